@@ -32,3 +32,17 @@ func unpad(in []byte) []byte {
 	}
 	return in[:len(in)-int(padding)]
 }
+
+// Pad applies PKCS #7 padding to a copy of the message. It is exported
+// for other CBC-based formats that share this padding scheme.
+func Pad(in []byte) []byte {
+	out := make([]byte, len(in), len(in)+aes.BlockSize)
+	copy(out, in)
+	return pad(out)
+}
+
+// Unpad removes PKCS #7 padding from the message, returning nil if
+// the padding is invalid.
+func Unpad(in []byte) []byte {
+	return unpad(in)
+}
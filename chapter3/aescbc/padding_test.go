@@ -135,3 +135,18 @@ func TestBadUnpad(t *testing.T) {
 		t.Fatal("Unpadding should fail.")
 	}
 }
+
+func TestExportedPad(t *testing.T) {
+	in := make([]byte, 3, 32)
+	copy(in, "AAA")
+	padded := Pad(in)
+
+	// Pad must not write into the spare capacity of its argument.
+	if in[:4][3] != 0 {
+		t.Fatal("Pad modified its input")
+	}
+
+	if !bytes.Equal(Unpad(padded), in) {
+		t.Fatal("Unpad should reverse Pad")
+	}
+}
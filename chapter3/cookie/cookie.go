@@ -0,0 +1,214 @@
+// Package cookie seals HTTP cookie values with AES-256-GCM. The cookie
+// name and its expiry time are authenticated as additional data, so a
+// value can't be moved to another cookie or have its lifetime
+// extended. A Codec holds several keys to support rotation: values are
+// sealed with the first key, and any of the keys may open them.
+//
+// A sealed value is the URL-safe base64 encoding of
+//
+//	expiry (64-bit big endian Unix time) || nonce || ciphertext
+//
+// Cookies that have been tampered with, were sealed under an unknown
+// key, or have expired are treated as though they were never sent.
+package cookie
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+)
+
+// DefaultMaxSize is the default limit on the size of an encoded cookie
+// value. Browsers generally limit a whole cookie to 4096 bytes.
+const DefaultMaxSize = 4000
+
+var (
+	// ErrNoKeys is returned when a Codec is created without keys.
+	ErrNoKeys = errors.New("cookie: no keys")
+
+	// ErrTooLarge is returned when an encoded value would exceed the
+	// Codec's maximum size.
+	ErrTooLarge = errors.New("cookie: value too large")
+
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("cookie: encryption failed")
+
+	// ErrInvalid is returned when a value can't be decoded, was
+	// tampered with, or has expired.
+	ErrInvalid = errors.New("cookie: invalid value")
+)
+
+var b64 = base64.RawURLEncoding
+
+// timeNow is used to get the current time, so that tests can control
+// the clock.
+var timeNow = time.Now
+
+// A Codec seals and opens cookie values.
+type Codec struct {
+	keys []cipher.AEAD
+
+	// MaxAge is how long a sealed value is valid for.
+	MaxAge time.Duration
+
+	// MaxSize is the largest encoded value that will be produced or
+	// accepted.
+	MaxSize int
+}
+
+// NewCodec returns a Codec that seals values for maxAge. The keys must
+// be AES-256 keys, in order of preference; the first key is used to
+// seal new values.
+func NewCodec(maxAge time.Duration, keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	c := &Codec{
+		MaxAge:  maxAge,
+		MaxSize: DefaultMaxSize,
+	}
+
+	for _, key := range keys {
+		if len(key) != aesgcm.KeySize {
+			return nil, aes.KeySizeError(len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, gcm)
+	}
+
+	return c, nil
+}
+
+// additionalData binds the cookie name and expiry to the value.
+func additionalData(name string, expiry []byte) []byte {
+	ad := make([]byte, 0, len(expiry)+len(name))
+	ad = append(ad, expiry...)
+	return append(ad, name...)
+}
+
+// Encode seals the value for the named cookie.
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	nonce, err := aesgcm.GenerateNonce()
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	out := make([]byte, 8, 8+len(nonce)+len(value)+c.keys[0].Overhead())
+	binary.BigEndian.PutUint64(out, uint64(timeNow().Add(c.MaxAge).Unix()))
+	out = append(out, nonce...)
+	out = c.keys[0].Seal(out, nonce, value, additionalData(name, out[:8]))
+
+	if b64.EncodedLen(len(out)) > c.MaxSize {
+		return "", ErrTooLarge
+	}
+	return b64.EncodeToString(out), nil
+}
+
+// Decode opens a value sealed for the named cookie.
+func (c *Codec) Decode(name, value string) ([]byte, error) {
+	if len(value) > c.MaxSize {
+		return nil, ErrInvalid
+	}
+
+	in, err := b64.DecodeString(value)
+	if err != nil || len(in) < 8+aesgcm.NonceSize {
+		return nil, ErrInvalid
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(in)), 0)
+	if !timeNow().Before(expiry) {
+		return nil, ErrInvalid
+	}
+
+	ad := additionalData(name, in[:8])
+	nonce := in[8 : 8+aesgcm.NonceSize]
+	for _, gcm := range c.keys {
+		out, err := gcm.Open(nil, nonce, in[8+aesgcm.NonceSize:], ad)
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	return nil, ErrInvalid
+}
+
+// Write seals the cookie's value and adds it to the response. If the
+// cookie doesn't have an expiry time, it is set to match the sealed
+// value. The cookie passed in isn't modified.
+func (c *Codec) Write(w http.ResponseWriter, cookie *http.Cookie) error {
+	value, err := c.Encode(cookie.Name, []byte(cookie.Value))
+	if err != nil {
+		return err
+	}
+
+	sealed := *cookie
+	sealed.Value = value
+	if sealed.Expires.IsZero() && sealed.MaxAge == 0 {
+		sealed.Expires = timeNow().Add(c.MaxAge)
+	}
+
+	http.SetCookie(w, &sealed)
+	return nil
+}
+
+// Read returns the opened value of the named cookie in the request. If
+// the cookie is missing, tampered with, or expired, it returns false.
+func (c *Codec) Read(r *http.Request, name string) ([]byte, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, false
+	}
+
+	value, err := c.Decode(name, cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+type contextKey struct{}
+
+// Middleware opens the named cookies on each request before passing it
+// to next. Handlers retrieve the opened values with Value; a cookie
+// that couldn't be opened is simply absent.
+func (c *Codec) Middleware(next http.Handler, names ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := map[string][]byte{}
+		for _, name := range names {
+			if value, ok := c.Read(r, name); ok {
+				values[name] = value
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, values)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Value returns a cookie value opened by the Middleware.
+func Value(r *http.Request, name string) ([]byte, bool) {
+	values, ok := r.Context().Value(contextKey{}).(map[string][]byte)
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := values[name]
+	return value, ok
+}
@@ -0,0 +1,159 @@
+package cookie
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+)
+
+var testValue = []byte("session=0123456789abcdef")
+
+func testCodec(t *testing.T) (*Codec, []byte) {
+	key, err := aesgcm.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c, err := NewCodec(time.Hour, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return c, key
+}
+
+func TestEncode(t *testing.T) {
+	c, _ := testCodec(t)
+
+	value, err := c.Encode("session", testValue)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := c.Decode("session", value)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testValue) {
+		t.Fatal("recovered value doesn't match original")
+	}
+
+	// The value is bound to the cookie name.
+	if _, err = c.Decode("admin", value); err != ErrInvalid {
+		t.Fatal("expected a value moved to another cookie to fail")
+	}
+
+	// Changing the expiry must break authentication.
+	raw, _ := b64.DecodeString(value)
+	raw[7]++
+	if _, err = c.Decode("session", b64.EncodeToString(raw)); err != ErrInvalid {
+		t.Fatal("expected a value with a modified expiry to fail")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c, _ := testCodec(t)
+
+	value, err := c.Encode("session", testValue)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	defer func() { timeNow = time.Now }()
+
+	if _, err = c.Decode("session", value); err != ErrInvalid {
+		t.Fatal("expected an expired value to fail")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, oldKey := testCodec(t)
+
+	newKey, err := aesgcm.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c, err := NewCodec(time.Hour, newKey, oldKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	value, err := old.Encode("session", testValue)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = c.Decode("session", value); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	value, err = c.Encode("session", testValue)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = old.Decode("session", value); err != ErrInvalid {
+		t.Fatal("expected a value sealed with the new key to fail with only the old key")
+	}
+
+	if _, err = NewCodec(time.Hour); err != ErrNoKeys {
+		t.Fatal("expected a codec without keys to fail")
+	}
+
+	if _, err = NewCodec(time.Hour, newKey[:16]); err == nil {
+		t.Fatal("expected a codec with a short key to fail")
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	c, _ := testCodec(t)
+	c.MaxSize = 64
+
+	if _, err := c.Encode("session", make([]byte, 64)); err != ErrTooLarge {
+		t.Fatal("expected an oversized value to fail")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c, _ := testCodec(t)
+
+	rec := httptest.NewRecorder()
+	err := c.Write(rec, &http.Cookie{Name: "session", Value: string(testValue)})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Expires.IsZero() {
+		t.Fatal("expected a single cookie with an expiry time")
+	}
+
+	var seen []byte
+	var found bool
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, found = Value(r, "session")
+	}), "session")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !found || !bytes.Equal(seen, testValue) {
+		t.Fatal("middleware didn't open the cookie")
+	}
+
+	// A tampered cookie is treated as absent rather than an error.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: cookies[0].Value[:len(cookies[0].Value)-2] + "AA"})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if found {
+		t.Fatal("tampered cookie should be absent")
+	}
+}
@@ -0,0 +1,224 @@
+// Package fernet implements the Fernet token format used by Python's
+// cryptography package. Fernet is AES-128-CBC with PKCS #7 padding,
+// authenticated with HMAC-SHA-256 in an encrypt-then-MAC construction,
+// much like the aescbc package; the token also carries a version byte
+// and the time it was created, so that old tokens can be rejected.
+//
+// A token is laid out as
+//
+//	version (0x80) || timestamp (64-bit big endian) || IV || ciphertext || HMAC
+//
+// and is encoded with URL-safe base64. A key is 32 bytes: a 16-byte
+// HMAC key followed by a 16-byte AES key, also URL-safe base64 encoded.
+package fernet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	aescbc "git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+const (
+	// KeySize is the size of a Fernet key.
+	KeySize = 32
+
+	version    = 0x80
+	headerSize = 1 + 8 + aes.BlockSize
+	macSize    = sha256.Size
+
+	// maxClockSkew is how far in the future a token's timestamp may
+	// be; this matches the Python implementation.
+	maxClockSkew = 60 * time.Second
+)
+
+var (
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("fernet: encryption failed")
+
+	// ErrInvalidToken is returned when a token can't be decrypted.
+	ErrInvalidToken = errors.New("fernet: invalid token")
+
+	// ErrExpired is returned when a token is older than the TTL, or
+	// was created too far in the future.
+	ErrExpired = errors.New("fernet: token has expired")
+
+	// ErrInvalidKey is returned when a key can't be decoded.
+	ErrInvalidKey = errors.New("fernet: invalid key")
+)
+
+var b64 = base64.URLEncoding
+
+// timeNow is used to get the current time, so that tests can control
+// the clock.
+var timeNow = time.Now
+
+// A Key contains the signing and encryption keys for Fernet tokens.
+type Key struct {
+	key []byte
+}
+
+func (k *Key) signingKey() []byte {
+	return k.key[:KeySize/2]
+}
+
+func (k *Key) encryptionKey() []byte {
+	return k.key[KeySize/2:]
+}
+
+// GenerateKey creates a new random key.
+func GenerateKey() (*Key, error) {
+	key, err := util.RandBytes(KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{key: key}, nil
+}
+
+// ParseKey decodes a base64-encoded key, as produced by Python's
+// Fernet.generate_key.
+func ParseKey(in string) (*Key, error) {
+	key, err := b64.DecodeString(in)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return &Key{key: key}, nil
+}
+
+// String returns the base64 encoding of the key.
+func (k *Key) String() string {
+	return b64.EncodeToString(k.key)
+}
+
+// Zero wipes the key.
+func (k *Key) Zero() {
+	util.Zero(k.key)
+}
+
+// encrypt builds a token from its parts.
+func (k *Key) encrypt(message []byte, ts int64, iv []byte) []byte {
+	pmessage := aescbc.Pad(message)
+
+	out := make([]byte, headerSize, headerSize+len(pmessage)+macSize)
+	out[0] = version
+	binary.BigEndian.PutUint64(out[1:], uint64(ts))
+	copy(out[9:], iv)
+
+	// NewCipher only returns an error with an invalid key size, and
+	// the key size is fixed.
+	c, _ := aes.NewCipher(k.encryptionKey())
+	cbc := cipher.NewCBCEncrypter(c, iv)
+	cbc.CryptBlocks(pmessage, pmessage)
+	out = append(out, pmessage...)
+
+	h := hmac.New(sha256.New, k.signingKey())
+	h.Write(out)
+	out = h.Sum(out)
+
+	token := make([]byte, b64.EncodedLen(len(out)))
+	b64.Encode(token, out)
+	return token
+}
+
+// Encrypt secures the message in a new token stamped with the current
+// time.
+func (k *Key) Encrypt(message []byte) ([]byte, error) {
+	iv, err := util.RandBytes(aes.BlockSize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	return k.encrypt(message, timeNow().Unix(), iv), nil
+}
+
+// decode checks the token's structure and authentication tag, and
+// returns the raw token and its timestamp.
+func (k *Key) decode(token []byte) ([]byte, int64, error) {
+	raw := make([]byte, b64.DecodedLen(len(token)))
+	n, err := b64.Decode(raw, token)
+	if err != nil {
+		return nil, 0, ErrInvalidToken
+	}
+	raw = raw[:n]
+
+	// A token must have a header, at least one block of
+	// ciphertext, and a MAC.
+	if len(raw) < headerSize+aes.BlockSize+macSize || raw[0] != version {
+		return nil, 0, ErrInvalidToken
+	}
+
+	if (len(raw)-headerSize-macSize)%aes.BlockSize != 0 {
+		return nil, 0, ErrInvalidToken
+	}
+
+	macStart := len(raw) - macSize
+	h := hmac.New(sha256.New, k.signingKey())
+	h.Write(raw[:macStart])
+	if !hmac.Equal(h.Sum(nil), raw[macStart:]) {
+		return nil, 0, ErrInvalidToken
+	}
+
+	return raw[:macStart], int64(binary.BigEndian.Uint64(raw[1:9])), nil
+}
+
+// decrypt recovers the message from an authenticated raw token.
+func (k *Key) decrypt(raw []byte) ([]byte, error) {
+	c, _ := aes.NewCipher(k.encryptionKey())
+	cbc := cipher.NewCBCDecrypter(c, raw[9:headerSize])
+
+	out := make([]byte, len(raw)-headerSize)
+	cbc.CryptBlocks(out, raw[headerSize:])
+
+	pt := aescbc.Unpad(out)
+	if pt == nil {
+		return nil, ErrInvalidToken
+	}
+	return pt, nil
+}
+
+// checkTTL rejects tokens older than the TTL, and tokens from too far
+// in the future. A zero TTL only checks the latter.
+func checkTTL(ts int64, ttl time.Duration) error {
+	now := timeNow()
+	created := time.Unix(ts, 0)
+	if created.After(now.Add(maxClockSkew)) {
+		return ErrExpired
+	}
+
+	if ttl > 0 && now.After(created.Add(ttl)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Decrypt authenticates and decrypts the token. If ttl is non-zero,
+// tokens older than ttl are rejected.
+func (k *Key) Decrypt(token []byte, ttl time.Duration) ([]byte, error) {
+	raw, ts, err := k.decode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkTTL(ts, ttl); err != nil {
+		return nil, err
+	}
+
+	return k.decrypt(raw)
+}
+
+// Timestamp returns the time the token was created. The token must
+// authenticate under the key.
+func (k *Key) Timestamp(token []byte) (time.Time, error) {
+	_, ts, err := k.decode(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
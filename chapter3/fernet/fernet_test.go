@@ -0,0 +1,228 @@
+package fernet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+// setTime fixes the clock used by the package, returning a function
+// that restores it.
+func setTime(t time.Time) func() {
+	timeNow = func() time.Time { return t }
+	return func() { timeNow = time.Now }
+}
+
+func hmacFor(key *Key, raw []byte) []byte {
+	h := hmac.New(sha256.New, key.signingKey())
+	h.Write(raw)
+	return h.Sum(nil)
+}
+
+// The generate and verify vectors from the Fernet specification.
+var (
+	specSecret = "cw_0x689RpI-jtRR7oE8h_eQsKImvJapLeSbXpwF4e4="
+	specToken  = []byte("gAAAAAAdwJ6wAAECAwQFBgcICQoLDA0ODy021cpGVWKZ_eEwCGM4BLLF_5CV9dOPmrhuVUPgJobwOz7JcbmrR64jVmpU4IwqDA==")
+	specIV     = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	specSource = []byte("hello")
+	specNow    = time.Date(1985, 10, 26, 1, 20, 0, 0, time.FixedZone("", -7*3600))
+)
+
+func TestSpecVectors(t *testing.T) {
+	key, err := ParseKey(specSecret)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if key.String() != specSecret {
+		t.Fatal("key doesn't encode to the original")
+	}
+
+	token := key.encrypt(specSource, specNow.Unix(), specIV)
+	if !bytes.Equal(token, specToken) {
+		t.Fatalf("have %s, want %s", token, specToken)
+	}
+
+	defer setTime(specNow.Add(time.Second))()
+	out, err := key.Decrypt(specToken, 60*time.Second)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, specSource) {
+		t.Fatal("recovered message doesn't match original")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token, err := key.Encrypt(testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := key.Decrypt(token, time.Minute)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	other, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = other.Decrypt(token, 0); err != ErrInvalidToken {
+		t.Fatal("expected decryption with the wrong key to fail")
+	}
+}
+
+func TestInvalidTokens(t *testing.T) {
+	key, err := ParseKey(specSecret)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	raw, _ := b64.DecodeString(string(specToken))
+	encode := func(raw []byte) []byte {
+		return []byte(b64.EncodeToString(raw))
+	}
+
+	badMAC := append([]byte{}, raw...)
+	badMAC[len(badMAC)-1] ^= 1
+
+	badVersion := append([]byte{}, raw...)
+	badVersion[0] = 0x81
+
+	cases := map[string][]byte{
+		"incorrect mac":  encode(badMAC),
+		"bad version":    encode(badVersion),
+		"too short":      encode(raw[:headerSize+macSize]),
+		"invalid base64": []byte("%%%%"),
+	}
+
+	defer setTime(specNow)()
+	for name, token := range cases {
+		if _, err = key.Decrypt(token, 0); err != ErrInvalidToken {
+			t.Fatalf("%s: expected decryption to fail", name)
+		}
+	}
+
+	// A token with valid MAC but bad padding is built by encrypting
+	// a full block and chopping off the padding block.
+	token := key.encrypt(bytes.Repeat([]byte("A"), 16), specNow.Unix(), specIV)
+	raw, _ = b64.DecodeString(string(token))
+	raw = raw[:headerSize+16]
+	h := hmacFor(key, raw)
+	if _, err = key.Decrypt(encode(append(raw, h...)), 0); err != ErrInvalidToken {
+		t.Fatal("expected decryption with bad padding to fail")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	key, err := ParseKey(specSecret)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	restore := setTime(specNow.Add(2 * time.Minute))
+	if _, err = key.Decrypt(specToken, time.Minute); err != ErrExpired {
+		t.Fatal("expected an expired token to fail")
+	}
+
+	// Without a TTL, an old token is accepted.
+	if _, err = key.Decrypt(specToken, 0); err != nil {
+		t.Fatalf("%v", err)
+	}
+	restore()
+
+	restore = setTime(specNow.Add(-2 * time.Minute))
+	if _, err = key.Decrypt(specToken, 0); err != ErrExpired {
+		t.Fatal("expected a token from the future to fail")
+	}
+	restore()
+
+	ts, err := key.Timestamp(specToken)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !ts.Equal(specNow) {
+		t.Fatal("timestamp doesn't match")
+	}
+}
+
+func TestMultiFernet(t *testing.T) {
+	oldKey, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	newKey, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = NewMultiFernet(); err != ErrNoKeys {
+		t.Fatal("expected a MultiFernet without keys to fail")
+	}
+
+	defer setTime(specNow)()
+	token, err := oldKey.Encrypt(testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	mf, err := NewMultiFernet(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := mf.Decrypt(token, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	setTime(specNow.Add(time.Hour))
+	rotated, err := mf.Rotate(token)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = oldKey.Decrypt(rotated, 0); err != ErrInvalidToken {
+		t.Fatal("rotated token should not decrypt with the old key")
+	}
+
+	out, err = newKey.Decrypt(rotated, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	ts, err := newKey.Timestamp(rotated)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !ts.Equal(specNow) {
+		t.Fatal("rotation should keep the original timestamp")
+	}
+}
@@ -0,0 +1,82 @@
+package fernet
+
+import (
+	"crypto/aes"
+	"errors"
+	"time"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+// ErrNoKeys is returned when a MultiFernet is created without keys.
+var ErrNoKeys = errors.New("fernet: no keys")
+
+// A MultiFernet supports key rotation. New tokens are always encrypted
+// with the first (primary) key, and tokens are decrypted with whichever
+// key authenticates them.
+type MultiFernet struct {
+	keys []*Key
+}
+
+// NewMultiFernet returns a MultiFernet using the keys in order of
+// preference; the first key is used for encryption.
+func NewMultiFernet(keys ...*Key) (*MultiFernet, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return &MultiFernet{keys: keys}, nil
+}
+
+// Encrypt secures the message with the primary key.
+func (m *MultiFernet) Encrypt(message []byte) ([]byte, error) {
+	return m.keys[0].Encrypt(message)
+}
+
+// find returns the key that authenticates the token, with the decoded
+// token and its timestamp.
+func (m *MultiFernet) find(token []byte) (*Key, []byte, int64, error) {
+	for _, k := range m.keys {
+		raw, ts, err := k.decode(token)
+		if err == nil {
+			return k, raw, ts, nil
+		}
+	}
+	return nil, nil, 0, ErrInvalidToken
+}
+
+// Decrypt tries each key in turn to decrypt the token. If ttl is
+// non-zero, tokens older than ttl are rejected.
+func (m *MultiFernet) Decrypt(token []byte, ttl time.Duration) ([]byte, error) {
+	k, raw, ts, err := m.find(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkTTL(ts, ttl); err != nil {
+		return nil, err
+	}
+
+	return k.decrypt(raw)
+}
+
+// Rotate re-encrypts the token with the primary key. The original
+// timestamp is kept, so rotating a token doesn't extend its lifetime.
+func (m *MultiFernet) Rotate(token []byte) ([]byte, error) {
+	k, raw, ts, err := m.find(token)
+	if err != nil {
+		return nil, err
+	}
+
+	pt, err := k.decrypt(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(pt)
+
+	iv, err := util.RandBytes(aes.BlockSize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	return m.keys[0].encrypt(pt, ts, iv), nil
+}
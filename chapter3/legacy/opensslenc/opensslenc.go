@@ -0,0 +1,211 @@
+// Package opensslenc reads and writes files in the format produced by
+// the `openssl enc` command with AES-256-CBC:
+//
+//	"Salted__" || salt (8 bytes) || AES-256-CBC ciphertext
+//
+// The key and IV are derived from a password and the salt, either with
+// OpenSSL's EVP_BytesToKey (the default before OpenSSL 1.1.1, and still
+// the default unless -pbkdf2 or -iter is given) or with PBKDF2.
+//
+// This format is NOT authenticated: an attacker can modify the
+// ciphertext undetected, and a wrong password is only noticed if the
+// padding happens to be invalid. It lives in the legacy tree for
+// interoperating with existing files; new data should use one of the
+// authenticated chapter 3 ciphersuites.
+package opensslenc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"hash"
+
+	aescbc "git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// SaltSize is the size of the salt in the file header.
+	SaltSize = 8
+
+	keySize = 32
+	ivSize  = aes.BlockSize
+)
+
+var magic = []byte("Salted__")
+
+var (
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("opensslenc: encryption failed")
+
+	// ErrDecrypt is returned when decryption fails. Because the
+	// format is unauthenticated, a successful decryption doesn't
+	// mean the password was right or the data is intact.
+	ErrDecrypt = errors.New("opensslenc: decryption failed")
+
+	// ErrOptions is returned when the key derivation options are
+	// incomplete or invalid.
+	ErrOptions = errors.New("opensslenc: invalid options")
+)
+
+// A KDF selects how the key and IV are derived from the password.
+type KDF int
+
+const (
+	// BytesToKey is OpenSSL's EVP_BytesToKey with an iteration count
+	// of one, as used by `openssl enc` without -pbkdf2.
+	BytesToKey KDF = iota
+
+	// PBKDF2 is used by `openssl enc -pbkdf2`.
+	PBKDF2
+)
+
+// Options control key derivation. They must match the options given to
+// `openssl enc` when the file was created.
+type Options struct {
+	KDF KDF
+
+	// Digest is the hash function passed with -md.
+	Digest func() hash.Hash
+
+	// Iterations is the PBKDF2 iteration count passed with -iter.
+	// It is ignored for BytesToKey.
+	Iterations int
+}
+
+// DefaultOptions matches `openssl enc -aes-256-cbc -pbkdf2` in OpenSSL
+// 1.1.1 and later.
+var DefaultOptions = &Options{
+	KDF:        PBKDF2,
+	Digest:     sha256.New,
+	Iterations: 10000,
+}
+
+// LegacyOptions matches `openssl enc -aes-256-cbc` in OpenSSL 1.0.2 and
+// earlier, which used EVP_BytesToKey with MD5. Later versions default
+// to SHA-256 instead; use -md md5 to produce files for this.
+var LegacyOptions = &Options{
+	KDF:    BytesToKey,
+	Digest: md5.New,
+}
+
+// check verifies that the options name a known KDF and a digest, and
+// that PBKDF2 has a positive iteration count.
+func (opts *Options) check() error {
+	if opts.Digest == nil {
+		return ErrOptions
+	}
+
+	switch opts.KDF {
+	case BytesToKey:
+	case PBKDF2:
+		if opts.Iterations < 1 {
+			return ErrOptions
+		}
+	default:
+		return ErrOptions
+	}
+	return nil
+}
+
+// bytesToKey implements EVP_BytesToKey with an iteration count of one:
+// each block is the digest of the previous block, the password, and
+// the salt.
+func bytesToKey(digest func() hash.Hash, pass, salt []byte, size int) []byte {
+	var out, prev []byte
+	for len(out) < size {
+		h := digest()
+		h.Write(prev)
+		h.Write(pass)
+		h.Write(salt)
+		prev = h.Sum(nil)
+		out = append(out, prev...)
+	}
+	util.Zero(out[size:])
+	return out[:size]
+}
+
+// deriveKey returns the AES key and IV for the password and salt.
+func (opts *Options) deriveKey(pass, salt []byte) (key, iv []byte) {
+	var buf []byte
+	switch opts.KDF {
+	case PBKDF2:
+		buf = pbkdf2.Key(pass, salt, opts.Iterations, keySize+ivSize, opts.Digest)
+	case BytesToKey:
+		buf = bytesToKey(opts.Digest, pass, salt, keySize+ivSize)
+	}
+	return buf[:keySize], buf[keySize:]
+}
+
+// Encrypt produces a file that can be decrypted with `openssl enc -d
+// -aes-256-cbc` and the options matching opts. If opts is nil,
+// DefaultOptions is used; invalid options return ErrOptions.
+func Encrypt(pass, message []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	salt, err := util.RandBytes(SaltSize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	key, iv := opts.deriveKey(pass, salt)
+	defer util.Zero(key)
+
+	// NewCipher only returns an error with an invalid key size, and
+	// the key size is fixed.
+	c, _ := aes.NewCipher(key)
+	pmessage := aescbc.Pad(message)
+	cbc := cipher.NewCBCEncrypter(c, iv)
+	cbc.CryptBlocks(pmessage, pmessage)
+
+	out := make([]byte, 0, len(magic)+SaltSize+len(pmessage))
+	out = append(out, magic...)
+	out = append(out, salt...)
+	return append(out, pmessage...), nil
+}
+
+// Decrypt recovers a message from a file produced by `openssl enc
+// -aes-256-cbc`. If opts is nil, DefaultOptions is used; invalid
+// options return ErrOptions.
+func Decrypt(pass, message []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	headerSize := len(magic) + SaltSize
+	if len(message) < headerSize+aes.BlockSize || !bytes.Equal(message[:len(magic)], magic) {
+		return nil, ErrDecrypt
+	}
+
+	if (len(message)-headerSize)%aes.BlockSize != 0 {
+		return nil, ErrDecrypt
+	}
+
+	key, iv := opts.deriveKey(pass, message[len(magic):headerSize])
+	defer util.Zero(key)
+
+	c, _ := aes.NewCipher(key)
+	out := make([]byte, len(message)-headerSize)
+	cbc := cipher.NewCBCDecrypter(c, iv)
+	cbc.CryptBlocks(out, message[headerSize:])
+
+	pt := aescbc.Unpad(out)
+	if pt == nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
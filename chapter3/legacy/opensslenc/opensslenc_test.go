@@ -0,0 +1,133 @@
+package opensslenc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	testMessage = []byte("do not go gentle into that good night")
+	testPass    = []byte("hunter2")
+)
+
+type opensslVector struct {
+	Args string
+	Opts *Options
+	File string
+}
+
+// These files were produced by OpenSSL 3.0 with
+//
+//	openssl enc -aes-256-cbc $args -pass pass:hunter2
+var opensslVectors = []opensslVector{
+	{
+		Args: "-pbkdf2",
+		Opts: DefaultOptions,
+		File: "53616c7465645f5f2a214e4a3b018e3be391527290224d602eaa4c9943ad9a194bbbd616d1654bcdf20562ab6709cd5b33e4870550aa9bb57a6e70e2d549355a",
+	},
+	{
+		Args: "-pbkdf2 -iter 1000 -md sha512",
+		Opts: &Options{KDF: PBKDF2, Digest: sha512.New, Iterations: 1000},
+		File: "53616c7465645f5fa7470aaddf5ef8534fe876c4abae1627c330284a8155860c20a6b1ad57290b869525d1f4e1347ab1977b4ae93207cf56befdd86c3c3fe37b",
+	},
+	{
+		Args: "-md md5",
+		Opts: LegacyOptions,
+		File: "53616c7465645f5f5e2d034f2d34aaaeff55a066b632065348e319a33239249a4798c5922a92089e00a75d11c7f52cf9000af37a16916bd5f258379a94f44fe6",
+	},
+	{
+		Args: "-md sha256",
+		Opts: &Options{KDF: BytesToKey, Digest: sha256.New},
+		File: "53616c7465645f5f714400892394053adb6c1d4f39c2391a103ea224c2cc071f409bc0003e06077ca80417f788f3ec95059a17e43d9604df354aa7548b648013",
+	},
+}
+
+func TestOpenSSLVectors(t *testing.T) {
+	for _, v := range opensslVectors {
+		in, _ := hex.DecodeString(v.File)
+		out, err := Decrypt(testPass, in, v.Opts)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Args, err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatalf("%s: recovered message doesn't match original", v.Args)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	for _, v := range opensslVectors {
+		out, err := Encrypt(testPass, testMessage, v.Opts)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.HasPrefix(out, []byte("Salted__")) {
+			t.Fatal("missing file header")
+		}
+
+		out, err = Decrypt(testPass, out, v.Opts)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Args, err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatalf("%s: recovered message doesn't match original", v.Args)
+		}
+	}
+}
+
+func TestBytesToKey(t *testing.T) {
+	// With MD5 the first 16 bytes are MD5(pass || salt).
+	salt := []byte("saltsalt")
+	h := md5.Sum(append(append([]byte{}, testPass...), salt...))
+	key := bytesToKey(md5.New, testPass, salt, 48)
+	if len(key) != 48 || !bytes.Equal(key[:16], h[:]) {
+		t.Fatal("EVP_BytesToKey output is wrong")
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	in, _ := hex.DecodeString(opensslVectors[0].File)
+
+	if _, err := Decrypt(testPass, in[:20], nil); err != ErrDecrypt {
+		t.Fatal("expected a short file to fail")
+	}
+
+	if _, err := Decrypt(testPass, in[:len(in)-1], nil); err != ErrDecrypt {
+		t.Fatal("expected a file that isn't a multiple of the block size to fail")
+	}
+
+	bad := append([]byte{}, in...)
+	bad[0] = 'X'
+	if _, err := Decrypt(testPass, bad, nil); err != ErrDecrypt {
+		t.Fatal("expected a file without the header to fail")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	in, _ := hex.DecodeString(opensslVectors[0].File)
+
+	bad := []*Options{
+		{},
+		{KDF: PBKDF2, Iterations: 10000},
+		{KDF: PBKDF2, Digest: sha256.New},
+		{KDF: PBKDF2, Digest: sha256.New, Iterations: -1},
+		{KDF: KDF(2), Digest: sha256.New},
+	}
+
+	for i, opts := range bad {
+		if _, err := Encrypt(testPass, testMessage, opts); err != ErrOptions {
+			t.Fatalf("case %d: expected ErrOptions, have %v", i, err)
+		}
+
+		if _, err := Decrypt(testPass, in, opts); err != ErrOptions {
+			t.Fatalf("case %d: expected ErrOptions, have %v", i, err)
+		}
+	}
+}
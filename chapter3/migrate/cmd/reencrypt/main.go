@@ -0,0 +1,83 @@
+// reencrypt moves files encrypted with one of the chapter 3
+// ciphersuites to another suite and key.
+//
+// Usage:
+//
+//	reencrypt -from aescbc -fromkey old.key -to aesgcm -tokey new.key \
+//	    [-journal progress.journal] paths...
+//
+// Keys are read as raw bytes from the named files. Directories are
+// walked recursively. If the journal is given, it records each file
+// as it is completed, and running the same command again resumes
+// where the previous run stopped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/migrate"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+func readKey(path string) []byte {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+func suite(name string) *migrate.Suite {
+	s, err := migrate.Lookup(name)
+	if err != nil {
+		log.Fatalf("%v: %s", err, name)
+	}
+	return s
+}
+
+func main() {
+	from := flag.String("from", "aescbc", "source ciphersuite")
+	fromKey := flag.String("fromkey", "", "file containing the source key")
+	to := flag.String("to", "aesgcm", "target ciphersuite")
+	toKey := flag.String("tokey", "", "file containing the target key")
+	journal := flag.String("journal", "", "progress journal for resuming")
+	flag.Parse()
+
+	if *fromKey == "" || *toKey == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "reencrypt: source key, target key, and at least one path are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	oldKey := readKey(*fromKey)
+	newKey := readKey(*toKey)
+
+	m, err := migrate.NewMigrator(suite(*from), oldKey, suite(*to), newKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var j *migrate.Journal
+	if *journal != "" {
+		j, err = migrate.OpenJournal(*journal)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer j.Close()
+	}
+
+	err = m.Files(j, flag.Args()...)
+	util.Zero(oldKey)
+	util.Zero(newKey)
+	if err != nil {
+		if j != nil {
+			log.Printf("%d files completed; rerun with the same journal to resume", j.Len())
+			j.Close()
+		}
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package migrate
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix marks a journal entry naming a temporary file rather than
+// a completed one. An absolute path can't begin with it.
+const tempPrefix = "temp:"
+
+// A Journal records the files that have been migrated. Each completed
+// file is appended to the journal as an absolute path on its own line,
+// and the journal is synced after every entry. Reopening the journal
+// after an interruption restores the set of completed files, so that
+// they are skipped on the next run.
+//
+// The journal also records each temporary file the migration creates,
+// on a line starting with "temp:", so that one left behind by an
+// interruption can be told apart from the user's own files.
+type Journal struct {
+	path  string
+	file  *os.File
+	done  map[string]bool
+	temps map[string]bool
+}
+
+// OpenJournal opens the journal at path, creating it if needed, and
+// loads the files that have already been completed.
+func OpenJournal(path string) (*Journal, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		path:  path,
+		file:  f,
+		done:  map[string]bool{},
+		temps: map[string]bool{},
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, tempPrefix) {
+			j.temps[strings.TrimPrefix(line, tempPrefix)] = true
+		} else {
+			j.done[line] = true
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Skip returns true if the file has already been migrated, or if it
+// is the journal itself.
+func (j *Journal) Skip(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	return path == j.path || j.done[path]
+}
+
+// Record marks the file as migrated.
+func (j *Journal) Record(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if err = j.write(path); err != nil {
+		return err
+	}
+
+	j.done[path] = true
+	return nil
+}
+
+// recordTemp notes a temporary file before it is written.
+func (j *Journal) recordTemp(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if err = j.write(tempPrefix + path); err != nil {
+		return err
+	}
+
+	j.temps[path] = true
+	return nil
+}
+
+// isTemp returns true if the path is a temporary file recorded in the
+// journal.
+func (j *Journal) isTemp(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	return j.temps[path]
+}
+
+// write appends an entry to the journal and syncs it.
+func (j *Journal) write(entry string) error {
+	if _, err := j.file.WriteString(entry + "\n"); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Len returns the number of files recorded in the journal.
+func (j *Journal) Len() int {
+	return len(j.done)
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
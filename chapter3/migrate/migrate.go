@@ -0,0 +1,318 @@
+// Package migrate moves ciphertexts between the chapter 3 ciphersuites.
+// A message is decrypted with a source suite and key, re-encrypted with
+// a target suite and key, and the new ciphertext is checked by
+// decrypting it again before it is accepted.
+//
+// Files are rewritten atomically: the new ciphertext is written to a
+// temporary file in the same directory, synced, and renamed over the
+// original, and the directory is synced after the rename. Progress is
+// recorded in a Journal so that an interrupted migration can be resumed
+// without touching files that were already moved to the new suite. A
+// file that was renamed into place but not yet recorded when the
+// migration stopped already decrypts with the target suite and key, so
+// it is recognised and left alone. The journal also records each
+// temporary file before it is written, and a temporary file left behind
+// by an interrupted run is removed on the next one; no other file is
+// ever deleted.
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	aescbc "git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	aesctr "git.metacircular.net/kyle/gocrypto/chapter3/aesctr"
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+	nacl "git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+var (
+	// ErrUnknownSuite is returned when a suite name isn't recognised.
+	ErrUnknownSuite = errors.New("migrate: unknown ciphersuite")
+
+	// ErrKeySize is returned when a key is the wrong size for its
+	// suite.
+	ErrKeySize = errors.New("migrate: invalid key size")
+
+	// ErrVerify is returned when a re-encrypted message doesn't
+	// decrypt to the original plaintext.
+	ErrVerify = errors.New("migrate: verification of new ciphertext failed")
+)
+
+// A Suite describes one of the chapter 3 ciphersuites. All of the
+// suites take their key as a byte slice here; the NaCl suite copies it
+// into an array.
+type Suite struct {
+	Name    string
+	KeySize int
+	Encrypt func(key, message []byte) ([]byte, error)
+	Decrypt func(key, message []byte) ([]byte, error)
+}
+
+func naclKey(key []byte) *[nacl.KeySize]byte {
+	k := new([nacl.KeySize]byte)
+	copy(k[:], key)
+	return k
+}
+
+// The suites that can be migrated between.
+var (
+	AESCBC = &Suite{
+		Name:    "aescbc",
+		KeySize: aescbc.KeySize,
+		Encrypt: aescbc.Encrypt,
+		Decrypt: aescbc.Decrypt,
+	}
+
+	AESCTR = &Suite{
+		Name:    "aesctr",
+		KeySize: aesctr.KeySize,
+		Encrypt: aesctr.Encrypt,
+		Decrypt: aesctr.Decrypt,
+	}
+
+	AESGCM = &Suite{
+		Name:    "aesgcm",
+		KeySize: aesgcm.KeySize,
+		Encrypt: aesgcm.Encrypt,
+		Decrypt: aesgcm.Decrypt,
+	}
+
+	NaCl = &Suite{
+		Name:    "nacl",
+		KeySize: nacl.KeySize,
+		Encrypt: func(key, message []byte) ([]byte, error) {
+			k := naclKey(key)
+			defer util.Zero(k[:])
+			return nacl.Encrypt(k, message)
+		},
+		Decrypt: func(key, message []byte) ([]byte, error) {
+			k := naclKey(key)
+			defer util.Zero(k[:])
+			return nacl.Decrypt(k, message)
+		},
+	}
+)
+
+var suites = []*Suite{AESCBC, AESCTR, AESGCM, NaCl}
+
+// Lookup returns the suite with the given name.
+func Lookup(name string) (*Suite, error) {
+	for _, s := range suites {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, ErrUnknownSuite
+}
+
+// A Migrator holds the source and target suites and keys.
+type Migrator struct {
+	From    *Suite
+	FromKey []byte
+	To      *Suite
+	ToKey   []byte
+}
+
+// NewMigrator checks that the keys are the right size for their suites
+// and returns a new Migrator.
+func NewMigrator(from *Suite, fromKey []byte, to *Suite, toKey []byte) (*Migrator, error) {
+	if from == nil || to == nil {
+		return nil, ErrUnknownSuite
+	}
+
+	if len(fromKey) != from.KeySize || len(toKey) != to.KeySize {
+		return nil, ErrKeySize
+	}
+
+	return &Migrator{
+		From:    from,
+		FromKey: fromKey,
+		To:      to,
+		ToKey:   toKey,
+	}, nil
+}
+
+// Reencrypt decrypts the message with the source suite and encrypts
+// it with the target suite. The new ciphertext is decrypted with the
+// target suite and compared against the plaintext before it is
+// returned.
+func (m *Migrator) Reencrypt(message []byte) ([]byte, error) {
+	pt, err := m.From.Decrypt(m.FromKey, message)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(pt)
+
+	out, err := m.To.Encrypt(m.ToKey, pt)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := m.To.Decrypt(m.ToKey, out)
+	if err != nil {
+		return nil, ErrVerify
+	}
+	defer util.Zero(check)
+
+	if !bytes.Equal(check, pt) {
+		return nil, ErrVerify
+	}
+
+	return out, nil
+}
+
+// migrated returns true if the message already decrypts with the
+// target suite and key.
+func (m *Migrator) migrated(message []byte) bool {
+	pt, err := m.To.Decrypt(m.ToKey, message)
+	if err != nil {
+		return false
+	}
+	util.Zero(pt)
+	return true
+}
+
+// tempSuffix marks the temporary files written by writeFile.
+const tempSuffix = ".migrate"
+
+// syncDir syncs a directory, so that a rename in it is durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeFile atomically replaces the file at path with data. The data
+// is written to a temporary file in the same directory, which is
+// synced and then renamed over the original; the directory is synced
+// after the rename. If a journal is provided, the temporary file is
+// recorded in it before anything is written.
+func writeFile(j *Journal, path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+
+	name := tmp.Name()
+	if j != nil {
+		err = j.recordTemp(name)
+	}
+
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(name, mode)
+	}
+
+	if err == nil {
+		err = os.Rename(name, path)
+	}
+
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// File re-encrypts a single file in place. A file that already
+// decrypts with the target suite and key is left alone.
+func (m *Migrator) File(path string) error {
+	return m.file(nil, path)
+}
+
+func (m *Migrator) file(j *Journal, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, err := m.Reencrypt(in)
+	if err != nil {
+		if m.migrated(in) {
+			return nil
+		}
+		return err
+	}
+
+	return writeFile(j, path, out, fi.Mode().Perm())
+}
+
+// Files re-encrypts each of the named paths. Directories are walked,
+// and every regular file under them is migrated. If a journal is
+// provided, files already recorded in it are skipped, each file is
+// recorded once it has been migrated, and temporary files recorded in
+// it by an interrupted run are removed. Migration stops at the first
+// failure.
+func (m *Migrator) Files(j *Journal, paths ...string) error {
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			if j != nil && j.isTemp(path) {
+				return os.Remove(path)
+			}
+
+			if j != nil && j.Skip(path) {
+				return nil
+			}
+
+			if err = m.file(j, path); err != nil {
+				return &PathError{Path: path, Err: err}
+			}
+
+			if j != nil {
+				return j.Record(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A PathError records the file that failed to migrate.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return "migrate: " + e.Path + ": " + e.Err.Error()
+}
@@ -0,0 +1,262 @@
+package migrate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	aescbc "git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+func testMigrator(t *testing.T) *Migrator {
+	cbcKey, err := aescbc.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	gcmKey, err := aesgcm.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m, err := NewMigrator(AESCBC, cbcKey, AESGCM, gcmKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return m
+}
+
+func TestReencrypt(t *testing.T) {
+	for _, from := range suites {
+		for _, to := range suites {
+			fromKey, err := util.RandBytes(from.KeySize)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			toKey, err := util.RandBytes(to.KeySize)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			m, err := NewMigrator(from, fromKey, to, toKey)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			ct, err := from.Encrypt(fromKey, testMessage)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			ct, err = m.Reencrypt(ct)
+			if err != nil {
+				t.Fatalf("%s -> %s: %v", from.Name, to.Name, err)
+			}
+
+			pt, err := to.Decrypt(toKey, ct)
+			if err != nil {
+				t.Fatalf("%s -> %s: %v", from.Name, to.Name, err)
+			}
+
+			if !bytes.Equal(pt, testMessage) {
+				t.Fatalf("%s -> %s: recovered message doesn't match original", from.Name, to.Name)
+			}
+		}
+	}
+}
+
+func TestBadMigrator(t *testing.T) {
+	if _, err := Lookup("rot13"); err == nil {
+		t.Fatal("expected unknown suite to fail lookup")
+	}
+
+	if _, err := NewMigrator(AESCBC, make([]byte, 32), AESGCM, make([]byte, 32)); err == nil {
+		t.Fatal("expected migrator creation to fail with a short key")
+	}
+
+	m := testMigrator(t)
+	if _, err := m.Reencrypt(testMessage); err == nil {
+		t.Fatal("expected re-encryption of a bad ciphertext to fail")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	m := testMigrator(t)
+
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	files := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "sub", "b"),
+		filepath.Join(dir, "sub", "c"),
+	}
+
+	for _, path := range files {
+		ct, err := AESCBC.Encrypt(m.FromKey, testMessage)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if err = ioutil.WriteFile(path, ct, 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// The last file is garbage, so the first run should stop there
+	// after migrating the others.
+	if err = ioutil.WriteFile(files[2], testMessage, 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	journalPath := filepath.Join(dir, "journal")
+	j, err := OpenJournal(journalPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = m.Files(j, dir); err == nil {
+		t.Fatal("expected migration to fail on a bad file")
+	}
+	j.Close()
+
+	ct, err := AESCBC.Encrypt(m.FromKey, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = ioutil.WriteFile(files[2], ct, 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Resuming must not touch the files that are already migrated;
+	// if it did, they would fail to decrypt with the source key.
+	j, err = OpenJournal(journalPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer j.Close()
+
+	if j.Len() != 2 {
+		t.Fatalf("expected 2 files in the journal, have %d", j.Len())
+	}
+
+	if err = m.Files(j, dir); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, path := range files {
+		ct, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		pt, err := AESGCM.Decrypt(m.ToKey, ct)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		if !bytes.Equal(pt, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+}
+
+// TestResumeAfterCrash simulates a run that stopped after a file was
+// renamed into place but before it was recorded in the journal, and
+// left a temporary file behind for another. A user's file that happens
+// to look like a temporary file must be migrated, not removed.
+func TestResumeAfterCrash(t *testing.T) {
+	m := testMigrator(t)
+
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "b"),
+		filepath.Join(dir, ".notes"+tempSuffix),
+	}
+	for _, path := range files {
+		ct, err := AESCBC.Encrypt(m.FromKey, testMessage)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if err = ioutil.WriteFile(path, ct, 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// The first file was migrated, but the journal never saw it.
+	if err = m.File(files[0]); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	j, err := OpenJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The second was interrupted while writing its temporary file.
+	tmp := filepath.Join(dir, ".b.123456"+tempSuffix)
+	if err = j.recordTemp(tmp); err != nil {
+		t.Fatalf("%v", err)
+	}
+	j.Close()
+
+	if err = ioutil.WriteFile(tmp, testMessage[:8], 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	j, err = OpenJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer j.Close()
+
+	if err = m.Files(j, dir); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if j.Len() != len(files) {
+		t.Fatalf("expected %d files in the journal, have %d", len(files), j.Len())
+	}
+
+	if _, err = os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temporary file should have been removed")
+	}
+
+	for _, path := range files {
+		ct, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		pt, err := AESGCM.Decrypt(m.ToKey, ct)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		if !bytes.Equal(pt, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+}
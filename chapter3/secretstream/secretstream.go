@@ -0,0 +1,272 @@
+// Package secretstream implements libsodium's
+// crypto_secretstream_xchacha20poly1305 API, for decrypting (and
+// producing) streams that interoperate with libsodium.
+//
+// A stream starts with a 24-byte header, which is sent alongside the
+// encrypted messages. Each message is encrypted with ChaCha20-Poly1305
+// under a subkey derived from the key and header, and carries a tag
+// that marks message boundaries, key rotation, or the end of the
+// stream. Messages must be decrypted in the order they were encrypted;
+// reordered, dropped, or duplicated messages fail to decrypt.
+//
+// Each encrypted message is
+//
+//	encrypted tag (1 byte) || ciphertext || Poly1305 MAC (16 bytes)
+package secretstream
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+const (
+	// KeySize is the size of a secretstream key.
+	KeySize = 32
+
+	// HeaderSize is the size of the stream header.
+	HeaderSize = 24
+
+	// Overhead is the number of bytes added to each message.
+	Overhead = 1 + poly1305.TagSize
+
+	counterSize = 4
+	inonceSize  = 8
+)
+
+// Message tags, as defined by libsodium.
+const (
+	// TagMessage is the tag for an ordinary message.
+	TagMessage byte = 0
+
+	// TagPush marks the end of a set of messages, without ending the
+	// stream.
+	TagPush byte = 1
+
+	// TagRekey rotates the key after the message.
+	TagRekey byte = 2
+
+	// TagFinal marks the last message in the stream. It also rotates
+	// the key.
+	TagFinal = TagPush | TagRekey
+)
+
+var (
+	// ErrInvalidHeader is returned when a stream header is the wrong
+	// size.
+	ErrInvalidHeader = errors.New("secretstream: invalid header")
+
+	// ErrDecrypt is returned when a message fails to decrypt.
+	ErrDecrypt = errors.New("secretstream: decryption failed")
+
+	// ErrFinished is returned when reading past a final message.
+	ErrFinished = errors.New("secretstream: stream has ended")
+)
+
+// GenerateKey creates a new random secretstream key.
+func GenerateKey() (*[KeySize]byte, error) {
+	key := new([KeySize]byte)
+	_, err := io.ReadFull(rand.Reader, key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// state is libsodium's crypto_secretstream_xchacha20poly1305_state:
+// the subkey and a 96-bit nonce made up of a 32-bit little-endian
+// counter and a 64-bit internal nonce.
+type state struct {
+	key   [KeySize]byte
+	nonce [chacha20.NonceSize]byte
+}
+
+func newState(key *[KeySize]byte, header []byte) *state {
+	s := &state{}
+	subkey, _ := chacha20.HChaCha20(key[:], header[:16])
+	copy(s.key[:], subkey)
+	util.Zero(subkey)
+	copy(s.nonce[counterSize:], header[16:])
+	s.resetCounter()
+	return s
+}
+
+func (s *state) resetCounter() {
+	binary.LittleEndian.PutUint32(s.nonce[:counterSize], 1)
+}
+
+func (s *state) cipher(counter uint32) *chacha20.Cipher {
+	c, _ := chacha20.NewUnauthenticatedCipher(s.key[:], s.nonce[:])
+	c.SetCounter(counter)
+	return c
+}
+
+// rekey replaces the key and internal nonce with the ChaCha20
+// encryption of themselves, and resets the counter.
+func (s *state) rekey() {
+	var buf [KeySize + inonceSize]byte
+	copy(buf[:], s.key[:])
+	copy(buf[KeySize:], s.nonce[counterSize:])
+
+	s.cipher(0).XORKeyStream(buf[:], buf[:])
+	copy(s.key[:], buf[:KeySize])
+	copy(s.nonce[counterSize:], buf[KeySize:])
+	util.Zero(buf[:])
+	s.resetCounter()
+}
+
+// mac computes the Poly1305 tag over the additional data (padded to 16
+// bytes), the 64-byte block holding the encrypted tag, the ciphertext,
+// and their lengths. libsodium pads the ciphertext with
+// (0x10 - 64 + mlen) & 0xf zero bytes, which isn't a pad to a 16-byte
+// boundary; it's reproduced here for compatibility.
+func (s *state) mac(block, ad, ct []byte) []byte {
+	var polyKey [32]byte
+	s.cipher(0).XORKeyStream(polyKey[:], polyKey[:])
+	defer util.Zero(polyKey[:])
+
+	var pad [16]byte
+	h := poly1305.New(&polyKey)
+	h.Write(ad)
+	h.Write(pad[:(16-len(ad)%16)%16])
+	h.Write(block)
+	h.Write(ct)
+	h.Write(pad[:(16-len(block)+len(ct))&0xf])
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(block)+len(ct)))
+	h.Write(lengths[:])
+	return h.Sum(nil)
+}
+
+// advance mixes the MAC into the internal nonce, increments the
+// counter, and rekeys if the tag asks for it or the counter wrapped.
+func (s *state) advance(mac []byte, tag byte) {
+	for i := 0; i < inonceSize; i++ {
+		s.nonce[counterSize+i] ^= mac[i]
+	}
+
+	counter := binary.LittleEndian.Uint32(s.nonce[:counterSize]) + 1
+	binary.LittleEndian.PutUint32(s.nonce[:counterSize], counter)
+	if tag&TagRekey != 0 || counter == 0 {
+		s.rekey()
+	}
+}
+
+func (s *state) zero() {
+	util.Zero(s.key[:])
+	util.Zero(s.nonce[:])
+}
+
+// An Encryptor encrypts a stream of messages.
+type Encryptor struct {
+	s *state
+}
+
+// NewEncryptor starts a new stream, returning the encryptor and the
+// header that must be sent to the recipient.
+func NewEncryptor(key *[KeySize]byte) (*Encryptor, []byte, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(rand.Reader, header); err != nil {
+		return nil, nil, err
+	}
+
+	return &Encryptor{s: newState(key, header)}, header, nil
+}
+
+// Push encrypts the next message in the stream with the given tag and
+// additional data. The result is Overhead bytes longer than the
+// message.
+func (e *Encryptor) Push(message, ad []byte, tag byte) []byte {
+	var block [64]byte
+	block[0] = tag
+	e.s.cipher(1).XORKeyStream(block[:], block[:])
+
+	out := make([]byte, 1+len(message), Overhead+len(message))
+	out[0] = block[0]
+	e.s.cipher(2).XORKeyStream(out[1:], message)
+
+	mac := e.s.mac(block[:], ad, out[1:])
+	out = append(out, mac...)
+	e.s.advance(mac, tag)
+	return out
+}
+
+// Rekey explicitly rotates the key. The decryptor must call Rekey at
+// the same point in the stream.
+func (e *Encryptor) Rekey() {
+	e.s.rekey()
+}
+
+// Close zeroes the stream state.
+func (e *Encryptor) Close() {
+	e.s.zero()
+}
+
+// A Decryptor decrypts a stream of messages.
+type Decryptor struct {
+	s        *state
+	finished bool
+}
+
+// NewDecryptor starts decrypting the stream with the given header.
+func NewDecryptor(key *[KeySize]byte, header []byte) (*Decryptor, error) {
+	if len(header) != HeaderSize {
+		return nil, ErrInvalidHeader
+	}
+
+	return &Decryptor{s: newState(key, header)}, nil
+}
+
+// Pull decrypts the next message in the stream, returning the message
+// and its tag. Once a message tagged TagFinal has been read, further
+// calls return ErrFinished.
+func (d *Decryptor) Pull(in, ad []byte) ([]byte, byte, error) {
+	if d.finished {
+		return nil, 0, ErrFinished
+	}
+
+	if len(in) < Overhead {
+		return nil, 0, ErrDecrypt
+	}
+
+	var block [64]byte
+	block[0] = in[0]
+	d.s.cipher(1).XORKeyStream(block[:], block[:])
+	tag := block[0]
+	block[0] = in[0]
+
+	ct := in[1 : len(in)-poly1305.TagSize]
+	mac := d.s.mac(block[:], ad, ct)
+	if subtle.ConstantTimeCompare(mac, in[len(in)-poly1305.TagSize:]) != 1 {
+		return nil, 0, ErrDecrypt
+	}
+
+	out := make([]byte, len(ct))
+	d.s.cipher(2).XORKeyStream(out, ct)
+	d.s.advance(mac, tag)
+
+	if tag == TagFinal {
+		d.finished = true
+	}
+	return out, tag, nil
+}
+
+// Rekey explicitly rotates the key, matching a call to Rekey by the
+// encryptor.
+func (d *Decryptor) Rekey() {
+	d.s.rekey()
+}
+
+// Close zeroes the stream state.
+func (d *Decryptor) Close() {
+	d.s.zero()
+}
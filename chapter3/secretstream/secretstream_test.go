@@ -0,0 +1,183 @@
+package secretstream
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+type streamVector struct {
+	Message []byte
+	AD      []byte
+	Tag     byte
+	Rekey   bool // call Rekey before this message
+	Out     string
+}
+
+func longMessage() []byte {
+	m := make([]byte, 300)
+	for i := range m {
+		m[i] = byte(i % 251)
+	}
+	return m
+}
+
+// This stream was produced by libsodium 1.0.18 with the key 00 01 ... 1f
+// using crypto_secretstream_xchacha20poly1305_push, with an explicit
+// crypto_secretstream_xchacha20poly1305_rekey before the fifth message.
+var (
+	testHeader = "6d4f5cd2bfce657f980fbfacdf6ddde8a553a05c708753d3"
+	testStream = []streamVector{
+		{
+			Message: testMessage,
+			Tag:     TagMessage,
+			Out:     "a41f19272094a7944fe36b57db55fd8f81a8225e900eaee074ef568fe93aca96f6aa5967672bacea606a0e2f5c40551fb91dc3fd8e9f",
+		},
+		{
+			Message: []byte{},
+			Tag:     TagMessage,
+			Out:     "f85a0c025ffde8b3b5ac6890eaafea60ab",
+		},
+		{
+			Message: []byte("old age should burn and rave at close of day"),
+			AD:      []byte("chunk 3"),
+			Tag:     TagPush,
+			Out:     "41ce9097682b07e5e8fa46aed3a611029c2fce47090bb0462ebca4351b67f7fe818a85e439106e40f0d5e4f4ac394a78830acdf4bfff7c33688efa66cd",
+		},
+		{
+			Message: []byte("rage, rage against the dying of the light"),
+			Tag:     TagRekey,
+			Out:     "e168c9ad5b0f49a6660e064360ff4646251ea90b97edb352242fb9017258aeaebe92d5ee4270a4934dfd58b1a04513043a6e47d17ab828729424",
+		},
+		{
+			Message: longMessage(),
+			Tag:     TagMessage,
+			Rekey:   true,
+			Out:     "7e1d2b8a6d3b066844d88165e83beae1098b97825eb255526105fbf774e9950c9129760ebccc1cdf3977c3dc2d0997dd838b782760257a7e413b8c08b0aaf4c3f2eac0074ca5ead605d8849195f155d16f42da853859c9b141c4d815ca624a71795b054d36c3d13f690a43e7336a6d9a4629f46998f78b43eece44bd6ec8ab92a5021024507252a6e3acbf0726185165f8cf7f487e7b42861ee2951f09fae31a99eba903d5a465f2f8031d05c9c2b7bb370cedaadc6ea17a4ff6b3bd9440e6f809f5c5beca7d7b42e63da7ad668b552406f670a39946f7a4ad73b10818c52928dd4f76949484d8ba87a7286c3b7d18082a52662331c5f1f91dc345b035609c454e3496e3bf6607edeb9fc977af3fb11f2bfd40b33d555caf576e3bb0ee0a896658e686ff6bad7d0ee8eb4c4751a20f8803470bebbd78ec620632e1fe70",
+		},
+		{
+			Message: []byte("though wise men at their end know dark is right"),
+			Tag:     TagFinal,
+			Out:     "38789f2558a7a92189a1a0538b9682ff57a30c29ccdd189854891a3a3ecc399eea1f15d61cb21278172a19748aae8987b457b112a6b24a49befc3d28d3ca6ceb",
+		},
+	}
+)
+
+func testKey() *[KeySize]byte {
+	key := new([KeySize]byte)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestLibsodiumPull(t *testing.T) {
+	header, _ := hex.DecodeString(testHeader)
+	d, err := NewDecryptor(testKey(), header)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer d.Close()
+
+	for i, v := range testStream {
+		if v.Rekey {
+			d.Rekey()
+		}
+
+		in, _ := hex.DecodeString(v.Out)
+		out, tag, err := d.Pull(in, v.AD)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if tag != v.Tag {
+			t.Fatalf("message %d: expected tag %d, have %d", i, v.Tag, tag)
+		}
+
+		if !bytes.Equal(out, v.Message) {
+			t.Fatalf("message %d: recovered message doesn't match original", i)
+		}
+	}
+
+	if _, _, err = d.Pull(make([]byte, Overhead), nil); err != ErrFinished {
+		t.Fatal("expected a read past the final message to fail")
+	}
+}
+
+func TestLibsodiumPush(t *testing.T) {
+	header, _ := hex.DecodeString(testHeader)
+	e := &Encryptor{s: newState(testKey(), header)}
+	defer e.Close()
+
+	for i, v := range testStream {
+		if v.Rekey {
+			e.Rekey()
+		}
+
+		out := e.Push(v.Message, v.AD, v.Tag)
+		if hex.EncodeToString(out) != v.Out {
+			t.Fatalf("message %d: output doesn't match libsodium", i)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	e, header, err := NewEncryptor(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	first := e.Push(testMessage, nil, TagMessage)
+	second := e.Push(testMessage, nil, TagMessage)
+	final := e.Push(testMessage, nil, TagFinal)
+
+	if len(first) != len(testMessage)+Overhead {
+		t.Fatal("wrong ciphertext length")
+	}
+
+	if bytes.Equal(first, second) {
+		t.Fatal("identical messages should encrypt differently")
+	}
+
+	d, err := NewDecryptor(key, header)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Messages can't be skipped or reordered.
+	if _, _, err = d.Pull(second, nil); err != ErrDecrypt {
+		t.Fatal("expected an out of order message to fail")
+	}
+
+	if _, _, err = d.Pull(first, []byte("ad")); err != ErrDecrypt {
+		t.Fatal("expected a message with the wrong additional data to fail")
+	}
+
+	first[1] ^= 1
+	if _, _, err = d.Pull(first, nil); err != ErrDecrypt {
+		t.Fatal("expected a modified message to fail")
+	}
+	first[1] ^= 1
+
+	for _, in := range [][]byte{first, second, final} {
+		out, _, err := d.Pull(in, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+
+	if _, err = NewDecryptor(key, header[1:]); err != ErrInvalidHeader {
+		t.Fatal("expected a short header to fail")
+	}
+}
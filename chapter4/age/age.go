@@ -0,0 +1,186 @@
+// Package age implements the age-encryption.org/v1 file format. A file
+// is encrypted under a random 16-byte file key, which is wrapped for
+// each recipient in a stanza in the header:
+//
+//	age-encryption.org/v1
+//	-> X25519 <ephemeral share>
+//	<wrapped file key>
+//	--- <HMAC-SHA-256 of the header>
+//	<16-byte nonce><ChaCha20-Poly1305 STREAM payload>
+//
+// X25519 recipients use the same Curve25519 keys as naclbox, and scrypt
+// recipients encrypt to a passphrase. Files interoperate with the
+// reference age implementation.
+package age
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// FileKeySize is the size of the file key wrapped by each recipient.
+const FileKeySize = 16
+
+var (
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("age: encryption failed")
+
+	// ErrNoRecipients is returned when encrypting without recipients.
+	ErrNoRecipients = errors.New("age: no recipients")
+
+	// ErrScryptNotAlone is returned when a scrypt recipient is mixed
+	// with other recipients; a passphrase-encrypted file must only be
+	// decryptable with the passphrase.
+	ErrScryptNotAlone = errors.New("age: scrypt recipient must be the only recipient")
+
+	// ErrInvalidKey is returned when a key string can't be parsed.
+	ErrInvalidKey = errors.New("age: invalid key")
+
+	// ErrHeader is returned when the header is malformed.
+	ErrHeader = errors.New("age: invalid header")
+
+	// ErrHeaderMAC is returned when the header fails authentication.
+	ErrHeaderMAC = errors.New("age: header MAC mismatch")
+
+	// ErrNoMatch is returned when none of the identities can unwrap
+	// the file key.
+	ErrNoMatch = errors.New("age: no identity matched any of the recipients")
+
+	// ErrPayload is returned when the payload fails to decrypt.
+	ErrPayload = errors.New("age: payload decryption failed")
+
+	// ErrIncorrectIdentity is returned by an Identity when none of the
+	// stanzas are addressed to it.
+	ErrIncorrectIdentity = errors.New("age: incorrect identity for recipient block")
+)
+
+// A Recipient wraps a file key in one or more stanzas.
+type Recipient interface {
+	Wrap(fileKey []byte) ([]*Stanza, error)
+}
+
+// An Identity unwraps a file key from one of the stanzas in a header.
+// It returns ErrIncorrectIdentity if none of the stanzas are for it,
+// and ErrHeader if a stanza for it is malformed.
+type Identity interface {
+	Unwrap(stanzas []*Stanza) ([]byte, error)
+}
+
+// aeadEncrypt seals the file key with ChaCha20-Poly1305 under a
+// single-use wrapping key, using an all-zero nonce.
+func aeadEncrypt(key, plaintext []byte) []byte {
+	aead, _ := chacha20poly1305.New(key)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, plaintext, nil)
+}
+
+// aeadDecrypt opens a wrapped file key. The length is checked first so
+// that a longer key is rejected as malformed rather than unwrapped.
+func aeadDecrypt(key, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) != FileKeySize+chacha20poly1305.Overhead {
+		return nil, ErrHeader
+	}
+
+	aead, _ := chacha20poly1305.New(key)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	out, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return out, nil
+}
+
+func headerMAC(fileKey, header []byte) []byte {
+	key := hkdfKey(fileKey, nil, "header")
+	defer util.Zero(key)
+
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil)
+}
+
+// Encrypt encrypts the message to all of the recipients.
+func Encrypt(message []byte, recipients ...Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	fileKey, err := util.RandBytes(FileKeySize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(fileKey)
+
+	h := &header{}
+	for _, r := range recipients {
+		stanzas, err := r.Wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		h.stanzas = append(h.stanzas, stanzas...)
+	}
+
+	if !scryptAlone(h.stanzas) {
+		return nil, ErrScryptNotAlone
+	}
+
+	h.mac = headerMAC(fileKey, h.marshalNoMAC())
+
+	nonce, err := util.RandBytes(streamNonceSize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	key := hkdfKey(fileKey, nonce, "payload")
+	defer util.Zero(key)
+
+	out := h.marshal()
+	out = append(out, nonce...)
+	return append(out, streamSeal(key, message)...), nil
+}
+
+// Decrypt decrypts a file with the first identity that matches one of
+// its recipients.
+func Decrypt(file []byte, identities ...Identity) ([]byte, error) {
+	h, signed, payload, err := parseHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if !scryptAlone(h.stanzas) {
+		return nil, ErrHeader
+	}
+
+	var fileKey []byte
+	for _, id := range identities {
+		fileKey, err = id.Unwrap(h.stanzas)
+		if err == ErrIncorrectIdentity {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	if fileKey == nil {
+		return nil, ErrNoMatch
+	}
+	defer util.Zero(fileKey)
+
+	if !hmac.Equal(headerMAC(fileKey, signed), h.mac) {
+		return nil, ErrHeaderMAC
+	}
+
+	if len(payload) < streamNonceSize {
+		return nil, ErrHeader
+	}
+
+	key := hkdfKey(fileKey, payload[:streamNonceSize], "payload")
+	defer util.Zero(key)
+
+	return streamOpen(key, payload[streamNonceSize:])
+}
@@ -0,0 +1,297 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+type vector struct {
+	name       string
+	expect     string
+	payload    []byte
+	fileKey    []byte
+	identities []Identity
+	file       []byte
+}
+
+var expectErrors = map[string]error{
+	"success":         nil,
+	"header failure":  ErrHeader,
+	"HMAC failure":    ErrHeaderMAC,
+	"no match":        ErrNoMatch,
+	"payload failure": ErrPayload,
+}
+
+func loadVectors(t *testing.T) []*vector {
+	paths, err := filepath.Glob(filepath.Join("testdata", "testkit", "*"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var vectors []*vector
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		v := &vector{name: filepath.Base(path)}
+		r := bufio.NewReader(bytes.NewReader(data))
+		compressed := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s: %v", v.name, err)
+			}
+
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				break
+			}
+
+			kv := strings.SplitN(line, ": ", 2)
+			switch kv[0] {
+			case "expect":
+				v.expect = kv[1]
+			case "payload":
+				v.payload, _ = hex.DecodeString(kv[1])
+			case "file key":
+				v.fileKey, _ = hex.DecodeString(kv[1])
+			case "identity":
+				id, err := ParseX25519Identity(kv[1])
+				if err != nil {
+					t.Fatalf("%s: %v", v.name, err)
+				}
+				v.identities = append(v.identities, id)
+			case "passphrase":
+				v.identities = append(v.identities, NewScryptIdentity([]byte(kv[1])))
+			case "compressed":
+				compressed = true
+			}
+		}
+
+		if compressed {
+			zr, err := zlib.NewReader(r)
+			if err != nil {
+				t.Fatalf("%s: %v", v.name, err)
+			}
+			v.file, err = ioutil.ReadAll(zr)
+		} else {
+			v.file, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+
+		if _, ok := expectErrors[v.expect]; !ok {
+			t.Fatalf("%s: unknown expectation %q", v.name, v.expect)
+		}
+		vectors = append(vectors, v)
+	}
+
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors found")
+	}
+	return vectors
+}
+
+func TestTestkit(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		out, err := Decrypt(v.file, v.identities...)
+		if err != expectErrors[v.expect] {
+			t.Fatalf("%s: expected %s, got %v", v.name, v.expect, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		sum := sha256.Sum256(out)
+		if !bytes.Equal(sum[:], v.payload) {
+			t.Fatalf("%s: payload doesn't match", v.name)
+		}
+	}
+}
+
+// Every vector with a well-formed header must re-encode to the same
+// bytes, and every successful vector's payload must re-encrypt to the
+// same STREAM ciphertext under the file key.
+func TestTestkitRoundTrip(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.expect == "header failure" {
+			continue
+		}
+
+		h, _, rest, err := parseHeader(v.file)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+
+		encoded := v.file[:len(v.file)-len(rest)]
+		if !bytes.Equal(h.marshal(), encoded) {
+			t.Fatalf("%s: header doesn't round trip", v.name)
+		}
+
+		if v.expect != "success" {
+			continue
+		}
+
+		out, err := Decrypt(v.file, v.identities...)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+
+		key := hkdfKey(v.fileKey, rest[:streamNonceSize], "payload")
+		if !bytes.Equal(streamSeal(key, out), rest[streamNonceSize:]) {
+			t.Fatalf("%s: payload doesn't round trip", v.name)
+		}
+	}
+}
+
+func TestX25519(t *testing.T) {
+	// Keys from naclbox can be used directly.
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	other, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	file, err := Encrypt(testMessage, NewX25519Recipient(pub), other.Recipient())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, id := range []Identity{NewX25519Identity(priv), other} {
+		out, err := Decrypt(file, id)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+
+	stranger, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = Decrypt(file, stranger); err != ErrNoMatch {
+		t.Fatal("expected decryption with the wrong identity to fail")
+	}
+}
+
+func TestKeyStrings(t *testing.T) {
+	const identity = "AGE-SECRET-KEY-1EGTZVFFV20835NWYV6270LXYVK2VKNX2MMDKWYKLMGR48UAWX40Q2P2LM0"
+
+	id, err := ParseX25519Identity(identity)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if id.String() != identity {
+		t.Fatal("identity doesn't round trip")
+	}
+
+	recipient := id.Recipient().String()
+	if !strings.HasPrefix(recipient, "age1") {
+		t.Fatal("recipient has the wrong prefix")
+	}
+
+	r, err := ParseX25519Recipient(recipient)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if r.pub != id.pub {
+		t.Fatal("recipient doesn't round trip")
+	}
+
+	if _, err = ParseX25519Recipient(identity); err != ErrInvalidKey {
+		t.Fatal("expected a secret key to be rejected as a recipient")
+	}
+
+	if _, err = ParseX25519Identity(recipient); err != ErrInvalidKey {
+		t.Fatal("expected a recipient to be rejected as a secret key")
+	}
+}
+
+func TestScrypt(t *testing.T) {
+	r := NewScryptRecipient([]byte("hunter2"))
+	r.WorkFactor = 10
+
+	file, err := Encrypt(testMessage, r)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := Decrypt(file, NewScryptIdentity([]byte("hunter2")))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	if _, err = Decrypt(file, NewScryptIdentity([]byte("hunter3"))); err != ErrNoMatch {
+		t.Fatal("expected decryption with the wrong passphrase to fail")
+	}
+
+	id := NewScryptIdentity([]byte("hunter2"))
+	id.MaxWorkFactor = 9
+	if _, err = Decrypt(file, id); err != ErrHeader {
+		t.Fatal("expected a work factor above the maximum to be rejected")
+	}
+
+	other, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = Encrypt(testMessage, r, other.Recipient()); err != ErrScryptNotAlone {
+		t.Fatal("expected a scrypt recipient with other recipients to fail")
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	key := make([]byte, 32)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize} {
+		message := make([]byte, size)
+		ct := streamSeal(key, message)
+
+		chunks := (size + chunkSize - 1) / chunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if len(ct) != size+chunks*16 {
+			t.Fatalf("%d: wrong ciphertext length %d", size, len(ct))
+		}
+
+		out, err := streamOpen(key, ct)
+		if err != nil {
+			t.Fatalf("%d: %v", size, err)
+		}
+
+		if !bytes.Equal(out, message) {
+			t.Fatalf("%d: recovered message doesn't match original", size)
+		}
+	}
+}
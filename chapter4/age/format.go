@@ -0,0 +1,166 @@
+package age
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	version     = "age-encryption.org/v1"
+	stanzaStart = "-> "
+	footerStart = "---"
+	columns     = 64
+)
+
+// b64 is the unpadded, canonical base64 encoding used throughout the
+// header.
+var b64 = base64.RawStdEncoding.Strict()
+
+// A Stanza is a single recipient block in the header. Each recipient
+// type defines the meaning of the arguments and body.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// marshal writes the stanza, wrapping the body at 64 columns. The last
+// line is always shorter than 64 columns, and is empty if the encoded
+// body is a multiple of 64 columns long.
+func (s *Stanza) marshal(buf *bytes.Buffer) {
+	buf.WriteString(stanzaStart)
+	buf.WriteString(strings.Join(append([]string{s.Type}, s.Args...), " "))
+	buf.WriteByte('\n')
+
+	body := b64.EncodeToString(s.Body)
+	for len(body) >= columns {
+		buf.WriteString(body[:columns])
+		buf.WriteByte('\n')
+		body = body[columns:]
+	}
+	buf.WriteString(body)
+	buf.WriteByte('\n')
+}
+
+// header is a parsed age header.
+type header struct {
+	stanzas []*Stanza
+	mac     []byte
+}
+
+// marshalNoMAC returns the part of the header covered by the MAC, up to
+// and including the "---" that starts the footer.
+func (h *header) marshalNoMAC() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(version)
+	buf.WriteByte('\n')
+	for _, s := range h.stanzas {
+		s.marshal(buf)
+	}
+	buf.WriteString(footerStart)
+	return buf.Bytes()
+}
+
+// marshal returns the complete encoded header.
+func (h *header) marshal() []byte {
+	out := h.marshalNoMAC()
+	out = append(out, ' ')
+	out = append(out, b64.EncodeToString(h.mac)...)
+	return append(out, '\n')
+}
+
+// validArg returns true if the argument is non-empty and made up of
+// printable ASCII characters other than space.
+func validArg(arg string) bool {
+	if len(arg) == 0 {
+		return false
+	}
+	for i := 0; i < len(arg); i++ {
+		if arg[i] < 33 || arg[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeLine decodes a line of canonical base64. The base64 package
+// skips over newlines, so carriage returns have to be rejected here.
+func decodeLine(line []byte) ([]byte, bool) {
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return nil, false
+	}
+	out, err := b64.DecodeString(string(line))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// parseHeader parses the header at the start of the file. It returns
+// the header, the bytes covered by the MAC, and the rest of the file.
+func parseHeader(file []byte) (*header, []byte, []byte, error) {
+	rest := file
+	nextLine := func() ([]byte, bool) {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			return nil, false
+		}
+		line := rest[:i]
+		rest = rest[i+1:]
+		return line, true
+	}
+
+	line, ok := nextLine()
+	if !ok || string(line) != version {
+		return nil, nil, nil, ErrHeader
+	}
+
+	h := &header{}
+	for {
+		start := len(file) - len(rest)
+		line, ok = nextLine()
+		if !ok {
+			return nil, nil, nil, ErrHeader
+		}
+
+		if bytes.HasPrefix(line, []byte(footerStart+" ")) {
+			mac, ok := decodeLine(line[len(footerStart)+1:])
+			if !ok || len(mac) != 32 {
+				return nil, nil, nil, ErrHeader
+			}
+			h.mac = mac
+			return h, file[:start+len(footerStart)], rest, nil
+		}
+
+		if !bytes.HasPrefix(line, []byte(stanzaStart)) {
+			return nil, nil, nil, ErrHeader
+		}
+
+		args := strings.Split(string(line[len(stanzaStart):]), " ")
+		for _, arg := range args {
+			if !validArg(arg) {
+				return nil, nil, nil, ErrHeader
+			}
+		}
+
+		s := &Stanza{Type: args[0], Args: args[1:], Body: []byte{}}
+		for {
+			line, ok = nextLine()
+			if !ok || len(line) > columns {
+				return nil, nil, nil, ErrHeader
+			}
+
+			body, ok := decodeLine(line)
+			if !ok {
+				return nil, nil, nil, ErrHeader
+			}
+			s.Body = append(s.Body, body...)
+
+			if len(line) < columns {
+				break
+			}
+		}
+		h.stanzas = append(h.stanzas, s)
+	}
+}
@@ -0,0 +1,157 @@
+package age
+
+import (
+	"strconv"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptLabel    = "age-encryption.org/v1/scrypt"
+	scryptType     = "scrypt"
+	scryptSaltSize = 16
+
+	// DefaultWorkFactor is the base-2 logarithm of the scrypt N
+	// parameter used for new files. It matches the N = 2^20 of
+	// passcrypt's LegacyParams.
+	DefaultWorkFactor = 20
+
+	// DefaultMaxWorkFactor is the largest work factor a ScryptIdentity
+	// will accept by default. Larger values would let a malicious
+	// file force a very long computation.
+	DefaultMaxWorkFactor = 22
+)
+
+// scryptKey derives the wrapping key from the passphrase. The salt is
+// prefixed with the label to separate it from other scrypt uses; r = 8
+// and p = 1 as in passcrypt's LegacyParams.
+func scryptKey(pass, salt []byte, logN int) ([]byte, error) {
+	s := make([]byte, 0, len(scryptLabel)+len(salt))
+	s = append(s, scryptLabel...)
+	s = append(s, salt...)
+	return scrypt.Key(pass, s, 1<<uint(logN), 8, 1, 32)
+}
+
+// scryptAlone returns false if a scrypt stanza appears alongside any
+// other stanza.
+func scryptAlone(stanzas []*Stanza) bool {
+	if len(stanzas) < 2 {
+		return true
+	}
+	for _, s := range stanzas {
+		if s.Type == scryptType {
+			return false
+		}
+	}
+	return true
+}
+
+// A ScryptRecipient encrypts to a passphrase. It must be the only
+// recipient of a file.
+type ScryptRecipient struct {
+	pass []byte
+
+	// WorkFactor is the base-2 logarithm of the scrypt N parameter.
+	WorkFactor int
+}
+
+// NewScryptRecipient returns a recipient for the passphrase.
+func NewScryptRecipient(pass []byte) *ScryptRecipient {
+	return &ScryptRecipient{
+		pass:       append([]byte{}, pass...),
+		WorkFactor: DefaultWorkFactor,
+	}
+}
+
+// Wrap implements Recipient.
+func (r *ScryptRecipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	if r.WorkFactor < 1 || r.WorkFactor > 30 {
+		return nil, ErrEncrypt
+	}
+
+	salt, err := util.RandBytes(scryptSaltSize)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	key, err := scryptKey(r.pass, salt, r.WorkFactor)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(key)
+
+	return []*Stanza{{
+		Type: scryptType,
+		Args: []string{b64.EncodeToString(salt), strconv.Itoa(r.WorkFactor)},
+		Body: aeadEncrypt(key, fileKey),
+	}}, nil
+}
+
+// A ScryptIdentity decrypts files encrypted to a passphrase.
+type ScryptIdentity struct {
+	pass []byte
+
+	// MaxWorkFactor is the largest work factor that will be accepted.
+	MaxWorkFactor int
+}
+
+// NewScryptIdentity returns an identity for the passphrase.
+func NewScryptIdentity(pass []byte) *ScryptIdentity {
+	return &ScryptIdentity{
+		pass:          append([]byte{}, pass...),
+		MaxWorkFactor: DefaultMaxWorkFactor,
+	}
+}
+
+// parseWorkFactor accepts only a plain decimal number without a sign or
+// leading zeroes.
+func parseWorkFactor(s string) (int, bool) {
+	if len(s) == 0 || len(s) > 2 || s[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// Unwrap implements Identity.
+func (id *ScryptIdentity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != scryptType {
+			continue
+		}
+
+		if len(s.Args) != 2 {
+			return nil, ErrHeader
+		}
+
+		salt, ok := decodeLine([]byte(s.Args[0]))
+		if !ok || len(salt) != scryptSaltSize {
+			return nil, ErrHeader
+		}
+
+		logN, ok := parseWorkFactor(s.Args[1])
+		if !ok || logN > id.MaxWorkFactor {
+			return nil, ErrHeader
+		}
+
+		key, err := scryptKey(id.pass, salt, logN)
+		if err != nil {
+			return nil, ErrHeader
+		}
+
+		fileKey, err := aeadDecrypt(key, s.Body)
+		util.Zero(key)
+		if err == ErrIncorrectIdentity {
+			continue
+		}
+		return fileKey, err
+	}
+
+	return nil, ErrIncorrectIdentity
+}
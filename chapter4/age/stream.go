@@ -0,0 +1,97 @@
+package age
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// chunkSize is the size of each plaintext chunk in the payload.
+	chunkSize = 64 * 1024
+
+	// encChunkSize is the size of an encrypted chunk, including the
+	// Poly1305 tag.
+	encChunkSize = chunkSize + chacha20poly1305.Overhead
+
+	// streamNonceSize is the size of the nonce that precedes the
+	// payload and is used to derive the payload key.
+	streamNonceSize = 16
+)
+
+// hkdfKey derives a 32-byte key with HKDF-SHA-256.
+func hkdfKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	// A 32-byte read from HKDF-SHA-256 can't fail.
+	io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key)
+	return key
+}
+
+// chunkNonce builds the ChaCha20-Poly1305 nonce for a chunk: an 11-byte
+// big-endian counter followed by a byte that is 1 for the final chunk.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// streamSeal encrypts the payload with the STREAM construction. The
+// message is split into 64 KiB chunks; the final chunk may be shorter,
+// and is only empty if the whole message is.
+func streamSeal(key, message []byte) []byte {
+	aead, _ := chacha20poly1305.New(key)
+
+	chunks := (len(message) + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	out := make([]byte, 0, len(message)+chunks*aead.Overhead())
+	for i := 0; i < chunks; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(message) {
+			end = len(message)
+		}
+		nonce := chunkNonce(uint64(i), i == chunks-1)
+		out = aead.Seal(out, nonce, message[i*chunkSize:end], nil)
+	}
+	return out
+}
+
+// streamOpen decrypts a STREAM payload. Every chunk but the last must
+// be full, and only the last chunk may be marked as final.
+func streamOpen(key, payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, ErrPayload
+	}
+
+	aead, _ := chacha20poly1305.New(key)
+	out := make([]byte, 0, len(payload))
+	for counter := uint64(0); len(payload) > 0; counter++ {
+		n := encChunkSize
+		if n > len(payload) {
+			n = len(payload)
+		}
+		last := n == len(payload)
+
+		// An empty final chunk is only allowed for an empty message.
+		if n < aead.Overhead() || (last && n == aead.Overhead() && counter > 0) {
+			return nil, ErrPayload
+		}
+
+		var err error
+		out, err = aead.Open(out, chunkNonce(counter, last), payload[:n], nil)
+		if err != nil {
+			return nil, ErrPayload
+		}
+		payload = payload[n:]
+	}
+
+	return out, nil
+}
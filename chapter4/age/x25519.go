@@ -0,0 +1,165 @@
+package age
+
+import (
+	"crypto/rand"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"git.metacircular.net/kyle/gocrypto/util/bech32"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	x25519Label = "age-encryption.org/v1/X25519"
+	x25519Type  = "X25519"
+
+	recipientHRP = "age"
+	identityHRP  = "AGE-SECRET-KEY-"
+)
+
+// An X25519Recipient encrypts to a Curve25519 public key, such as one
+// produced by box.GenerateKey.
+type X25519Recipient struct {
+	pub [32]byte
+}
+
+// NewX25519Recipient returns a recipient for the public key.
+func NewX25519Recipient(pub *[32]byte) *X25519Recipient {
+	return &X25519Recipient{pub: *pub}
+}
+
+// ParseX25519Recipient parses an "age1..." public key string.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil || hrp != recipientHRP || len(data) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	r := &X25519Recipient{}
+	copy(r.pub[:], data)
+	return r, nil
+}
+
+// String returns the "age1..." encoding of the public key.
+func (r *X25519Recipient) String() string {
+	s, _ := bech32.Encode(recipientHRP, r.pub[:])
+	return s
+}
+
+// wrapKey derives the key wrapping the file key from the shared secret,
+// binding it to the ephemeral share and the recipient's public key.
+func wrapKey(shared, share, pub []byte) []byte {
+	salt := make([]byte, 0, len(share)+len(pub))
+	salt = append(salt, share...)
+	salt = append(salt, pub...)
+	return hkdfKey(shared, salt, x25519Label)
+}
+
+// Wrap implements Recipient with an ephemeral key exchange.
+func (r *X25519Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	share, eph, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(eph[:])
+
+	shared, err := curve25519.X25519(eph[:], r.pub[:])
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(shared)
+
+	key := wrapKey(shared, share[:], r.pub[:])
+	defer util.Zero(key)
+
+	return []*Stanza{{
+		Type: x25519Type,
+		Args: []string{b64.EncodeToString(share[:])},
+		Body: aeadEncrypt(key, fileKey),
+	}}, nil
+}
+
+// An X25519Identity decrypts files encrypted to its public key.
+type X25519Identity struct {
+	priv [32]byte
+	pub  [32]byte
+}
+
+// NewX25519Identity returns an identity for the Curve25519 private key.
+func NewX25519Identity(priv *[32]byte) *X25519Identity {
+	id := &X25519Identity{priv: *priv}
+	curve25519.ScalarBaseMult(&id.pub, &id.priv)
+	return id
+}
+
+// GenerateX25519Identity returns a new random identity.
+func GenerateX25519Identity() (*X25519Identity, error) {
+	_, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(priv[:])
+	return NewX25519Identity(priv), nil
+}
+
+// ParseX25519Identity parses an "AGE-SECRET-KEY-1..." private key
+// string.
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil || hrp != identityHRP || len(data) != 32 {
+		return nil, ErrInvalidKey
+	}
+	defer util.Zero(data)
+
+	var priv [32]byte
+	copy(priv[:], data)
+	defer util.Zero(priv[:])
+	return NewX25519Identity(&priv), nil
+}
+
+// String returns the "AGE-SECRET-KEY-1..." encoding of the private key.
+func (id *X25519Identity) String() string {
+	s, _ := bech32.Encode(identityHRP, id.priv[:])
+	return s
+}
+
+// Recipient returns the recipient for the identity's public key.
+func (id *X25519Identity) Recipient() *X25519Recipient {
+	return NewX25519Recipient(&id.pub)
+}
+
+// Unwrap implements Identity.
+func (id *X25519Identity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != x25519Type {
+			continue
+		}
+
+		if len(s.Args) != 1 {
+			return nil, ErrHeader
+		}
+
+		share, ok := decodeLine([]byte(s.Args[0]))
+		if !ok || len(share) != 32 {
+			return nil, ErrHeader
+		}
+
+		// X25519 fails if the share is a low-order point that
+		// produces an all-zero shared secret.
+		shared, err := curve25519.X25519(id.priv[:], share)
+		if err != nil {
+			return nil, ErrHeader
+		}
+
+		key := wrapKey(shared, share, id.pub[:])
+		util.Zero(shared)
+		fileKey, err := aeadDecrypt(key, s.Body)
+		util.Zero(key)
+		if err == ErrIncorrectIdentity {
+			continue
+		}
+		return fileKey, err
+	}
+
+	return nil, ErrIncorrectIdentity
+}
@@ -0,0 +1,290 @@
+// Package ecies encrypts messages to NIST curve ECDSA keys using the
+// Elliptic Curve Integrated Encryption Scheme from SEC 1 version 2.
+//
+// Each message is encrypted with a new ephemeral key pair on the
+// recipient's curve. The shared secret between the ephemeral key and
+// the recipient's key is passed through a KDF, ANSI X9.63 with SHA-256
+// by default, to derive the message keys. The ciphertext is the
+// ephemeral public key, as an uncompressed or compressed point,
+// followed by the encrypted message.
+//
+// The default cipher is AES-256-GCM. AESCBCHMAC is the SEC 1 scheme
+// with AES-256-CBC and HMAC-SHA-256, for exchanging messages with
+// other SEC 1 implementations.
+package ecies
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+)
+
+var (
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("ecies: encryption failed")
+
+	// ErrDecrypt is returned when decryption fails.
+	ErrDecrypt = errors.New("ecies: decryption failed")
+
+	// ErrUnsupported is returned for an unknown cipher.
+	ErrUnsupported = errors.New("ecies: unsupported cipher")
+)
+
+// A Cipher selects how messages are encrypted.
+type Cipher int
+
+const (
+	// AESGCM encrypts with AES-256-GCM. The KDF provides the nonce
+	// along with the key, and SharedInfo2 is authenticated as
+	// additional data.
+	AESGCM Cipher = iota
+
+	// AESCBCHMAC encrypts with AES-256-CBC, using an all-zero IV
+	// and PKCS #7 padding, and appends an HMAC-SHA-256 tag over the
+	// ciphertext and SharedInfo2.
+	AESCBCHMAC
+)
+
+const (
+	keySize   = 32
+	nonceSize = 12
+	macSize   = sha256.Size
+)
+
+// Options control how messages are encrypted. Messages must be
+// decrypted with the same Cipher, KDF and shared info; whether the
+// ephemeral key was compressed is detected automatically.
+type Options struct {
+	Cipher Cipher
+
+	// Compressed sends the ephemeral public key as a compressed
+	// point, saving the size of one field element.
+	Compressed bool
+
+	// KDF derives the message keys from the shared secret. If it
+	// is nil, ANSI X9.63 with SHA-256 is used with SharedInfo1 as
+	// its shared info.
+	KDF nistecdh.KDF
+
+	// SharedInfo1 and SharedInfo2 are optional data that both
+	// sides must know. SharedInfo1 is passed to the default KDF,
+	// and SharedInfo2 is authenticated with the ciphertext.
+	SharedInfo1 []byte
+	SharedInfo2 []byte
+}
+
+// DefaultOptions use AES-256-GCM with uncompressed points and no
+// shared info.
+var DefaultOptions = &Options{}
+
+func (opts *Options) kdf() nistecdh.KDF {
+	if opts.KDF != nil {
+		return opts.KDF
+	}
+	return &nistecdh.X963{Hash: sha256.New, SharedInfo: opts.SharedInfo1}
+}
+
+// subkeys returns the keys to derive for the cipher: the encryption
+// key is always first, as in SEC 1.
+func (opts *Options) subkeys() ([]nistecdh.Subkey, error) {
+	switch opts.Cipher {
+	case AESGCM:
+		return []nistecdh.Subkey{{Name: "key", Size: keySize}, {Name: "nonce", Size: nonceSize}}, nil
+	case AESCBCHMAC:
+		return []nistecdh.Subkey{{Name: "key", Size: keySize}, {Name: "mac", Size: keySize}}, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+func (opts *Options) deriveKeys(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) (nistecdh.Keys, error) {
+	subkeys, err := opts.subkeys()
+	if err != nil {
+		return nil, err
+	}
+	return nistecdh.DeriveKeys(priv, pub, opts.kdf(), subkeys...)
+}
+
+func cbcMAC(key, ct, sharedInfo []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(ct)
+	h.Write(sharedInfo)
+	return h.Sum(nil)
+}
+
+func (opts *Options) seal(keys nistecdh.Keys, out, message []byte) []byte {
+	// NewCipher only returns an error with an invalid key size, and
+	// the keys were derived with the right size.
+	c, _ := aes.NewCipher(keys["key"])
+
+	if opts.Cipher == AESGCM {
+		gcm, _ := cipher.NewGCM(c)
+		return gcm.Seal(out, keys["nonce"], message, opts.SharedInfo2)
+	}
+
+	ct := secret.Pad(message)
+	cipher.NewCBCEncrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(ct, ct)
+	ct = append(ct, cbcMAC(keys["mac"], ct, opts.SharedInfo2)...)
+	return append(out, ct...)
+}
+
+func (opts *Options) open(keys nistecdh.Keys, ct []byte) ([]byte, error) {
+	c, _ := aes.NewCipher(keys["key"])
+
+	if opts.Cipher == AESGCM {
+		gcm, _ := cipher.NewGCM(c)
+		out, err := gcm.Open(nil, keys["nonce"], ct, opts.SharedInfo2)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return out, nil
+	}
+
+	if len(ct) < aes.BlockSize+macSize || (len(ct)-macSize)%aes.BlockSize != 0 {
+		return nil, ErrDecrypt
+	}
+
+	tag := ct[len(ct)-macSize:]
+	ct = ct[:len(ct)-macSize]
+	if !hmac.Equal(tag, cbcMAC(keys["mac"], ct, opts.SharedInfo2)) {
+		return nil, ErrDecrypt
+	}
+
+	out := make([]byte, len(ct))
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(out, ct)
+	pt := secret.Unpad(out)
+	if pt == nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// compress converts an uncompressed point to its compressed form.
+func compress(point []byte) []byte {
+	size := (len(point) - 1) / 2
+	out := make([]byte, 1+size)
+	out[0] = 2 | point[len(point)-1]&1
+	copy(out[1:], point[1:1+size])
+	return out
+}
+
+// parseEphemeral splits the ephemeral public key from the start of a
+// message, returning it and the rest of the message.
+func parseEphemeral(curve elliptic.Curve, ecCurve ecdh.Curve, message []byte) (*ecdh.PublicKey, []byte, error) {
+	if len(message) == 0 {
+		return nil, nil, ErrDecrypt
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	switch message[0] {
+	case 4:
+		if len(message) < 1+2*size {
+			return nil, nil, ErrDecrypt
+		}
+
+		pub, err := ecCurve.NewPublicKey(message[:1+2*size])
+		if err != nil {
+			return nil, nil, ErrDecrypt
+		}
+		return pub, message[1+2*size:], nil
+	case 2, 3:
+		if len(message) < 1+size {
+			return nil, nil, ErrDecrypt
+		}
+
+		x, y := elliptic.UnmarshalCompressed(curve, message[:1+size])
+		if x == nil {
+			return nil, nil, ErrDecrypt
+		}
+
+		pub, err := nistecdh.PublicKeyFromECDSA(&ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+		if err != nil {
+			return nil, nil, ErrDecrypt
+		}
+		return pub, message[1+size:], nil
+	default:
+		return nil, nil, ErrDecrypt
+	}
+}
+
+// Encrypt secures a message to the peer's public key using an
+// ephemeral key pair and the default options.
+func Encrypt(pub *ecdsa.PublicKey, message []byte) ([]byte, error) {
+	return EncryptWithOptions(pub, message, DefaultOptions)
+}
+
+// Decrypt recovers a message encrypted with the default options.
+func Decrypt(priv *ecdsa.PrivateKey, message []byte) ([]byte, error) {
+	return DecryptWithOptions(priv, message, DefaultOptions)
+}
+
+// EncryptWithOptions secures a message to the peer's public key using
+// an ephemeral key pair. If opts is nil, the default options are used.
+func EncryptWithOptions(pub *ecdsa.PublicKey, message []byte, opts *Options) ([]byte, error) {
+	ecPub, err := nistecdh.PublicKeyFromECDSA(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecPub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	return encrypt(ephemeral, ecPub, message, opts)
+}
+
+func encrypt(ephemeral *ecdh.PrivateKey, pub *ecdh.PublicKey, message []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+
+	keys, err := opts.deriveKeys(ephemeral, pub)
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Zero()
+
+	out := ephemeral.PublicKey().Bytes()
+	if opts.Compressed {
+		out = compress(out)
+	}
+	return opts.seal(keys, out, message), nil
+}
+
+// DecryptWithOptions recovers a message encrypted with the same
+// options. If opts is nil, the default options are used.
+func DecryptWithOptions(priv *ecdsa.PrivateKey, message []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+
+	ecPriv, err := nistecdh.PrivateKeyFromECDSA(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, ct, err := parseEphemeral(priv.Curve, ecPriv.Curve(), message)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := opts.deriveKeys(ecPriv, ephemeral)
+	if err != nil {
+		if errors.Is(err, nistecdh.ErrKeyExchange) {
+			return nil, ErrDecrypt
+		}
+		return nil, err
+	}
+	defer keys.Zero()
+
+	return opts.open(keys, ct)
+}
@@ -0,0 +1,202 @@
+package ecies
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+func mustHex(t *testing.T, s string) []byte {
+	out, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return out
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	curves := []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()}
+	options := []*Options{
+		nil,
+		{Compressed: true},
+		{Cipher: AESCBCHMAC},
+		{Cipher: AESCBCHMAC, Compressed: true},
+		{SharedInfo1: []byte("info 1"), SharedInfo2: []byte("info 2")},
+		{KDF: &nistecdh.HKDF{Hash: sha512.New, Info: []byte("ecies")}},
+	}
+
+	for _, curve := range curves {
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		for i, opts := range options {
+			out, err := EncryptWithOptions(&priv.PublicKey, testMessage, opts)
+			if err != nil {
+				t.Fatalf("%s, case %d: %v", curve.Params().Name, i, err)
+			}
+
+			pt, err := DecryptWithOptions(priv, out, opts)
+			if err != nil {
+				t.Fatalf("%s, case %d: %v", curve.Params().Name, i, err)
+			}
+
+			if !bytes.Equal(pt, testMessage) {
+				t.Fatalf("%s, case %d: decrypted message doesn't match original",
+					curve.Params().Name, i)
+			}
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := Encrypt(&priv.PublicKey, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// An uncompressed P-256 point, the message and a GCM tag.
+	if len(out) != 65+len(testMessage)+16 {
+		t.Fatalf("unexpected ciphertext length %d", len(out))
+	}
+
+	pt, err := Decrypt(priv, out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(pt, testMessage) {
+		t.Fatal("decrypted message doesn't match original")
+	}
+}
+
+// TestSEC1Vector checks the AES-CBC and HMAC scheme against a message
+// built with OpenSSL's ECDH, X963KDF, AES-256-CBC and HMAC-SHA-256.
+func TestSEC1Vector(t *testing.T) {
+	priv, err := x509.ParseECPrivateKey(mustHex(t, "307702010104203f2dd9ab5f82e70ee21e8c6f82fe70c62a78523d27a82f41526ef9ce4875acc3a00a06082a8648ce3d030107a14403420004dfec0ddf07e02c6fd2d63f02331624424c0e3284687c364ee91ef90050fe591336b4be8ba19cbdf41a69479219de73fb4596a096a1276f6da22a56bbe6f4b10e"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ephemeral, err := ecdh.P256().NewPrivateKey(mustHex(t, "246f490f6a6d1cef4846262bb5eb6ee5e363476c4731f2af5490644d4deb549a"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	opts := &Options{
+		Cipher:      AESCBCHMAC,
+		SharedInfo1: []byte("ecies test 1"),
+		SharedInfo2: []byte("ecies test 2"),
+	}
+	body := "17aa492a1d9e9ff80b1ce1211e9f39f61c1d97c761dab140c2d688437147a9ca8e53ec8e8c7e2e7c71e7b05543186a7c" +
+		"576d93eaba69fdbd3a774e238a9fe8282590bd67595adaf4caca3b05811d8063"
+	expected := mustHex(t, "04667a7e1060d0d1f037ffafa5ea8ee2d42f4fe0b3a090e6f2125507cb0ad2b13a404ee706bc13c00e8924260e18b2a2af318fd9daf78c686dc0f721b8a79d1323"+body)
+
+	pub, err := nistecdh.PublicKeyFromECDSA(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := encrypt(ephemeral, pub, testMessage, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, expected) {
+		t.Fatalf("have %x, want %x", out, expected)
+	}
+
+	compressed := mustHex(t, "03667a7e1060d0d1f037ffafa5ea8ee2d42f4fe0b3a090e6f2125507cb0ad2b13a"+body)
+	for _, message := range [][]byte{expected, compressed} {
+		pt, err := DecryptWithOptions(priv, message, opts)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(pt, testMessage) {
+			t.Fatal("decrypted message doesn't match original")
+		}
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, opts := range []*Options{DefaultOptions, {Cipher: AESCBCHMAC}} {
+		out, err := EncryptWithOptions(&priv.PublicKey, testMessage, opts)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if _, err = DecryptWithOptions(other, out, opts); err != ErrDecrypt {
+			t.Fatalf("expected ErrDecrypt with the wrong key, have %v", err)
+		}
+
+		for i := range out {
+			tampered := append([]byte{}, out...)
+			tampered[i] ^= 1
+			if _, err = DecryptWithOptions(priv, tampered, opts); err == nil {
+				t.Fatalf("tampered byte %d should fail to decrypt", i)
+			}
+		}
+
+		for i := 0; i < len(out); i++ {
+			if _, err = DecryptWithOptions(priv, out[:i], opts); err == nil {
+				t.Fatalf("truncated message of %d bytes should fail to decrypt", i)
+			}
+		}
+
+		wrongInfo := *opts
+		wrongInfo.SharedInfo2 = []byte("other")
+		if _, err = DecryptWithOptions(priv, out, &wrongInfo); err != ErrDecrypt {
+			t.Fatalf("expected ErrDecrypt with the wrong shared info, have %v", err)
+		}
+	}
+
+	if _, err = EncryptWithOptions(&priv.PublicKey, testMessage, &Options{Cipher: 7}); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, have %v", err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := Encrypt(&p384.PublicKey, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = Decrypt(priv, out); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt for a message on another curve, have %v", err)
+	}
+
+	if _, err = Encrypt(nil, testMessage); !errors.Is(err, nistecdh.ErrInvalidPoint) {
+		t.Fatalf("expected ErrInvalidPoint, have %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package hpke
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/kem"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+// context is the state shared by senders and recipients: the AEAD and
+// base nonce (unless the suite is export-only), the exporter secret,
+// and the sequence number of the next message.
+type context struct {
+	suite     *Suite
+	aead      cipher.AEAD
+	baseNonce []byte
+	exporter  []byte
+	seq       uint64
+}
+
+// nonce computes the nonce for the current sequence number by XORing
+// it into the low bytes of the base nonce.
+func (c *context) nonce() []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[nonceSize-8:], c.seq)
+	for i := range nonce {
+		nonce[i] ^= c.baseNonce[i]
+	}
+	return nonce
+}
+
+func (c *context) export(exporterContext []byte, length int) ([]byte, error) {
+	if length < 0 || length > 255*len(c.exporter) {
+		return nil, ErrExportLength
+	}
+	return c.suite.labeledExpand(c.exporter, "sec", exporterContext, length), nil
+}
+
+func (c *context) zero() {
+	util.Zero(c.exporter)
+	util.Zero(c.baseNonce)
+	c.aead = nil
+}
+
+// A Sender encrypts a sequence of messages to a recipient. Messages
+// must be decrypted in the order they were encrypted. A Sender isn't
+// safe for concurrent use.
+type Sender struct {
+	ctx *context
+}
+
+// Seal encrypts the next message, authenticating the additional data
+// as well.
+func (s *Sender) Seal(aad, plaintext []byte) ([]byte, error) {
+	c := s.ctx
+	if c.aead == nil {
+		return nil, ErrExportOnly
+	}
+
+	if c.seq == math.MaxUint64 {
+		return nil, ErrMessageLimit
+	}
+
+	out := c.aead.Seal(nil, c.nonce(), plaintext, aad)
+	c.seq++
+	return out, nil
+}
+
+// Export derives a secret of the given length from the context and
+// the exporter context. The recipient derives the same secret.
+func (s *Sender) Export(exporterContext []byte, length int) ([]byte, error) {
+	return s.ctx.export(exporterContext, length)
+}
+
+// Seq returns the sequence number of the next message.
+func (s *Sender) Seq() uint64 { return s.ctx.seq }
+
+// Zero wipes the context's secrets; it can't be used afterwards.
+func (s *Sender) Zero() { s.ctx.zero() }
+
+// A Recipient decrypts a sequence of messages from a sender. A
+// Recipient isn't safe for concurrent use.
+type Recipient struct {
+	ctx *context
+}
+
+// Open decrypts the next message. If decryption fails, the sequence
+// number isn't advanced.
+func (r *Recipient) Open(aad, ciphertext []byte) ([]byte, error) {
+	c := r.ctx
+	if c.aead == nil {
+		return nil, ErrExportOnly
+	}
+
+	if c.seq == math.MaxUint64 {
+		return nil, ErrMessageLimit
+	}
+
+	out, err := c.aead.Open(nil, c.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	c.seq++
+	return out, nil
+}
+
+// Export derives a secret of the given length from the context and
+// the exporter context.
+func (r *Recipient) Export(exporterContext []byte, length int) ([]byte, error) {
+	return r.ctx.export(exporterContext, length)
+}
+
+// Seq returns the sequence number of the next message.
+func (r *Recipient) Seq() uint64 { return r.ctx.seq }
+
+// Zero wipes the context's secrets; it can't be used afterwards.
+func (r *Recipient) Zero() { r.ctx.zero() }
+
+func sealOnce(enc []byte, sender *Sender, err error, aad, plaintext []byte) ([]byte, []byte, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sender.Zero()
+
+	ct, err := sender.Seal(aad, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, ct, nil
+}
+
+func openOnce(recipient *Recipient, err error, aad, ciphertext []byte) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer recipient.Zero()
+	return recipient.Open(aad, ciphertext)
+}
+
+// Seal encrypts a single message to pkR in Base mode, returning the
+// encapsulation and the ciphertext.
+func (s *Suite) Seal(pkR kem.PublicKey, info, aad, plaintext []byte) (enc, ct []byte, err error) {
+	enc, sender, err := s.SetupBaseS(pkR, info)
+	return sealOnce(enc, sender, err, aad, plaintext)
+}
+
+// Open decrypts a single message sealed in Base mode.
+func (s *Suite) Open(enc []byte, skR kem.PrivateKey, info, aad, ciphertext []byte) ([]byte, error) {
+	recipient, err := s.SetupBaseR(enc, skR, info)
+	return openOnce(recipient, err, aad, ciphertext)
+}
+
+// SealPSK encrypts a single message to pkR in PSK mode.
+func (s *Suite) SealPSK(pkR kem.PublicKey, info, aad, plaintext, psk, pskID []byte) (enc, ct []byte, err error) {
+	enc, sender, err := s.SetupPSKS(pkR, info, psk, pskID)
+	return sealOnce(enc, sender, err, aad, plaintext)
+}
+
+// OpenPSK decrypts a single message sealed in PSK mode.
+func (s *Suite) OpenPSK(enc []byte, skR kem.PrivateKey, info, aad, ciphertext, psk, pskID []byte) ([]byte, error) {
+	recipient, err := s.SetupPSKR(enc, skR, info, psk, pskID)
+	return openOnce(recipient, err, aad, ciphertext)
+}
+
+// SealAuth encrypts a single message to pkR in Auth mode.
+func (s *Suite) SealAuth(pkR kem.PublicKey, info, aad, plaintext []byte, skS kem.PrivateKey) (enc, ct []byte, err error) {
+	enc, sender, err := s.SetupAuthS(pkR, info, skS)
+	return sealOnce(enc, sender, err, aad, plaintext)
+}
+
+// OpenAuth decrypts a single message sealed in Auth mode by the holder
+// of the private key for pkS.
+func (s *Suite) OpenAuth(enc []byte, skR kem.PrivateKey, info, aad, ciphertext []byte, pkS kem.PublicKey) ([]byte, error) {
+	recipient, err := s.SetupAuthR(enc, skR, info, pkS)
+	return openOnce(recipient, err, aad, ciphertext)
+}
+
+// SealAuthPSK encrypts a single message to pkR in AuthPSK mode.
+func (s *Suite) SealAuthPSK(pkR kem.PublicKey, info, aad, plaintext, psk, pskID []byte, skS kem.PrivateKey) (enc, ct []byte, err error) {
+	enc, sender, err := s.SetupAuthPSKS(pkR, info, psk, pskID, skS)
+	return sealOnce(enc, sender, err, aad, plaintext)
+}
+
+// OpenAuthPSK decrypts a single message sealed in AuthPSK mode.
+func (s *Suite) OpenAuthPSK(enc []byte, skR kem.PrivateKey, info, aad, ciphertext, psk, pskID []byte, pkS kem.PublicKey) ([]byte, error) {
+	recipient, err := s.SetupAuthPSKR(enc, skR, info, psk, pskID, pkS)
+	return openOnce(recipient, err, aad, ciphertext)
+}
@@ -0,0 +1,354 @@
+// Package hpke implements Hybrid Public Key Encryption as specified in
+// RFC 9180, as a standard replacement for the ad hoc constructions in
+// naclbox and in nistecdh with aescbc.
+//
+// A Suite combines a KEM from the kem package with a KDF and an AEAD.
+// All four modes are supported: Base, PSK (where both sides also share
+// a pre-shared key), Auth (where the sender is authenticated by its own
+// KEM key pair), and AuthPSK. Setting up a mode gives the sender a
+// Sender context and the recipient a Recipient context, which encrypt
+// and decrypt a sequence of messages, and can both export secrets
+// derived from the shared key. The single-shot Seal and Open functions
+// handle a single message.
+package hpke
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/kem"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrUnsupported is returned when a suite uses an unknown KDF or
+	// AEAD, or a mode its KEM can't provide.
+	ErrUnsupported = errors.New("hpke: unsupported suite or mode")
+
+	// ErrInvalidPSK is returned when the pre-shared key and its
+	// identifier are missing, or are given for a mode that doesn't use
+	// them.
+	ErrInvalidPSK = errors.New("hpke: invalid pre-shared key")
+
+	// ErrEncrypt is returned when a message can't be encrypted.
+	ErrEncrypt = errors.New("hpke: encryption failed")
+
+	// ErrDecrypt is returned when a message can't be decrypted.
+	ErrDecrypt = errors.New("hpke: decryption failed")
+
+	// ErrMessageLimit is returned when a context has used all of its
+	// nonces.
+	ErrMessageLimit = errors.New("hpke: message limit reached")
+
+	// ErrExportOnly is returned when an export-only context is used to
+	// encrypt or decrypt.
+	ErrExportOnly = errors.New("hpke: context is export-only")
+
+	// ErrExportLength is returned when too long a secret is requested
+	// from Export.
+	ErrExportLength = errors.New("hpke: export length too large")
+)
+
+// A Mode selects how the sender and the shared key are authenticated.
+type Mode byte
+
+// The HPKE modes.
+const (
+	ModeBase    Mode = 0x00
+	ModePSK     Mode = 0x01
+	ModeAuth    Mode = 0x02
+	ModeAuthPSK Mode = 0x03
+)
+
+// MinPSKSize is the minimum size of a pre-shared key.
+const MinPSKSize = 32
+
+// A KDF identifies an HPKE key derivation function.
+type KDF uint16
+
+// The supported KDFs.
+const (
+	HKDFSHA256 KDF = 0x0001
+	HKDFSHA384 KDF = 0x0002
+	HKDFSHA512 KDF = 0x0003
+)
+
+func (kdf KDF) hash() func() hash.Hash {
+	switch kdf {
+	case HKDFSHA256:
+		return sha256.New
+	case HKDFSHA384:
+		return sha512.New384
+	case HKDFSHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// An AEAD identifies an HPKE AEAD.
+type AEAD uint16
+
+// The supported AEADs. An ExportOnly context can't encrypt messages,
+// but can export secrets.
+const (
+	AES128GCM        AEAD = 0x0001
+	AES256GCM        AEAD = 0x0002
+	ChaCha20Poly1305 AEAD = 0x0003
+	ExportOnly       AEAD = 0xffff
+)
+
+// keySize returns Nk, the AEAD's key size.
+func (a AEAD) keySize() int {
+	switch a {
+	case AES128GCM:
+		return 16
+	case AES256GCM, ChaCha20Poly1305:
+		return 32
+	default:
+		return 0
+	}
+}
+
+const nonceSize = 12
+
+func (a AEAD) new(key []byte) (cipher.AEAD, error) {
+	switch a {
+	case AES128GCM, AES256GCM:
+		c, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(c)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, ErrExportOnly
+	}
+}
+
+// A Suite is a combination of KEM, KDF and AEAD.
+type Suite struct {
+	kem  kem.KEM
+	kdf  KDF
+	aead AEAD
+}
+
+// NewSuite returns a suite, checking that the KDF and AEAD are
+// supported. Any KEM can be used for the Base and PSK modes; the Auth
+// modes need a kem.AuthKEM, such as the DHKEMs.
+func NewSuite(k kem.KEM, kdf KDF, aead AEAD) (*Suite, error) {
+	if k == nil || kdf.hash() == nil {
+		return nil, ErrUnsupported
+	}
+
+	if aead != ExportOnly && aead.keySize() == 0 {
+		return nil, ErrUnsupported
+	}
+	return &Suite{kem: k, kdf: kdf, aead: aead}, nil
+}
+
+// KEM returns the suite's KEM.
+func (s *Suite) KEM() kem.KEM { return s.kem }
+
+// suiteID identifies the suite in the key schedule.
+func (s *Suite) suiteID() []byte {
+	id := []byte("HPKE")
+	id = binary.BigEndian.AppendUint16(id, s.kem.ID())
+	id = binary.BigEndian.AppendUint16(id, uint16(s.kdf))
+	return binary.BigEndian.AppendUint16(id, uint16(s.aead))
+}
+
+func (s *Suite) labeledExtract(salt []byte, label string, ikm []byte) []byte {
+	in := append([]byte("HPKE-v1"), s.suiteID()...)
+	in = append(in, label...)
+	in = append(in, ikm...)
+	return hkdf.Extract(s.kdf.hash(), in, salt)
+}
+
+func (s *Suite) labeledExpand(prk []byte, label string, info []byte, length int) []byte {
+	in := binary.BigEndian.AppendUint16(nil, uint16(length))
+	in = append(in, "HPKE-v1"...)
+	in = append(in, s.suiteID()...)
+	in = append(in, label...)
+	in = append(in, info...)
+
+	out := make([]byte, length)
+	hkdf.Expand(s.kdf.hash(), prk, in).Read(out)
+	return out
+}
+
+func verifyPSK(mode Mode, psk, pskID []byte) error {
+	gotPSK, gotPSKID := len(psk) > 0, len(pskID) > 0
+	if gotPSK != gotPSKID {
+		return ErrInvalidPSK
+	}
+
+	usesPSK := mode == ModePSK || mode == ModeAuthPSK
+	if gotPSK != usesPSK {
+		return ErrInvalidPSK
+	}
+
+	if usesPSK && len(psk) < MinPSKSize {
+		return ErrInvalidPSK
+	}
+	return nil
+}
+
+// keySchedule derives a context from the KEM shared secret.
+func (s *Suite) keySchedule(mode Mode, shared, info, psk, pskID []byte) (*context, error) {
+	if err := verifyPSK(mode, psk, pskID); err != nil {
+		return nil, err
+	}
+
+	pskIDHash := s.labeledExtract(nil, "psk_id_hash", pskID)
+	infoHash := s.labeledExtract(nil, "info_hash", info)
+	ksContext := append([]byte{byte(mode)}, pskIDHash...)
+	ksContext = append(ksContext, infoHash...)
+
+	secret := s.labeledExtract(shared, "secret", psk)
+	defer util.Zero(secret)
+
+	ctx := &context{
+		suite:    s,
+		exporter: s.labeledExpand(secret, "exp", ksContext, s.kdf.hash()().Size()),
+	}
+
+	if s.aead == ExportOnly {
+		return ctx, nil
+	}
+
+	key := s.labeledExpand(secret, "key", ksContext, s.aead.keySize())
+	defer util.Zero(key)
+
+	var err error
+	if ctx.aead, err = s.aead.new(key); err != nil {
+		return nil, err
+	}
+	ctx.baseNonce = s.labeledExpand(secret, "base_nonce", ksContext, nonceSize)
+	return ctx, nil
+}
+
+func (s *Suite) authKEM() (kem.AuthKEM, error) {
+	k, ok := s.kem.(kem.AuthKEM)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return k, nil
+}
+
+// setupS runs the sender's side of the setup for any mode; skS is only
+// used in, and is required by, the Auth modes.
+func (s *Suite) setupS(mode Mode, pkR kem.PublicKey, info, psk, pskID []byte, skS kem.PrivateKey) ([]byte, *Sender, error) {
+	var shared, enc []byte
+	var err error
+	if mode == ModeAuth || mode == ModeAuthPSK {
+		k, err := s.authKEM()
+		if err != nil {
+			return nil, nil, err
+		} else if skS == nil {
+			return nil, nil, kem.ErrInvalidKey
+		}
+		shared, enc, err = k.AuthEncapsulate(pkR, skS)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		shared, enc, err = s.kem.Encapsulate(pkR)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	defer util.Zero(shared)
+
+	ctx, err := s.keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, &Sender{ctx}, nil
+}
+
+// setupR runs the recipient's side of the setup for any mode; pkS is
+// only used in, and is required by, the Auth modes. Without it, the
+// recipient would accept messages from any sender.
+func (s *Suite) setupR(mode Mode, enc []byte, skR kem.PrivateKey, info, psk, pskID []byte, pkS kem.PublicKey) (*Recipient, error) {
+	var shared []byte
+	var err error
+	if mode == ModeAuth || mode == ModeAuthPSK {
+		k, err := s.authKEM()
+		if err != nil {
+			return nil, err
+		} else if pkS == nil {
+			return nil, kem.ErrInvalidKey
+		}
+		shared, err = k.AuthDecapsulate(skR, enc, pkS)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		shared, err = s.kem.Decapsulate(skR, enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer util.Zero(shared)
+
+	ctx, err := s.keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{ctx}, nil
+}
+
+// SetupBaseS sets up a Base mode sender, returning the encapsulation
+// to send to the recipient.
+func (s *Suite) SetupBaseS(pkR kem.PublicKey, info []byte) ([]byte, *Sender, error) {
+	return s.setupS(ModeBase, pkR, info, nil, nil, nil)
+}
+
+// SetupBaseR sets up a Base mode recipient from the sender's
+// encapsulation.
+func (s *Suite) SetupBaseR(enc []byte, skR kem.PrivateKey, info []byte) (*Recipient, error) {
+	return s.setupR(ModeBase, enc, skR, info, nil, nil, nil)
+}
+
+// SetupPSKS sets up a PSK mode sender. The pre-shared key must be at
+// least MinPSKSize bytes, and the recipient must use the same key and
+// identifier.
+func (s *Suite) SetupPSKS(pkR kem.PublicKey, info, psk, pskID []byte) ([]byte, *Sender, error) {
+	return s.setupS(ModePSK, pkR, info, psk, pskID, nil)
+}
+
+// SetupPSKR sets up a PSK mode recipient.
+func (s *Suite) SetupPSKR(enc []byte, skR kem.PrivateKey, info, psk, pskID []byte) (*Recipient, error) {
+	return s.setupR(ModePSK, enc, skR, info, psk, pskID, nil)
+}
+
+// SetupAuthS sets up an Auth mode sender, authenticated by the
+// sender's private key skS.
+func (s *Suite) SetupAuthS(pkR kem.PublicKey, info []byte, skS kem.PrivateKey) ([]byte, *Sender, error) {
+	return s.setupS(ModeAuth, pkR, info, nil, nil, skS)
+}
+
+// SetupAuthR sets up an Auth mode recipient, which only succeeds in
+// decrypting messages from the holder of the private key for pkS.
+func (s *Suite) SetupAuthR(enc []byte, skR kem.PrivateKey, info []byte, pkS kem.PublicKey) (*Recipient, error) {
+	return s.setupR(ModeAuth, enc, skR, info, nil, nil, pkS)
+}
+
+// SetupAuthPSKS sets up an AuthPSK mode sender.
+func (s *Suite) SetupAuthPSKS(pkR kem.PublicKey, info, psk, pskID []byte, skS kem.PrivateKey) ([]byte, *Sender, error) {
+	return s.setupS(ModeAuthPSK, pkR, info, psk, pskID, skS)
+}
+
+// SetupAuthPSKR sets up an AuthPSK mode recipient.
+func (s *Suite) SetupAuthPSKR(enc []byte, skR kem.PrivateKey, info, psk, pskID []byte, pkS kem.PublicKey) (*Recipient, error) {
+	return s.setupR(ModeAuthPSK, enc, skR, info, psk, pskID, pkS)
+}
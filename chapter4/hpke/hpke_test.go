@@ -0,0 +1,672 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/sha3"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/kem"
+)
+
+var (
+	testMessage = []byte("do not go gentle into that good night")
+	testInfo    = []byte("hpke test")
+	testAAD     = []byte("rage, rage against the dying of the light")
+	testPSK     = bytes.Repeat([]byte{0x42}, 32)
+	testPSKID   = []byte("test psk")
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return b
+}
+
+// drawRandomInput reads a length byte, then that many bytes, from r.
+func drawRandomInput(t *testing.T, r io.Reader) []byte {
+	var l [1]byte
+	if _, err := r.Read(l[:]); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	b := make([]byte, int(l[0]))
+	if _, err := r.Read(b); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return b
+}
+
+// TestVectors checks the Base mode test vectors from RFC 9180, in the
+// condensed form used by the Go project: instead of listing each
+// ciphertext and exported value, each vector gives a SHAKE128 hash of
+// 1000 of them, computed over inputs drawn from SHAKE128.
+func TestVectors(t *testing.T) {
+	in, err := os.ReadFile("testdata/rfc9180.json")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var vectors []struct {
+		Mode           Mode   `json:"mode"`
+		KEM            uint16 `json:"kem_id"`
+		KDF            KDF    `json:"kdf_id"`
+		AEAD           AEAD   `json:"aead_id"`
+		Info           string `json:"info"`
+		IKME           string `json:"ikmE"`
+		IKMR           string `json:"ikmR"`
+		SKRm           string `json:"skRm"`
+		PKRm           string `json:"pkRm"`
+		Enc            string `json:"enc"`
+		AccEncryptions string `json:"encryptions_accumulated"`
+		AccExports     string `json:"exports_accumulated"`
+	}
+	if err = json.Unmarshal(in, &vectors); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, v := range vectors {
+		name := fmt.Sprintf("kem %04x kdf %04x aead %04x", v.KEM, v.KDF, v.AEAD)
+
+		k, err := kem.ByID(v.KEM)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		suite, err := NewSuite(k, v.KDF, v.AEAD)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		skR, err := k.DeriveKeyPair(unhex(t, v.IKMR))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(skR.Bytes(), unhex(t, v.SKRm)) || !bytes.Equal(skR.Public().Bytes(), unhex(t, v.PKRm)) {
+			t.Fatalf("%s: derived recipient key doesn't match", name)
+		}
+
+		skE, err := k.DeriveKeyPair(unhex(t, v.IKME))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		enc := unhex(t, v.Enc)
+		if !bytes.Equal(skE.Public().Bytes(), enc) {
+			t.Fatalf("%s: derived ephemeral key doesn't match", name)
+		}
+
+		// The vectors fix the ephemeral key, so the sender's context
+		// is built directly from the recipient's shared secret.
+		shared, err := k.Decapsulate(skR, enc)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		ctx, err := suite.keySchedule(v.Mode, shared, unhex(t, v.Info), nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sender := &Sender{ctx}
+
+		recipient, err := suite.SetupBaseR(enc, skR, unhex(t, v.Info))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if v.AEAD != ExportOnly {
+			source, sink := sha3.NewSHAKE128(), sha3.NewSHAKE128()
+			for i := 0; i < 1000; i++ {
+				aad, pt := drawRandomInput(t, source), drawRandomInput(t, source)
+				ct, err := sender.Seal(aad, pt)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				sink.Write(ct)
+
+				out, err := recipient.Open(aad, ct)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				if !bytes.Equal(out, pt) {
+					t.Fatalf("%s: recovered message doesn't match original", name)
+				}
+			}
+
+			acc := make([]byte, 16)
+			sink.Read(acc)
+			if !bytes.Equal(acc, unhex(t, v.AccEncryptions)) {
+				t.Fatalf("%s: encryptions don't match", name)
+			}
+		} else if _, err = sender.Seal(nil, testMessage); err != ErrExportOnly {
+			t.Fatalf("%s: expected an export-only context to refuse to encrypt", name)
+		}
+
+		source, sink := sha3.NewSHAKE128(), sha3.NewSHAKE128()
+		for l := 0; l < 1000; l++ {
+			exporterContext := drawRandomInput(t, source)
+			value, err := sender.Export(exporterContext, l)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			sink.Write(value)
+
+			other, err := recipient.Export(exporterContext, l)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !bytes.Equal(value, other) {
+				t.Fatalf("%s: exported secrets don't match", name)
+			}
+		}
+
+		acc := make([]byte, 16)
+		sink.Read(acc)
+		if !bytes.Equal(acc, unhex(t, v.AccExports)) {
+			t.Fatalf("%s: exports don't match", name)
+		}
+	}
+}
+
+// TestAppendixA checks the PSK, Auth and AuthPSK mode test vectors for
+// the suites in RFC 9180 Appendix A. They are copied from the CFRG
+// test-vectors.json (commit 5f503c5) that the appendix was generated
+// from, keeping only the encryptions the appendix lists.
+func TestAppendixA(t *testing.T) {
+	in, err := os.ReadFile("testdata/rfc9180-appendix-a.json")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var vectors []struct {
+		Mode        Mode   `json:"mode"`
+		KEM         uint16 `json:"kem_id"`
+		KDF         KDF    `json:"kdf_id"`
+		AEAD        AEAD   `json:"aead_id"`
+		Info        string `json:"info"`
+		IKME        string `json:"ikmE"`
+		IKMR        string `json:"ikmR"`
+		IKMS        string `json:"ikmS"`
+		SKRm        string `json:"skRm"`
+		PKRm        string `json:"pkRm"`
+		SKSm        string `json:"skSm"`
+		PKSm        string `json:"pkSm"`
+		PSK         string `json:"psk"`
+		PSKID       string `json:"psk_id"`
+		Enc         string `json:"enc"`
+		Shared      string `json:"shared_secret"`
+		BaseNonce   string `json:"base_nonce"`
+		Exporter    string `json:"exporter_secret"`
+		Encryptions []struct {
+			AAD   string `json:"aad"`
+			CT    string `json:"ct"`
+			Nonce string `json:"nonce"`
+			PT    string `json:"pt"`
+		} `json:"encryptions"`
+		Exports []struct {
+			Context string `json:"exporter_context"`
+			L       int    `json:"L"`
+			Value   string `json:"exported_value"`
+		} `json:"exports"`
+	}
+	if err = json.Unmarshal(in, &vectors); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, v := range vectors {
+		name := fmt.Sprintf("mode %d kem %04x kdf %04x aead %04x", v.Mode, v.KEM, v.KDF, v.AEAD)
+
+		k, err := kem.ByID(v.KEM)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		suite, err := NewSuite(k, v.KDF, v.AEAD)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		skR, err := k.DeriveKeyPair(unhex(t, v.IKMR))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(skR.Bytes(), unhex(t, v.SKRm)) || !bytes.Equal(skR.Public().Bytes(), unhex(t, v.PKRm)) {
+			t.Fatalf("%s: derived recipient key doesn't match", name)
+		}
+
+		skE, err := k.DeriveKeyPair(unhex(t, v.IKME))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		enc := unhex(t, v.Enc)
+		if !bytes.Equal(skE.Public().Bytes(), enc) {
+			t.Fatalf("%s: derived ephemeral key doesn't match", name)
+		}
+
+		info, psk, pskID := unhex(t, v.Info), unhex(t, v.PSK), unhex(t, v.PSKID)
+
+		var shared []byte
+		var recipient *Recipient
+		switch v.Mode {
+		case ModePSK:
+			shared, err = k.Decapsulate(skR, enc)
+			if err == nil {
+				recipient, err = suite.SetupPSKR(enc, skR, info, psk, pskID)
+			}
+		case ModeAuth, ModeAuthPSK:
+			skS, err := k.DeriveKeyPair(unhex(t, v.IKMS))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !bytes.Equal(skS.Bytes(), unhex(t, v.SKSm)) || !bytes.Equal(skS.Public().Bytes(), unhex(t, v.PKSm)) {
+				t.Fatalf("%s: derived sender key doesn't match", name)
+			}
+
+			shared, err = k.(kem.AuthKEM).AuthDecapsulate(skR, enc, skS.Public())
+			if err == nil && v.Mode == ModeAuth {
+				recipient, err = suite.SetupAuthR(enc, skR, info, skS.Public())
+			} else if err == nil {
+				recipient, err = suite.SetupAuthPSKR(enc, skR, info, psk, pskID, skS.Public())
+			}
+		default:
+			t.Fatalf("%s: unexpected mode", name)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(shared, unhex(t, v.Shared)) {
+			t.Fatalf("%s: shared secret doesn't match", name)
+		}
+
+		// As in TestVectors, the sender's context is built directly
+		// from the shared secret.
+		ctx, err := suite.keySchedule(v.Mode, shared, info, psk, pskID)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sender := &Sender{ctx}
+
+		if !bytes.Equal(ctx.exporter, unhex(t, v.Exporter)) || !bytes.Equal(ctx.baseNonce, unhex(t, v.BaseNonce)) {
+			t.Fatalf("%s: key schedule doesn't match", name)
+		}
+
+		for _, e := range v.Encryptions {
+			// The sequence number is recovered from the nonce.
+			nonce := unhex(t, e.Nonce)
+			for i := range nonce {
+				nonce[i] ^= ctx.baseNonce[i]
+			}
+			seq := binary.BigEndian.Uint64(nonce[nonceSize-8:])
+			sender.ctx.seq, recipient.ctx.seq = seq, seq
+
+			aad, pt := unhex(t, e.AAD), unhex(t, e.PT)
+			ct, err := sender.Seal(aad, pt)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !bytes.Equal(ct, unhex(t, e.CT)) {
+				t.Fatalf("%s: ciphertext %d doesn't match", name, seq)
+			}
+
+			out, err := recipient.Open(aad, ct)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !bytes.Equal(out, pt) {
+				t.Fatalf("%s: recovered message %d doesn't match original", name, seq)
+			}
+		}
+
+		for _, e := range v.Exports {
+			exporterContext := unhex(t, e.Context)
+			for _, c := range []*context{sender.ctx, recipient.ctx} {
+				value, err := c.export(exporterContext, e.L)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				if !bytes.Equal(value, unhex(t, e.Value)) {
+					t.Fatalf("%s: exported value doesn't match", name)
+				}
+			}
+		}
+	}
+}
+
+// setup creates a sender and recipient for the mode.
+func setup(t *testing.T, suite *Suite, mode Mode, skR, skS kem.PrivateKey) (*Sender, *Recipient) {
+	var enc []byte
+	var sender *Sender
+	var recipient *Recipient
+	var err error
+
+	pkR, pkS := skR.Public(), skS.Public()
+	switch mode {
+	case ModeBase:
+		enc, sender, err = suite.SetupBaseS(pkR, testInfo)
+		if err == nil {
+			recipient, err = suite.SetupBaseR(enc, skR, testInfo)
+		}
+	case ModePSK:
+		enc, sender, err = suite.SetupPSKS(pkR, testInfo, testPSK, testPSKID)
+		if err == nil {
+			recipient, err = suite.SetupPSKR(enc, skR, testInfo, testPSK, testPSKID)
+		}
+	case ModeAuth:
+		enc, sender, err = suite.SetupAuthS(pkR, testInfo, skS)
+		if err == nil {
+			recipient, err = suite.SetupAuthR(enc, skR, testInfo, pkS)
+		}
+	case ModeAuthPSK:
+		enc, sender, err = suite.SetupAuthPSKS(pkR, testInfo, testPSK, testPSKID, skS)
+		if err == nil {
+			recipient, err = suite.SetupAuthPSKR(enc, skR, testInfo, testPSK, testPSKID, pkS)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(enc) != suite.KEM().EncapsulationSize() {
+		t.Fatal("encapsulation has the wrong length")
+	}
+	return sender, recipient
+}
+
+func TestModes(t *testing.T) {
+	kems := []kem.KEM{kem.X25519(), kem.P256(), kem.P384()}
+	kdfs := []KDF{HKDFSHA256, HKDFSHA384, HKDFSHA512}
+	aeads := []AEAD{AES256GCM, ChaCha20Poly1305}
+
+	for _, k := range kems {
+		skR, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		skS, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		for _, kdf := range kdfs {
+			for _, aead := range aeads {
+				suite, err := NewSuite(k, kdf, aead)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+
+				for mode := ModeBase; mode <= ModeAuthPSK; mode++ {
+					sender, recipient := setup(t, suite, mode, skR, skS)
+					for i := 0; i < 3; i++ {
+						ct, err := sender.Seal(testAAD, testMessage)
+						if err != nil {
+							t.Fatalf("%v", err)
+						}
+
+						out, err := recipient.Open(testAAD, ct)
+						if err != nil {
+							t.Fatalf("%v", err)
+						}
+
+						if !bytes.Equal(out, testMessage) {
+							t.Fatal("recovered message doesn't match original")
+						}
+					}
+
+					if sender.Seq() != 3 || recipient.Seq() != 3 {
+						t.Fatal("sequence numbers weren't advanced")
+					}
+
+					se, _ := sender.Export([]byte("context"), 64)
+					re, _ := recipient.Export([]byte("context"), 64)
+					if !bytes.Equal(se, re) {
+						t.Fatal("exported secrets don't match")
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	suite, err := NewSuite(kem.X25519(), HKDFSHA256, ChaCha20Poly1305)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	skR, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sender, recipient := setup(t, suite, ModeBase, skR, skR)
+	first, err := sender.Seal(nil, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	second, err := sender.Seal(nil, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Messages must be opened in order, and a failure doesn't advance
+	// the recipient.
+	if _, err = recipient.Open(nil, second); err != ErrDecrypt {
+		t.Fatal("expected an out-of-order message to fail")
+	}
+
+	if _, err = recipient.Open(testAAD, first); err != ErrDecrypt {
+		t.Fatal("expected the wrong additional data to fail")
+	}
+
+	for _, ct := range [][]byte{first, second} {
+		if _, err = recipient.Open(nil, ct); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	if _, err = recipient.Open(nil, first); err != ErrDecrypt {
+		t.Fatal("expected a replayed message to fail")
+	}
+
+	if _, err = sender.Export(nil, 255*32+1); err != ErrExportLength {
+		t.Fatal("expected an overlong export to fail")
+	}
+
+	sender.ctx.seq = 1<<64 - 1
+	if _, err = sender.Seal(nil, testMessage); err != ErrMessageLimit {
+		t.Fatal("expected an exhausted context to fail")
+	}
+}
+
+func TestSingleShot(t *testing.T) {
+	suite, err := NewSuite(kem.P256(), HKDFSHA256, AES256GCM)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	skR, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	skS, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	other, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pkR, pkS := skR.Public(), skS.Public()
+
+	enc, ct, err := suite.Seal(pkR, testInfo, testAAD, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := suite.Open(enc, skR, testInfo, testAAD, ct)
+	if err != nil || !bytes.Equal(out, testMessage) {
+		t.Fatal("failed to open a Base mode message")
+	}
+
+	if _, err = suite.Open(enc, skR, []byte("other info"), testAAD, ct); err != ErrDecrypt {
+		t.Fatal("expected the wrong info to fail")
+	}
+
+	enc, ct, err = suite.SealPSK(pkR, testInfo, testAAD, testMessage, testPSK, testPSKID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = suite.OpenPSK(enc, skR, testInfo, testAAD, ct, testPSK, testPSKID); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	wrongPSK := bytes.Repeat([]byte{0x24}, 32)
+	if _, err = suite.OpenPSK(enc, skR, testInfo, testAAD, ct, wrongPSK, testPSKID); err != ErrDecrypt {
+		t.Fatal("expected the wrong pre-shared key to fail")
+	}
+
+	enc, ct, err = suite.SealAuth(pkR, testInfo, testAAD, testMessage, skS)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = suite.OpenAuth(enc, skR, testInfo, testAAD, ct, pkS); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = suite.OpenAuth(enc, skR, testInfo, testAAD, ct, other.Public()); err != ErrDecrypt {
+		t.Fatal("expected the wrong sender to fail")
+	}
+
+	// An Auth mode message can't be opened as a Base mode one.
+	if _, err = suite.Open(enc, skR, testInfo, testAAD, ct); err != ErrDecrypt {
+		t.Fatal("expected an Auth mode message to fail in Base mode")
+	}
+
+	enc, ct, err = suite.SealAuthPSK(pkR, testInfo, testAAD, testMessage, testPSK, testPSKID, skS)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err = suite.OpenAuthPSK(enc, skR, testInfo, testAAD, ct, testPSK, testPSKID, pkS)
+	if err != nil || !bytes.Equal(out, testMessage) {
+		t.Fatal("failed to open an AuthPSK mode message")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := NewSuite(kem.X25519(), KDF(0x0010), AES256GCM); err != ErrUnsupported {
+		t.Fatal("expected an unknown KDF to fail")
+	}
+
+	if _, err := NewSuite(kem.X25519(), HKDFSHA256, AEAD(0x0004)); err != ErrUnsupported {
+		t.Fatal("expected an unknown AEAD to fail")
+	}
+
+	suite, err := NewSuite(kem.X25519(), HKDFSHA256, AES256GCM)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	skR, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pkR := skR.Public()
+
+	for _, tc := range []struct{ psk, pskID []byte }{
+		{nil, nil},
+		{testPSK, nil},
+		{nil, testPSKID},
+		{testPSK[:16], testPSKID},
+	} {
+		if _, _, err = suite.SetupPSKS(pkR, testInfo, tc.psk, tc.pskID); err != ErrInvalidPSK {
+			t.Fatal("expected an invalid pre-shared key to fail")
+		}
+	}
+
+	// The Auth modes need the sender's key on both sides; without it,
+	// the recipient would accept a message from anyone.
+	skS, err := suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, _, err = suite.SealAuth(pkR, testInfo, testAAD, testMessage, nil); err != kem.ErrInvalidKey {
+		t.Fatalf("expected kem.ErrInvalidKey without a sender key, have %v", err)
+	}
+
+	if _, _, err = suite.SetupAuthPSKS(pkR, testInfo, testPSK, testPSKID, nil); err != kem.ErrInvalidKey {
+		t.Fatalf("expected kem.ErrInvalidKey without a sender key, have %v", err)
+	}
+
+	enc, ct, err := suite.SealAuth(pkR, testInfo, testAAD, testMessage, skS)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = suite.OpenAuth(enc, skR, testInfo, testAAD, ct, nil); err != kem.ErrInvalidKey {
+		t.Fatalf("expected kem.ErrInvalidKey without a sender key, have %v", err)
+	}
+
+	if _, err = suite.SetupAuthPSKR(enc, skR, testInfo, testPSK, testPSKID, nil); err != kem.ErrInvalidKey {
+		t.Fatalf("expected kem.ErrInvalidKey without a sender key, have %v", err)
+	}
+
+	enc, ct, err = suite.Seal(pkR, testInfo, testAAD, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = suite.OpenAuth(enc, skR, testInfo, testAAD, ct, nil); err != kem.ErrInvalidKey {
+		t.Fatalf("expected kem.ErrInvalidKey for a Base mode message, have %v", err)
+	}
+
+	// X-Wing works in Base and PSK mode, but has no Auth mode.
+	suite, err = NewSuite(kem.XWing(), HKDFSHA256, AES256GCM)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	skR, err = suite.KEM().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sender, recipient := setup(t, suite, ModePSK, skR, skR)
+	ct, err = sender.Seal(nil, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = recipient.Open(nil, ct); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, _, err = suite.SetupAuthS(skR.Public(), testInfo, skR); err != ErrUnsupported {
+		t.Fatal("expected Auth mode with X-Wing to fail")
+	}
+}
@@ -0,0 +1,349 @@
+// Package jwe implements the compact serialisation of JSON Web
+// Encryption (RFC 7516) for a small set of algorithms: direct
+// encryption with a shared key ("dir"), and ephemeral-static ECDH on
+// the NIST curves, either used directly ("ECDH-ES") or to wrap a
+// content key ("ECDH-ES+A256KW"). Content is always encrypted with
+// AES-256-GCM ("A256GCM").
+//
+// The algorithm is chosen by the function that is called, and the
+// "alg" and "enc" header values are checked against it on decryption;
+// a token is never processed with an algorithm the caller didn't ask
+// for. Tokens using compression ("zip") or critical extensions
+// ("crit") are rejected, since neither is supported.
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+// Supported algorithms.
+const (
+	AlgDirect       = "dir"
+	AlgECDHES       = "ECDH-ES"
+	AlgECDHESA256KW = "ECDH-ES+A256KW"
+	EncA256GCM      = "A256GCM"
+)
+
+// tagSize is the size of the GCM authentication tag.
+const tagSize = 16
+
+var (
+	// ErrEncrypt is returned when encryption fails.
+	ErrEncrypt = errors.New("jwe: encryption failed")
+
+	// ErrDecrypt is returned when decryption fails.
+	ErrDecrypt = errors.New("jwe: decryption failed")
+
+	// ErrMalformed is returned when a token isn't a well-formed
+	// compact JWE.
+	ErrMalformed = errors.New("jwe: malformed token")
+
+	// ErrUnsupported is returned when the token's algorithms aren't
+	// the ones expected.
+	ErrUnsupported = errors.New("jwe: unsupported algorithm")
+)
+
+// A Header is the JOSE header of a JWE.
+type Header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+	Cty string `json:"cty,omitempty"`
+	Epk *JWK   `json:"epk,omitempty"`
+	Apu string `json:"apu,omitempty"`
+	Apv string `json:"apv,omitempty"`
+}
+
+// A token holds the five parts of a compact JWE. The raw header is
+// kept as it was received, since it is the additional data for the
+// content encryption.
+type token struct {
+	rawHeader    string
+	header       *Header
+	encryptedKey []byte
+	iv           []byte
+	ciphertext   []byte
+	tag          []byte
+}
+
+func parse(in string) (*token, error) {
+	parts := strings.Split(in, ".")
+	if len(parts) != 5 {
+		return nil, ErrMalformed
+	}
+
+	var decoded [5][]byte
+	for i := range parts {
+		var err error
+		decoded[i], err = b64.DecodeString(parts[i])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+
+	tok := &token{
+		rawHeader:    parts[0],
+		header:       &Header{},
+		encryptedKey: decoded[1],
+		iv:           decoded[2],
+		ciphertext:   decoded[3],
+		tag:          decoded[4],
+	}
+
+	if err := json.Unmarshal(decoded[0], tok.header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	// Header parameters that aren't in Header would otherwise be
+	// dropped. A compressed token would decrypt to the compressed
+	// bytes, and RFC 7516 requires a token with critical extensions
+	// that aren't understood to be rejected.
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(decoded[0], &params); err != nil {
+		return nil, ErrMalformed
+	}
+
+	for _, name := range []string{"zip", "crit"} {
+		if _, ok := params[name]; ok {
+			return nil, ErrUnsupported
+		}
+	}
+
+	if tok.header.Enc != EncA256GCM {
+		return nil, ErrUnsupported
+	}
+
+	if len(tok.iv) != aesgcm.NonceSize || len(tok.tag) != tagSize {
+		return nil, ErrMalformed
+	}
+
+	return tok, nil
+}
+
+// ParseHeader returns the header of a compact JWE without decrypting
+// it, for example to select a key using the "kid" value. Nothing in
+// the header is authenticated until the token has been decrypted.
+func ParseHeader(in string) (*Header, error) {
+	tok, err := parse(in)
+	if err != nil {
+		return nil, err
+	}
+	return tok.header, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != aesgcm.KeySize {
+		return nil, ErrEncrypt
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// seal encrypts the message under the content encryption key, and
+// serialises the token.
+func seal(hdr *Header, cek, encryptedKey, message []byte) (string, error) {
+	hbs, err := json.Marshal(hdr)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+	rawHeader := b64.EncodeToString(hbs)
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	iv, err := aesgcm.GenerateNonce()
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	// The ASCII encoding of the protected header is the additional
+	// data for the content encryption.
+	ct := gcm.Seal(nil, iv, message, []byte(rawHeader))
+	tag := ct[len(ct)-tagSize:]
+	ct = ct[:len(ct)-tagSize]
+
+	return strings.Join([]string{
+		rawHeader,
+		b64.EncodeToString(encryptedKey),
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// open decrypts the token's content with the content encryption key.
+func (tok *token) open(cek []byte) ([]byte, error) {
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	ct := make([]byte, 0, len(tok.ciphertext)+len(tok.tag))
+	ct = append(ct, tok.ciphertext...)
+	ct = append(ct, tok.tag...)
+
+	out, err := gcm.Open(nil, tok.iv, ct, []byte(tok.rawHeader))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// EncryptDirect secures a message using the shared AES-256 key
+// directly as the content encryption key.
+func EncryptDirect(key, message []byte) (string, error) {
+	hdr := &Header{Alg: AlgDirect, Enc: EncA256GCM}
+	return seal(hdr, key, nil, message)
+}
+
+// DecryptDirect recovers a message from a "dir" token using the
+// shared key.
+func DecryptDirect(key []byte, in string) ([]byte, error) {
+	tok, err := parse(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.header.Alg != AlgDirect {
+		return nil, ErrUnsupported
+	}
+
+	// The encrypted key must be empty when using direct encryption.
+	if len(tok.encryptedKey) != 0 {
+		return nil, ErrMalformed
+	}
+
+	return tok.open(key)
+}
+
+// EncryptECDH secures a message to the peer's public key using an
+// ephemeral key pair on the same curve. The alg argument selects
+// whether the agreed key is used as the content encryption key
+// (AlgECDHES) or wraps a random one (AlgECDHESA256KW). A missing or
+// invalid peer key returns one of nistecdh's key errors.
+func EncryptECDH(peer *ecdsa.PublicKey, alg string, message []byte) (string, error) {
+	if alg != AlgECDHES && alg != AlgECDHESA256KW {
+		return "", ErrUnsupported
+	}
+
+	if err := nistecdh.CheckPublicKey(peer); err != nil {
+		return "", err
+	}
+
+	eph, err := ecdsa.GenerateKey(peer.Curve, rand.Reader)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	epk, err := NewPublicJWK(&eph.PublicKey)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	z, err := nistecdh.SharedSecret(eph, peer)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+	defer util.Zero(z)
+
+	hdr := &Header{Alg: alg, Enc: EncA256GCM, Epk: epk}
+	if alg == AlgECDHES {
+		cek := concatKDF(z, EncA256GCM, nil, nil, aesgcm.KeySize)
+		defer util.Zero(cek)
+		return seal(hdr, cek, nil, message)
+	}
+
+	kek := concatKDF(z, alg, nil, nil, aesgcm.KeySize)
+	defer util.Zero(kek)
+
+	cek, err := aesgcm.GenerateKey()
+	if err != nil {
+		return "", ErrEncrypt
+	}
+	defer util.Zero(cek)
+
+	wrapped, err := keyWrap(kek, cek)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	return seal(hdr, cek, wrapped, message)
+}
+
+// DecryptECDH recovers a message from an "ECDH-ES" or "ECDH-ES+A256KW"
+// token using the recipient's private key. The ephemeral public key
+// must be on the same curve as the private key.
+func DecryptECDH(priv *ecdsa.PrivateKey, in string) ([]byte, error) {
+	tok, err := parse(in)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := tok.header.Alg
+	if alg != AlgECDHES && alg != AlgECDHESA256KW {
+		return nil, ErrUnsupported
+	}
+
+	if tok.header.Epk == nil {
+		return nil, ErrMalformed
+	}
+
+	epk, err := tok.header.Epk.PublicKey()
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	apu, err := b64.DecodeString(tok.header.Apu)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	apv, err := b64.DecodeString(tok.header.Apv)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	z, err := nistecdh.SharedSecret(priv, epk)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	defer util.Zero(z)
+
+	if alg == AlgECDHES {
+		if len(tok.encryptedKey) != 0 {
+			return nil, ErrMalformed
+		}
+
+		cek := concatKDF(z, EncA256GCM, apu, apv, aesgcm.KeySize)
+		defer util.Zero(cek)
+		return tok.open(cek)
+	}
+
+	kek := concatKDF(z, alg, apu, apv, aesgcm.KeySize)
+	defer util.Zero(kek)
+
+	cek, err := keyUnwrap(kek, tok.encryptedKey)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	defer util.Zero(cek)
+
+	return tok.open(cek)
+}
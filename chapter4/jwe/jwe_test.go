@@ -0,0 +1,321 @@
+package jwe
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+// The example keys from RFC 7518, appendix C.
+var (
+	aliceJWK = []byte(`{"kty":"EC","crv":"P-256",
+"x":"gI0GAILBdu7T53akrFmMyGcsF3n5dO7MmwNBHKW5SV0",
+"y":"SLW_xSffzlPWrHEVI30DHM_4egVwt3NQqeUD7nMFpps",
+"d":"0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo"}`)
+	bobJWK = []byte(`{"kty":"EC","crv":"P-256",
+"x":"weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ",
+"y":"e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck",
+"d":"VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw"}`)
+)
+
+func mustPrivate(t *testing.T, in []byte) *ecdsa.PrivateKey {
+	jwk, err := ParseJWK(in)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	priv, err := jwk.PrivateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return priv
+}
+
+// TestConcatKDF checks the example in RFC 7518, appendix C.
+func TestConcatKDF(t *testing.T) {
+	alice := mustPrivate(t, aliceJWK)
+	bob := mustPrivate(t, bobJWK)
+
+	z, err := nistecdh.SharedSecret(bob, &alice.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []byte{86, 170, 141, 234, 248, 35, 109, 32,
+		92, 34, 40, 205, 113, 167, 16, 26}
+	key := concatKDF(z, "A128GCM", []byte("Alice"), []byte("Bob"), 16)
+	if !bytes.Equal(key, expected) {
+		t.Fatalf("have %x, want %x", key, expected)
+	}
+
+	if b64.EncodeToString(key) != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Fatal("derived key doesn't match the RFC")
+	}
+}
+
+// TestKeyWrap checks the 256-bit KEK vector from RFC 3394, section 4.6.
+func TestKeyWrap(t *testing.T) {
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	wrapped, err := keyWrap(kek, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(wrapped, expected) {
+		t.Fatalf("have %x, want %x", wrapped, expected)
+	}
+
+	unwrapped, err := keyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatal("unwrapped key doesn't match original")
+	}
+
+	wrapped[0] ^= 1
+	if _, err = keyUnwrap(kek, wrapped); err == nil {
+		t.Fatal("expected unwrap of a modified key to fail")
+	}
+}
+
+// The following tokens were produced by go-jose to check that tokens
+// from other implementations can be decrypted. The message is "Live
+// long and prosper."; the ECDH tokens are to Bob's key from RFC 7518.
+var (
+	interopMessage = []byte("Live long and prosper.")
+	interopKey     = []byte("0123456789abcdef0123456789abcdef")
+	interopDirect  = "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..Pk25OGr0SJ7gc0uX.KKe2Y1lFkPucIWyCul0vW9bzk2QOtA.Bi0ggo0WUAnBNV29F2RGhQ"
+	interopECDHES  = "eyJhbGciOiJFQ0RILUVTIiwiZW5jIjoiQTI1NkdDTSIsImVwayI6eyJrdHkiOiJFQyIsImNydiI6IlAtMjU2IiwieCI6IlMzaEJCNTZWNWV6WV9JMmg0dXBZRzZRbWk4SjJkYnRQN1BvN0w0ZUt1c0UiLCJ5IjoieGJRd2hsMWo1NWtydUpsWnJpVENoQTVMQy1IcWNfVVpubXprWk1qWENjbyJ9fQ..69aPHTcEJ0Nc1UVk.AM0-YEN8UPq0Rtsqaof6_-b0unqGvg.5mY0s1xzSRzWx0DGrQqUhw"
+	interopKW      = "eyJhbGciOiJFQ0RILUVTK0EyNTZLVyIsImVuYyI6IkEyNTZHQ00iLCJlcGsiOnsia3R5IjoiRUMiLCJjcnYiOiJQLTI1NiIsIngiOiJfemdFMHJ6S04wSVVjakFvM0tDS3NhZHRwYjl0UjQzalhqMl9Mdko4VmRBIiwieSI6InFCeHVUT0dFQUZNOUpCNld0MGNKNXZDX0daSHVIZzg5SGN3Xzc3Q3pmSDgifX0.uTbWGhL14MLuoHLDlQNoD77K4EqPGaEXwS3DcCLAOW3NNMH5aVZd3Q.QEQNHRacGk1z3swx.0vbO2Zha-NoQGWsUyNRvzjchPqy4KQ.1Mp6yrX1ZdvIVAztURFVCA"
+)
+
+func TestInterop(t *testing.T) {
+	out, err := DecryptDirect(interopKey, interopDirect)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, interopMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	bob := mustPrivate(t, bobJWK)
+	for _, tok := range []string{interopECDHES, interopKW} {
+		out, err = DecryptECDH(bob, tok)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, interopMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+
+	// A "dir" token must not be accepted by the ECDH path, and vice
+	// versa.
+	if _, err = DecryptECDH(bob, interopDirect); err != ErrUnsupported {
+		t.Fatal("expected algorithm mismatch to fail")
+	}
+
+	if _, err = DecryptDirect(interopKey, interopKW); err != ErrUnsupported {
+		t.Fatal("expected algorithm mismatch to fail")
+	}
+}
+
+func TestDirect(t *testing.T) {
+	key, err := aesgcm.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tok, err := EncryptDirect(key, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := DecryptDirect(key, tok)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	// Changing the header must break the authentication tag.
+	hdr := b64.EncodeToString([]byte(`{"alg":"dir","enc":"A256GCM","kid":"x"}`))
+	forged := hdr + tok[strings.Index(tok, "."):]
+	if _, err = DecryptDirect(key, forged); err == nil {
+		t.Fatal("expected decryption with a modified header to fail")
+	}
+
+	if _, err = DecryptDirect(key, "a.b.c"); err != ErrMalformed {
+		t.Fatal("expected a malformed token to fail")
+	}
+}
+
+func TestECDH(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		for _, alg := range []string{AlgECDHES, AlgECDHESA256KW} {
+			tok, err := EncryptECDH(&priv.PublicKey, alg, testMessage)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			hdr, err := ParseHeader(tok)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			if hdr.Alg != alg || hdr.Enc != EncA256GCM {
+				t.Fatalf("bad header: %+v", hdr)
+			}
+
+			out, err := DecryptECDH(priv, tok)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			if !bytes.Equal(out, testMessage) {
+				t.Fatal("recovered message doesn't match original")
+			}
+		}
+	}
+
+	if _, err := EncryptECDH(&mustPrivate(t, bobJWK).PublicKey, "RSA1_5", testMessage); err != ErrUnsupported {
+		t.Fatal("expected an unsupported algorithm to fail")
+	}
+
+	if _, err := EncryptECDH(nil, AlgECDHES, testMessage); err != nistecdh.ErrInvalidPoint {
+		t.Fatalf("expected nistecdh.ErrInvalidPoint for a nil peer, have %v", err)
+	}
+
+	if _, err := EncryptECDH(&ecdsa.PublicKey{}, AlgECDHESA256KW, testMessage); err != nistecdh.ErrInvalidPoint {
+		t.Fatalf("expected nistecdh.ErrInvalidPoint for an empty peer, have %v", err)
+	}
+
+	tok, err := EncryptECDH(&mustPrivate(t, bobJWK).PublicKey, AlgECDHES, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = DecryptECDH(nil, tok); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt without a private key, have %v", err)
+	}
+}
+
+// sealHeader encrypts a "dir" token with an arbitrary header, which
+// seal can't produce.
+func sealHeader(t *testing.T, key []byte, header string, message []byte) string {
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	iv, err := aesgcm.GenerateNonce()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	rawHeader := b64.EncodeToString([]byte(header))
+	ct := gcm.Seal(nil, iv, message, []byte(rawHeader))
+	return strings.Join([]string{
+		rawHeader,
+		"",
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct[:len(ct)-tagSize]),
+		b64.EncodeToString(ct[len(ct)-tagSize:]),
+	}, ".")
+}
+
+func TestUnsupportedHeaders(t *testing.T) {
+	key, err := aesgcm.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tok := sealHeader(t, key, `{"alg":"dir","enc":"A256GCM","kid":"x"}`, testMessage)
+	if _, err = DecryptDirect(key, tok); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, hdr := range []string{
+		`{"alg":"dir","enc":"A256GCM","zip":"DEF"}`,
+		`{"alg":"dir","enc":"A256GCM","crit":["exp"],"exp":1}`,
+	} {
+		tok = sealHeader(t, key, hdr, testMessage)
+		if _, err = DecryptDirect(key, tok); err != ErrUnsupported {
+			t.Fatalf("%s: expected ErrUnsupported, have %v", hdr, err)
+		}
+
+		if _, err = ParseHeader(tok); err != ErrUnsupported {
+			t.Fatalf("%s: expected ErrUnsupported, have %v", hdr, err)
+		}
+	}
+}
+
+func TestJWK(t *testing.T) {
+	priv := mustPrivate(t, aliceJWK)
+
+	jwk, err := NewPrivateJWK(priv)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := jwk.Marshal()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	priv2 := mustPrivate(t, out)
+	if priv2.D.Cmp(priv.D) != 0 || priv2.X.Cmp(priv.X) != 0 {
+		t.Fatal("JWK round trip failed")
+	}
+
+	// The public key from one JWK with the private scalar from
+	// another must be rejected.
+	bob, _ := ParseJWK(bobJWK)
+	jwk.D = bob.D
+	if _, err = jwk.PrivateKey(); err == nil {
+		t.Fatal("expected a mismatched private key to fail")
+	}
+
+	jwk, _ = ParseJWK(aliceJWK)
+	jwk.Y = jwk.X
+	if _, err = jwk.PublicKey(); err == nil {
+		t.Fatal("expected a point not on the curve to fail")
+	}
+
+	sym := NewSymmetricJWK(interopKey)
+	key, err := sym.SymmetricKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(key, interopKey) {
+		t.Fatal("symmetric JWK round trip failed")
+	}
+
+	if _, err = sym.PublicKey(); err == nil {
+		t.Fatal("expected an oct JWK to fail as an EC key")
+	}
+}
@@ -0,0 +1,126 @@
+package jwe
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+)
+
+// ErrInvalidJWK is returned when a JWK can't be parsed or doesn't hold
+// the expected kind of key.
+var ErrInvalidJWK = errors.New("jwe: invalid JWK")
+
+// A JWK is a JSON Web Key (RFC 7517). Only the "EC" and "oct" key types
+// are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// fromNISTECDH converts a JWK encoded by nistecdh. EC keys are encoded
+// and checked by nistecdh, so that there is only one EC JWK parser to
+// maintain; this package adds the "kid" member and "oct" keys.
+func fromNISTECDH(in []byte, err error) (*JWK, error) {
+	if err != nil {
+		return nil, ErrInvalidJWK
+	}
+
+	jwk := &JWK{}
+	if err = json.Unmarshal(in, jwk); err != nil {
+		return nil, ErrInvalidJWK
+	}
+	return jwk, nil
+}
+
+// NewPublicJWK exports an EC public key as a JWK.
+func NewPublicJWK(pub *ecdsa.PublicKey) (*JWK, error) {
+	return fromNISTECDH(nistecdh.MarshalJWK(pub))
+}
+
+// NewPrivateJWK exports an EC private key as a JWK.
+func NewPrivateJWK(priv *ecdsa.PrivateKey) (*JWK, error) {
+	return fromNISTECDH(nistecdh.MarshalPrivateJWK(priv))
+}
+
+// NewSymmetricJWK exports a symmetric key as a JWK.
+func NewSymmetricJWK(key []byte) *JWK {
+	return &JWK{
+		Kty: "oct",
+		K:   b64.EncodeToString(key),
+	}
+}
+
+// ecJSON encodes the EC members of the JWK for nistecdh to parse.
+func (jwk *JWK) ecJSON() ([]byte, error) {
+	if jwk.Kty != "EC" {
+		return nil, ErrInvalidJWK
+	}
+	return json.Marshal(&JWK{Kty: jwk.Kty, Crv: jwk.Crv, X: jwk.X, Y: jwk.Y, D: jwk.D})
+}
+
+// PublicKey returns the EC public key in the JWK. The point is checked
+// to be on the named curve.
+func (jwk *JWK) PublicKey() (*ecdsa.PublicKey, error) {
+	in, err := jwk.ecJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := nistecdh.ParseJWK(in)
+	if err != nil {
+		return nil, ErrInvalidJWK
+	}
+	return pub, nil
+}
+
+// PrivateKey returns the EC private key in the JWK. The public point
+// is checked against the private scalar.
+func (jwk *JWK) PrivateKey() (*ecdsa.PrivateKey, error) {
+	in, err := jwk.ecJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := nistecdh.ParsePrivateJWK(in)
+	if err != nil {
+		return nil, ErrInvalidJWK
+	}
+	return priv, nil
+}
+
+// SymmetricKey returns the key in an "oct" JWK.
+func (jwk *JWK) SymmetricKey() ([]byte, error) {
+	if jwk.Kty != "oct" {
+		return nil, ErrInvalidJWK
+	}
+
+	key, err := b64.DecodeString(jwk.K)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidJWK
+	}
+	return key, nil
+}
+
+// ParseJWK decodes a JSON-encoded JWK.
+func ParseJWK(in []byte) (*JWK, error) {
+	jwk := &JWK{}
+	if err := json.Unmarshal(in, jwk); err != nil {
+		return nil, ErrInvalidJWK
+	}
+	return jwk, nil
+}
+
+// Marshal encodes the JWK as JSON.
+func (jwk *JWK) Marshal() ([]byte, error) {
+	return json.Marshal(jwk)
+}
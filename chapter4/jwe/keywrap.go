@@ -0,0 +1,116 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrKeyWrap is returned when a wrapped key fails its integrity check.
+var ErrKeyWrap = errors.New("jwe: key unwrap failed")
+
+// defaultIV is the RFC 3394 initial value.
+var defaultIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// keyWrap wraps a key using the AES key wrap algorithm from RFC 3394.
+func keyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, ErrKeyWrap
+	}
+
+	c, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, defaultIV)
+	copy(out[8:], key)
+
+	var block [aes.BlockSize]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(block[:8], out[:8])
+			copy(block[8:], out[i*8:(i+1)*8])
+			c.Encrypt(block[:], block[:])
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(block[:8])^t)
+			copy(out[i*8:], block[8:])
+		}
+	}
+	return out, nil
+}
+
+// keyUnwrap reverses keyWrap, checking the integrity of the wrapped key.
+func keyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, ErrKeyWrap
+	}
+
+	c, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped)
+	out := make([]byte, len(wrapped)-8)
+	copy(out, wrapped[8:])
+
+	var block [aes.BlockSize]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(block[:8], binary.BigEndian.Uint64(a)^t)
+			copy(block[8:], out[(i-1)*8:i*8])
+			c.Decrypt(block[:], block[:])
+
+			copy(a, block[:8])
+			copy(out[(i-1)*8:], block[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, defaultIV) != 1 {
+		return nil, ErrKeyWrap
+	}
+	return out, nil
+}
+
+// lengthPrefixed returns the data prefixed with its 32-bit big-endian
+// length, as used in the Concat KDF's OtherInfo.
+func lengthPrefixed(data []byte) []byte {
+	out := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+// concatKDF is the single-step KDF from NIST SP 800-56A section 5.8.1
+// using SHA-256, with the OtherInfo laid out as in RFC 7518 section
+// 4.6.2.
+func concatKDF(z []byte, alg string, apu, apv []byte, keyLen int) []byte {
+	var otherInfo []byte
+	otherInfo = append(otherInfo, lengthPrefixed([]byte(alg))...)
+	otherInfo = append(otherInfo, lengthPrefixed(apu)...)
+	otherInfo = append(otherInfo, lengthPrefixed(apv)...)
+
+	var suppPubInfo [4]byte
+	binary.BigEndian.PutUint32(suppPubInfo[:], uint32(keyLen*8))
+	otherInfo = append(otherInfo, suppPubInfo[:]...)
+
+	var out []byte
+	var counter [4]byte
+	for i := uint32(1); len(out) < keyLen; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write(counter[:])
+		h.Write(z)
+		h.Write(otherInfo)
+		out = h.Sum(out)
+	}
+	return out[:keyLen]
+}
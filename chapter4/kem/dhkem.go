@@ -0,0 +1,308 @@
+package kem
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/hkdf"
+)
+
+// dhKEM is the RFC 9180 DHKEM over an elliptic curve, with the HKDF
+// hash matching the curve's security level.
+type dhKEM struct {
+	name    string
+	id      uint16
+	curve   ecdh.Curve
+	hash    func() hash.Hash
+	secret  int
+	skSize  int
+	pkSize  int
+	bitmask byte
+}
+
+var (
+	p256 = &dhKEM{
+		name: "P-256", id: 0x0010, curve: ecdh.P256(), hash: sha256.New,
+		secret: 32, skSize: 32, pkSize: 65, bitmask: 0xff,
+	}
+	p384 = &dhKEM{
+		name: "P-384", id: 0x0011, curve: ecdh.P384(), hash: sha512.New384,
+		secret: 48, skSize: 48, pkSize: 97, bitmask: 0xff,
+	}
+	p521 = &dhKEM{
+		name: "P-521", id: 0x0012, curve: ecdh.P521(), hash: sha512.New,
+		secret: 64, skSize: 66, pkSize: 133, bitmask: 0x01,
+	}
+	x25519 = &dhKEM{
+		name: "X25519", id: 0x0020, curve: ecdh.X25519(), hash: sha256.New,
+		secret: 32, skSize: 32, pkSize: 32,
+	}
+)
+
+// X25519 returns DHKEM(X25519, HKDF-SHA256). Its keys are the same as
+// naclbox keys: ParsePublicKey and ParsePrivateKey take the 32-byte
+// arrays directly.
+func X25519() KEM { return x25519 }
+
+// P256 returns DHKEM(P-256, HKDF-SHA256). Public keys are uncompressed
+// points.
+func P256() KEM { return p256 }
+
+// P384 returns DHKEM(P-384, HKDF-SHA384).
+func P384() KEM { return p384 }
+
+// P521 returns DHKEM(P-521, HKDF-SHA512).
+func P521() KEM { return p521 }
+
+func dhKEMForCurve(curve ecdh.Curve) (*dhKEM, error) {
+	for _, k := range []*dhKEM{p256, p384, p521, x25519} {
+		if k.curve == curve {
+			return k, nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+type dhPublicKey struct {
+	kem *dhKEM
+	key *ecdh.PublicKey
+}
+
+func (pub *dhPublicKey) KEM() KEM      { return pub.kem }
+func (pub *dhPublicKey) Bytes() []byte { return pub.key.Bytes() }
+
+func (pub *dhPublicKey) Equal(other PublicKey) bool {
+	o, ok := other.(*dhPublicKey)
+	return ok && o.kem == pub.kem && pub.key.Equal(o.key)
+}
+
+type dhPrivateKey struct {
+	kem *dhKEM
+	key *ecdh.PrivateKey
+}
+
+func (priv *dhPrivateKey) KEM() KEM      { return priv.kem }
+func (priv *dhPrivateKey) Bytes() []byte { return priv.key.Bytes() }
+
+func (priv *dhPrivateKey) Public() PublicKey {
+	return &dhPublicKey{kem: priv.kem, key: priv.key.PublicKey()}
+}
+
+func (k *dhKEM) ID() uint16             { return k.id }
+func (k *dhKEM) Name() string           { return k.name }
+func (k *dhKEM) PublicKeySize() int     { return k.pkSize }
+func (k *dhKEM) EncapsulationSize() int { return k.pkSize }
+func (k *dhKEM) SharedSecretSize() int  { return k.secret }
+
+// suiteID is the KEM's suite identifier for labelled key derivation.
+func (k *dhKEM) suiteID() []byte {
+	return binary.BigEndian.AppendUint16([]byte("KEM"), k.id)
+}
+
+func (k *dhKEM) labeledExtract(salt []byte, label string, ikm []byte) []byte {
+	in := append([]byte("HPKE-v1"), k.suiteID()...)
+	in = append(in, label...)
+	in = append(in, ikm...)
+	return hkdf.Extract(k.hash, in, salt)
+}
+
+func (k *dhKEM) labeledExpand(prk []byte, label string, info []byte, length int) []byte {
+	in := binary.BigEndian.AppendUint16(nil, uint16(length))
+	in = append(in, "HPKE-v1"...)
+	in = append(in, k.suiteID()...)
+	in = append(in, label...)
+	in = append(in, info...)
+
+	out := make([]byte, length)
+	hkdf.Expand(k.hash, prk, in).Read(out)
+	return out
+}
+
+func (k *dhKEM) extractAndExpand(dh, kemContext []byte) []byte {
+	prk := k.labeledExtract(nil, "eae_prk", dh)
+	defer util.Zero(prk)
+	return k.labeledExpand(prk, "shared_secret", kemContext, k.secret)
+}
+
+func (k *dhKEM) GenerateKeyPair() (PrivateKey, error) {
+	key, err := k.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &dhPrivateKey{kem: k, key: key}, nil
+}
+
+func (k *dhKEM) DeriveKeyPair(ikm []byte) (PrivateKey, error) {
+	prk := k.labeledExtract(nil, "dkp_prk", ikm)
+	defer util.Zero(prk)
+
+	if k.curve == ecdh.X25519() {
+		sk := k.labeledExpand(prk, "sk", nil, k.skSize)
+		defer util.Zero(sk)
+		return k.ParsePrivateKey(sk)
+	}
+
+	// For the NIST curves, candidates are drawn until one is a valid
+	// scalar.
+	for counter := 0; counter < 256; counter++ {
+		sk := k.labeledExpand(prk, "candidate", []byte{byte(counter)}, k.skSize)
+		sk[0] &= k.bitmask
+		key, err := k.curve.NewPrivateKey(sk)
+		util.Zero(sk)
+		if err == nil {
+			return &dhPrivateKey{kem: k, key: key}, nil
+		}
+	}
+	return nil, ErrInvalidKey
+}
+
+func (k *dhKEM) ParsePublicKey(in []byte) (PublicKey, error) {
+	key, err := k.curve.NewPublicKey(in)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &dhPublicKey{kem: k, key: key}, nil
+}
+
+func (k *dhKEM) ParsePrivateKey(in []byte) (PrivateKey, error) {
+	key, err := k.curve.NewPrivateKey(in)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &dhPrivateKey{kem: k, key: key}, nil
+}
+
+func (k *dhKEM) publicKey(pub PublicKey) (*ecdh.PublicKey, error) {
+	p, ok := pub.(*dhPublicKey)
+	if !ok || p == nil || p.kem != k {
+		return nil, ErrInvalidKey
+	}
+	return p.key, nil
+}
+
+func (k *dhKEM) privateKey(priv PrivateKey) (*ecdh.PrivateKey, error) {
+	p, ok := priv.(*dhPrivateKey)
+	if !ok || p == nil || p.kem != k {
+		return nil, ErrInvalidKey
+	}
+	return p.key, nil
+}
+
+func (k *dhKEM) Encapsulate(pub PublicKey) (shared, enc []byte, err error) {
+	pkR, err := k.publicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skE, err := k.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.encapsulate(skE, pkR, nil)
+}
+
+// AuthEncapsulate is Encapsulate, also authenticating the sender's key
+// pair. The sender is required; use Encapsulate for an unauthenticated
+// encapsulation.
+func (k *dhKEM) AuthEncapsulate(pub PublicKey, sender PrivateKey) (shared, enc []byte, err error) {
+	pkR, err := k.publicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skS, err := k.privateKey(sender)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skE, err := k.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.encapsulate(skE, pkR, skS)
+}
+
+// encapsulate encapsulates to pkR with the given ephemeral key and, if
+// skS isn't nil, the sender's key.
+func (k *dhKEM) encapsulate(skE *ecdh.PrivateKey, pkR *ecdh.PublicKey, skS *ecdh.PrivateKey) (shared, enc []byte, err error) {
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+	defer util.Zero(dh)
+
+	enc = skE.PublicKey().Bytes()
+	kemContext := append(append([]byte{}, enc...), pkR.Bytes()...)
+
+	if skS != nil {
+		dhS, err := skS.ECDH(pkR)
+		if err != nil {
+			return nil, nil, ErrInvalidKey
+		}
+		defer util.Zero(dhS)
+
+		dh = append(dh, dhS...)
+		defer util.Zero(dh)
+		kemContext = append(kemContext, skS.PublicKey().Bytes()...)
+	}
+	return k.extractAndExpand(dh, kemContext), enc, nil
+}
+
+func (k *dhKEM) Decapsulate(priv PrivateKey, enc []byte) ([]byte, error) {
+	skR, err := k.privateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return k.decapsulate(skR, enc, nil)
+}
+
+// AuthDecapsulate is Decapsulate, also checking that the shared secret
+// came from the sender's key pair. The sender is required; use
+// Decapsulate for an unauthenticated encapsulation.
+func (k *dhKEM) AuthDecapsulate(priv PrivateKey, enc []byte, sender PublicKey) ([]byte, error) {
+	skR, err := k.privateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	pkS, err := k.publicKey(sender)
+	if err != nil {
+		return nil, err
+	}
+	return k.decapsulate(skR, enc, pkS)
+}
+
+// decapsulate recovers the shared secret for enc and, if pkS isn't
+// nil, the sender's key.
+func (k *dhKEM) decapsulate(skR *ecdh.PrivateKey, enc []byte, pkS *ecdh.PublicKey) ([]byte, error) {
+	pkE, err := k.curve.NewPublicKey(enc)
+	if err != nil {
+		return nil, ErrDecapsulate
+	}
+
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, ErrDecapsulate
+	}
+	defer util.Zero(dh)
+
+	kemContext := append(append([]byte{}, enc...), skR.PublicKey().Bytes()...)
+
+	if pkS != nil {
+		dhS, err := skR.ECDH(pkS)
+		if err != nil {
+			return nil, ErrDecapsulate
+		}
+		defer util.Zero(dhS)
+
+		dh = append(dh, dhS...)
+		defer util.Zero(dh)
+		kemContext = append(kemContext, pkS.Bytes()...)
+	}
+	return k.extractAndExpand(dh, kemContext), nil
+}
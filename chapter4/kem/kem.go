@@ -0,0 +1,276 @@
+// Package kem provides a common key encapsulation interface over the
+// key exchanges used elsewhere in this chapter: X25519 (as in naclbox)
+// and the NIST curves (as in nistecdh). A KEM generates a fresh shared
+// secret for a public key, along with an encapsulation that only the
+// holder of the private key can turn back into the same secret.
+//
+// The implementations follow the DHKEM construction from RFC 9180,
+// which binds the shared secret to both the ephemeral and recipient
+// public keys. Seal and Open build public-key encryption on top of any
+// KEM, so choosing a curve is a matter of configuration.
+//
+// The X-Wing KEM combines X25519 with ML-KEM-768, for data that needs
+// protection against future quantum computers.
+package kem
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var (
+	// ErrUnsupported is returned for an unknown KEM name or
+	// identifier.
+	ErrUnsupported = errors.New("kem: unsupported KEM")
+
+	// ErrInvalidKey is returned when a key can't be parsed, or is
+	// used with the wrong KEM.
+	ErrInvalidKey = errors.New("kem: invalid key")
+
+	// ErrDecapsulate is returned when an encapsulation is invalid.
+	ErrDecapsulate = errors.New("kem: decapsulation failed")
+
+	// ErrEncrypt is returned when a message can't be sealed.
+	ErrEncrypt = errors.New("kem: encryption failed")
+
+	// ErrDecrypt is returned when a message can't be opened.
+	ErrDecrypt = errors.New("kem: decryption failed")
+)
+
+// A PublicKey is a KEM public key.
+type PublicKey interface {
+	// KEM returns the KEM the key belongs to.
+	KEM() KEM
+
+	// Bytes returns the serialised key.
+	Bytes() []byte
+
+	// Equal reports whether the keys are the same.
+	Equal(PublicKey) bool
+}
+
+// A PrivateKey is a KEM private key.
+type PrivateKey interface {
+	// KEM returns the KEM the key belongs to.
+	KEM() KEM
+
+	// Bytes returns the serialised private key.
+	Bytes() []byte
+
+	// Public returns the corresponding public key.
+	Public() PublicKey
+}
+
+// A KEM is a key encapsulation mechanism.
+type KEM interface {
+	// ID returns the KEM's identifier, as registered for HPKE.
+	ID() uint16
+
+	// Name returns the KEM's name, as accepted by ByName.
+	Name() string
+
+	// PublicKeySize is the length of a serialised public key.
+	PublicKeySize() int
+
+	// EncapsulationSize is the length of an encapsulation.
+	EncapsulationSize() int
+
+	// SharedSecretSize is the length of the shared secret.
+	SharedSecretSize() int
+
+	// GenerateKeyPair returns a new random key pair.
+	GenerateKeyPair() (PrivateKey, error)
+
+	// DeriveKeyPair deterministically derives a key pair from the
+	// input keying material, which should have at least as many bytes
+	// of entropy as the shared secret.
+	DeriveKeyPair(ikm []byte) (PrivateKey, error)
+
+	// ParsePublicKey parses a serialised public key.
+	ParsePublicKey(in []byte) (PublicKey, error)
+
+	// ParsePrivateKey parses a serialised private key.
+	ParsePrivateKey(in []byte) (PrivateKey, error)
+
+	// Encapsulate returns a new shared secret and its encapsulation
+	// to the public key.
+	Encapsulate(pub PublicKey) (shared, enc []byte, err error)
+
+	// Decapsulate recovers the shared secret from an encapsulation.
+	Decapsulate(priv PrivateKey, enc []byte) ([]byte, error)
+}
+
+// An AuthKEM is a KEM that can also authenticate the sender: the
+// shared secret depends on the sender's key pair as well, so only the
+// holder of the sender's private key could have produced it. The DHKEM
+// implementations are AuthKEMs; X-Wing isn't.
+type AuthKEM interface {
+	KEM
+
+	// AuthEncapsulate returns a new shared secret and its
+	// encapsulation to the public key, authenticated by the sender's
+	// private key. A nil sender is an invalid key, not a request for
+	// an unauthenticated encapsulation.
+	AuthEncapsulate(pub PublicKey, sender PrivateKey) (shared, enc []byte, err error)
+
+	// AuthDecapsulate recovers a shared secret from an encapsulation
+	// made with the sender's private key. A nil sender is an invalid
+	// key.
+	AuthDecapsulate(priv PrivateKey, enc []byte, sender PublicKey) ([]byte, error)
+}
+
+var registry = []KEM{p256, p384, p521, x25519, xwing}
+
+// ByName returns the KEM with the given name: one of "X25519",
+// "P-256", "P-384", "P-521" and "X-Wing".
+func ByName(name string) (KEM, error) {
+	for _, k := range registry {
+		if k.Name() == name {
+			return k, nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+// ByID returns the KEM with the given identifier.
+func ByID(id uint16) (KEM, error) {
+	for _, k := range registry {
+		if k.ID() == id {
+			return k, nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+// MarshalPublicKey serialises a public key with its KEM identifier,
+// so that it can be parsed without knowing the KEM in advance.
+func MarshalPublicKey(pub PublicKey) []byte {
+	out := make([]byte, 2, 2+pub.KEM().PublicKeySize())
+	binary.BigEndian.PutUint16(out, pub.KEM().ID())
+	return append(out, pub.Bytes()...)
+}
+
+// ParsePublicKey parses a public key serialised with MarshalPublicKey.
+func ParsePublicKey(in []byte) (PublicKey, error) {
+	if len(in) < 2 {
+		return nil, ErrInvalidKey
+	}
+
+	k, err := ByID(binary.BigEndian.Uint16(in))
+	if err != nil {
+		return nil, err
+	}
+	return k.ParsePublicKey(in[2:])
+}
+
+// PublicKeyFromECDSA converts an ECDSA public key, such as those used
+// with nistecdh, to a public key for the KEM on the same curve.
+func PublicKeyFromECDSA(pub *ecdsa.PublicKey) (PublicKey, error) {
+	key, err := pub.ECDH()
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	k, err := dhKEMForCurve(key.Curve())
+	if err != nil {
+		return nil, err
+	}
+	return &dhPublicKey{kem: k, key: key}, nil
+}
+
+// PrivateKeyFromECDSA converts an ECDSA private key to a private key
+// for the KEM on the same curve.
+func PrivateKeyFromECDSA(priv *ecdsa.PrivateKey) (PrivateKey, error) {
+	key, err := priv.ECDH()
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	k, err := dhKEMForCurve(key.Curve())
+	if err != nil {
+		return nil, err
+	}
+	return &dhPrivateKey{kem: k, key: key}, nil
+}
+
+// Overhead returns the length of the data Seal adds to a message for
+// the KEM.
+func Overhead(k KEM) int {
+	return 2 + k.EncapsulationSize() + secret.NonceSize + secretbox.Overhead
+}
+
+const sealInfo = "kem seal"
+
+// sealKey derives the secretbox key for Seal and Open from the shared
+// secret.
+func sealKey(k KEM, shared []byte) *[secret.KeySize]byte {
+	info := make([]byte, len(sealInfo), len(sealInfo)+2)
+	copy(info, sealInfo)
+	info = binary.BigEndian.AppendUint16(info, k.ID())
+
+	key := new([secret.KeySize]byte)
+	io.ReadFull(hkdf.New(sha256.New, shared, nil, info), key[:])
+	return key
+}
+
+// Seal encrypts a message to the public key. The result holds the KEM
+// identifier and the encapsulation, followed by the message encrypted
+// with NaCl secretbox under a key derived from the shared secret.
+func Seal(pub PublicKey, message []byte) ([]byte, error) {
+	k := pub.KEM()
+	shared, enc, err := k.Encapsulate(pub)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	key := sealKey(k, shared)
+	util.Zero(shared)
+	defer util.Zero(key[:])
+
+	ct, err := secret.Encrypt(key, message)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	out := make([]byte, 2, Overhead(k)+len(message))
+	binary.BigEndian.PutUint16(out, k.ID())
+	out = append(out, enc...)
+	return append(out, ct...), nil
+}
+
+// Open decrypts a message sealed to the private key's public key.
+func Open(priv PrivateKey, sealed []byte) ([]byte, error) {
+	k := priv.KEM()
+	if len(sealed) < Overhead(k) {
+		return nil, ErrDecrypt
+	}
+
+	if binary.BigEndian.Uint16(sealed) != k.ID() {
+		return nil, ErrDecrypt
+	}
+	sealed = sealed[2:]
+
+	size := k.EncapsulationSize()
+	shared, err := k.Decapsulate(priv, sealed[:size])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	key := sealKey(k, shared)
+	util.Zero(shared)
+	defer util.Zero(key[:])
+
+	out, err := secret.Decrypt(key, sealed[size:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
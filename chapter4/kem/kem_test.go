@@ -0,0 +1,368 @@
+package kem
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/nistecdh"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+var allKEMs = []KEM{X25519(), P256(), P384(), P521(), XWing()}
+
+func TestEncapsulate(t *testing.T) {
+	for _, k := range allKEMs {
+		priv, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		// Round-trip both keys through their serialised forms.
+		pub, err := ParsePublicKey(MarshalPublicKey(priv.Public()))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !pub.Equal(priv.Public()) || len(pub.Bytes()) != k.PublicKeySize() {
+			t.Fatalf("%s: public key didn't survive serialisation", k.Name())
+		}
+
+		priv, err = k.ParsePrivateKey(priv.Bytes())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		shared, enc, err := k.Encapsulate(pub)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if len(shared) != k.SharedSecretSize() || len(enc) != k.EncapsulationSize() {
+			t.Fatalf("%s: wrong output sizes", k.Name())
+		}
+
+		out, err := k.Decapsulate(priv, enc)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, shared) {
+			t.Fatalf("%s: shared secrets don't match", k.Name())
+		}
+
+		other, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		out, err = k.Decapsulate(other, enc)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if bytes.Equal(out, shared) {
+			t.Fatalf("%s: another key recovered the shared secret", k.Name())
+		}
+	}
+}
+
+func TestAuthEncapsulate(t *testing.T) {
+	for _, k := range allKEMs {
+		auth, ok := k.(AuthKEM)
+		if !ok {
+			continue
+		}
+
+		skR, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		skS, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		shared, enc, err := auth.AuthEncapsulate(skR.Public(), skS)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		out, err := auth.AuthDecapsulate(skR, enc, skS.Public())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, shared) {
+			t.Fatalf("%s: shared secrets don't match", k.Name())
+		}
+
+		// A missing sender is an error, not an unauthenticated
+		// encapsulation.
+		if _, _, err = auth.AuthEncapsulate(skR.Public(), nil); err != ErrInvalidKey {
+			t.Fatalf("%s: expected ErrInvalidKey without a sender, have %v", k.Name(), err)
+		}
+
+		if _, err = auth.AuthDecapsulate(skR, enc, nil); err != ErrInvalidKey {
+			t.Fatalf("%s: expected ErrInvalidKey without a sender, have %v", k.Name(), err)
+		}
+
+		if _, err = auth.AuthDecapsulate(skR, enc, (*dhPublicKey)(nil)); err != ErrInvalidKey {
+			t.Fatalf("%s: expected ErrInvalidKey for a nil key, have %v", k.Name(), err)
+		}
+
+		_, enc, err = k.Encapsulate(skR.Public())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if _, err = auth.AuthDecapsulate(skR, enc, nil); err != ErrInvalidKey {
+			t.Fatalf("%s: expected ErrInvalidKey without a sender, have %v", k.Name(), err)
+		}
+	}
+}
+
+func TestSeal(t *testing.T) {
+	for _, name := range []string{"X25519", "P-256", "P-384", "P-521", "X-Wing"} {
+		k, err := ByName(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		priv, err := k.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		sealed, err := Seal(priv.Public(), testMessage)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if len(sealed) != len(testMessage)+Overhead(k) {
+			t.Fatalf("%s: wrong sealed length", name)
+		}
+
+		out, err := Open(priv, sealed)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+
+		for _, i := range []int{0, 2, len(sealed) - 1} {
+			sealed[i] ^= 1
+			if _, err = Open(priv, sealed); err != ErrDecrypt {
+				t.Fatalf("%s: expected a modified message to fail", name)
+			}
+			sealed[i] ^= 1
+		}
+	}
+
+	if _, err := ByName("P-224"); err != ErrUnsupported {
+		t.Fatal("expected an unsupported curve to fail")
+	}
+}
+
+// TestExistingKeys checks that naclbox and nistecdh keys can be used
+// with the KEMs.
+func TestExistingKeys(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	kpub, err := X25519().ParsePublicKey(pub[:])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	kpriv, err := X25519().ParsePrivateKey(priv[:])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !kpriv.Public().Equal(kpub) {
+		t.Fatal("naclbox key pair doesn't match")
+	}
+
+	ecpriv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	kpriv, err = PrivateKeyFromECDSA(ecpriv)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	kpub, err = PublicKeyFromECDSA(&ecpriv.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if kpub.KEM() != P384() || !kpriv.Public().Equal(kpub) {
+		t.Fatal("ECDSA key pair doesn't match")
+	}
+
+	// The DHKEM shared secret is derived from the same exchange as
+	// nistecdh.SharedSecret.
+	eph, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	z, err := nistecdh.SharedSecret(eph, &ecpriv.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ephPub, err := eph.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	k := P384().(*dhKEM)
+	enc := ephPub.Bytes()
+	kemContext := append(append([]byte{}, enc...), kpub.Bytes()...)
+
+	shared, err := k.Decapsulate(kpriv, enc)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(shared, k.extractAndExpand(z, kemContext)) {
+		t.Fatal("shared secret doesn't match nistecdh")
+	}
+
+	if _, err = P256().Decapsulate(kpriv, enc); err != ErrInvalidKey {
+		t.Fatal("expected a key from another KEM to fail")
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return b
+}
+
+// Key derivation test vectors from RFC 9180, appendix A.
+var deriveTests = []struct {
+	kem              KEM
+	ikmE, ikmR       string
+	skRm, pkRm, pkEm string
+}{
+	{
+		kem:  X25519(),
+		ikmE: "7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234",
+		ikmR: "6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037",
+		skRm: "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8",
+		pkRm: "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d",
+		pkEm: "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431",
+	},
+	{
+		kem:  P256(),
+		ikmE: "4270e54ffd08d79d5928020af4686d8f6b7d35dbe470265f1f5aa22816ce860e",
+		ikmR: "668b37171f1072f3cf12ea8a236a45df23fc13b82af3609ad1e354f6ef817550",
+		skRm: "f3ce7fdae57e1a310d87f1ebbde6f328be0a99cdbcadf4d6589cf29de4b8ffd2",
+		pkRm: "04fe8c19ce0905191ebc298a9245792531f26f0cece2460639e8bc39cb7f706a" +
+			"826a779b4cf969b8a0e539c7f62fb3d30ad6aa8f80e30f1d128aafd68a2ce72ea0",
+		pkEm: "04a92719c6195d5085104f469a8b9814d5838ff72b60501e2c4466e5e67b325a" +
+			"c98536d7b61a1af4b78e5b7f951c0900be863c403ce65c9bfcb9382657222d18c4",
+	},
+	{
+		kem: P521(),
+		ikmE: "5040af7a10269b11f78bb884812ad20041866db8bbd749a6a69e3f33e54da716" +
+			"4598f005bce09a9fe190e29c2f42df9e9e3aad040fccc625ddbd7aa99063fc594f40",
+		ikmR: "39a28dc317c3e48b908948f99d608059f882d3d09c0541824bc25f94e6dee7aa" +
+			"0df1c644296b06fbb76e84aef5008f8a908e08fbabadf70658538d74753a85f8856a",
+		skRm: "009227b4b91cf1eb6eecb6c0c0bae93a272d24e11c63bd4c34a581c49f9c3ca0" +
+			"1c16bbd32a0a1fac22784f2ae985c85f183baad103b2d02aee787179dfc1a94fea11",
+		pkRm: "0400b81073b1612cf7fdb6db07b35cf4bc17bda5854f3d270ecd9ea99f6c07b4" +
+			"6795b8014b66c523ceed6f4829c18bc3886c891b63fa902500ce3ddeb1fbec7e60" +
+			"8ac70050b76a0a7fc081dbf1cb30b005981113e635eb501a973aba662d7f16fcc1" +
+			"2897dd752d657d37774bb16197c0d9724eecc1ed65349fb6ac1f280749e7669766f8cd",
+		pkEm: "0400bec215e31718cd2eff5ba61d55d062d723527ec2029d7679a9c867d5c682" +
+			"19c9b217a9d7f78562dc0af3242fef35d1d6f4a28ee75f0d4b31bc918937b559b7" +
+			"0762004c4fd6ad7373db7e31da8735fbd6171bbdcfa770211420682c760a40a482" +
+			"cc24f4125edbea9cb31fe71d5d796cfe788dc408857697a52fef711fb921fa7c385218",
+	},
+}
+
+func TestDeriveKeyPair(t *testing.T) {
+	for _, tc := range deriveTests {
+		priv, err := tc.kem.DeriveKeyPair(unhex(t, tc.ikmR))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(priv.Bytes(), unhex(t, tc.skRm)) {
+			t.Fatalf("%s: derived private key doesn't match", tc.kem.Name())
+		}
+
+		if !bytes.Equal(priv.Public().Bytes(), unhex(t, tc.pkRm)) {
+			t.Fatalf("%s: derived public key doesn't match", tc.kem.Name())
+		}
+
+		eph, err := tc.kem.DeriveKeyPair(unhex(t, tc.ikmE))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(eph.Public().Bytes(), unhex(t, tc.pkEm)) {
+			t.Fatalf("%s: derived ephemeral key doesn't match", tc.kem.Name())
+		}
+	}
+}
+
+func TestXWingVector(t *testing.T) {
+	in, err := os.ReadFile("testdata/xwing.json")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var tc struct {
+		IKMR         string `json:"ikmR"`
+		SKRm         string `json:"skRm"`
+		PKRm         string `json:"pkRm"`
+		Enc          string `json:"enc"`
+		SharedSecret string `json:"shared_secret"`
+	}
+	if err = json.Unmarshal(in, &tc); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	priv, err := XWing().DeriveKeyPair(unhex(t, tc.IKMR))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(priv.Bytes(), unhex(t, tc.SKRm)) {
+		t.Fatal("derived private key doesn't match")
+	}
+
+	if !bytes.Equal(priv.Public().Bytes(), unhex(t, tc.PKRm)) {
+		t.Fatal("public key doesn't match")
+	}
+
+	shared, err := XWing().Decapsulate(priv, unhex(t, tc.Enc))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(shared, unhex(t, tc.SharedSecret)) {
+		t.Fatal("shared secret doesn't match")
+	}
+}
@@ -0,0 +1,212 @@
+package kem
+
+import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha3"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+// xwingLabel is the X-Wing domain separator, the ASCII art `\./` over
+// `/^\`.
+const xwingLabel = "\\./" + "/^\\"
+
+const (
+	xwingSeedSize = 32
+	xwingPKSize   = mlkem.EncapsulationKeySize768 + 32
+	xwingCTSize   = mlkem.CiphertextSize768 + 32
+)
+
+// xwingKEM is the X-Wing hybrid of ML-KEM-768 and X25519. The shared
+// secret is SHA3-256 over both component secrets, the X25519 ciphertext
+// and public key, and a label; it stays secret as long as either
+// component is unbroken.
+type xwingKEM struct{}
+
+var xwing = xwingKEM{}
+
+// XWing returns the X-Wing KEM (MLKEM768-X25519), a hybrid of
+// ML-KEM-768 and X25519 for protecting data against future quantum
+// computers as well as today's attackers. Private keys are 32-byte
+// seeds.
+func XWing() KEM { return xwing }
+
+type xwingPublicKey struct {
+	pq *mlkem.EncapsulationKey768
+	t  *ecdh.PublicKey
+}
+
+func (pub *xwingPublicKey) KEM() KEM { return xwing }
+
+func (pub *xwingPublicKey) Bytes() []byte {
+	return append(pub.pq.Bytes(), pub.t.Bytes()...)
+}
+
+func (pub *xwingPublicKey) Equal(other PublicKey) bool {
+	o, ok := other.(*xwingPublicKey)
+	return ok && subtle.ConstantTimeCompare(pub.Bytes(), o.Bytes()) == 1
+}
+
+type xwingPrivateKey struct {
+	seed []byte
+	pq   *mlkem.DecapsulationKey768
+	t    *ecdh.PrivateKey
+}
+
+func (priv *xwingPrivateKey) KEM() KEM { return xwing }
+
+func (priv *xwingPrivateKey) Bytes() []byte {
+	return append([]byte{}, priv.seed...)
+}
+
+func (priv *xwingPrivateKey) Public() PublicKey {
+	return &xwingPublicKey{pq: priv.pq.EncapsulationKey(), t: priv.t.PublicKey()}
+}
+
+func (xwingKEM) ID() uint16             { return 0x647a }
+func (xwingKEM) Name() string           { return "X-Wing" }
+func (xwingKEM) PublicKeySize() int     { return xwingPKSize }
+func (xwingKEM) EncapsulationSize() int { return xwingCTSize }
+func (xwingKEM) SharedSecretSize() int  { return 32 }
+
+func (k xwingKEM) GenerateKeyPair() (PrivateKey, error) {
+	seed, err := util.RandBytes(xwingSeedSize)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(seed)
+	return k.ParsePrivateKey(seed)
+}
+
+// DeriveKeyPair derives the seed from the input keying material with
+// SHAKE256, as the hybrid KEMs for HPKE do.
+func (k xwingKEM) DeriveKeyPair(ikm []byte) (PrivateKey, error) {
+	const label = "DeriveKeyPair"
+
+	h := sha3.NewSHAKE256()
+	h.Write(ikm)
+	h.Write([]byte("HPKE-v1"))
+	h.Write(binary.BigEndian.AppendUint16([]byte("KEM"), k.ID()))
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(label))))
+	h.Write([]byte(label))
+	h.Write(binary.BigEndian.AppendUint16(nil, xwingSeedSize))
+
+	seed := make([]byte, xwingSeedSize)
+	h.Read(seed)
+	defer util.Zero(seed)
+	return k.ParsePrivateKey(seed)
+}
+
+// ParsePrivateKey expands a seed into the ML-KEM-768 and X25519
+// private keys.
+func (xwingKEM) ParsePrivateKey(in []byte) (PrivateKey, error) {
+	if len(in) != xwingSeedSize {
+		return nil, ErrInvalidKey
+	}
+
+	var expanded [mlkem.SeedSize + 32]byte
+	defer util.Zero(expanded[:])
+
+	h := sha3.NewSHAKE256()
+	h.Write(in)
+	h.Read(expanded[:])
+
+	pq, err := mlkem.NewDecapsulationKey768(expanded[:mlkem.SeedSize])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	t, err := ecdh.X25519().NewPrivateKey(expanded[mlkem.SeedSize:])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return &xwingPrivateKey{seed: append([]byte{}, in...), pq: pq, t: t}, nil
+}
+
+func (xwingKEM) ParsePublicKey(in []byte) (PublicKey, error) {
+	if len(in) != xwingPKSize {
+		return nil, ErrInvalidKey
+	}
+
+	pq, err := mlkem.NewEncapsulationKey768(in[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	t, err := ecdh.X25519().NewPublicKey(in[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &xwingPublicKey{pq: pq, t: t}, nil
+}
+
+func xwingCombine(ssPQ, ssT, ctT, pkT []byte) []byte {
+	h := sha3.New256()
+	h.Write(ssPQ)
+	h.Write(ssT)
+	h.Write(ctT)
+	h.Write(pkT)
+	h.Write([]byte(xwingLabel))
+	return h.Sum(nil)
+}
+
+func (xwingKEM) Encapsulate(pub PublicKey) (shared, enc []byte, err error) {
+	pk, ok := pub.(*xwingPublicKey)
+	if !ok {
+		return nil, nil, ErrInvalidKey
+	}
+
+	skE, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ssT, err := skE.ECDH(pk.t)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+	defer util.Zero(ssT)
+
+	ssPQ, ctPQ := pk.pq.Encapsulate()
+	defer util.Zero(ssPQ)
+
+	ctT := skE.PublicKey().Bytes()
+	shared = xwingCombine(ssPQ, ssT, ctT, pk.t.Bytes())
+	return shared, append(ctPQ, ctT...), nil
+}
+
+func (xwingKEM) Decapsulate(priv PrivateKey, enc []byte) ([]byte, error) {
+	sk, ok := priv.(*xwingPrivateKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	if len(enc) != xwingCTSize {
+		return nil, ErrDecapsulate
+	}
+	ctPQ, ctT := enc[:mlkem.CiphertextSize768], enc[mlkem.CiphertextSize768:]
+
+	ssPQ, err := sk.pq.Decapsulate(ctPQ)
+	if err != nil {
+		return nil, ErrDecapsulate
+	}
+	defer util.Zero(ssPQ)
+
+	pkE, err := ecdh.X25519().NewPublicKey(ctT)
+	if err != nil {
+		return nil, ErrDecapsulate
+	}
+
+	ssT, err := sk.t.ECDH(pkE)
+	if err != nil {
+		return nil, ErrDecapsulate
+	}
+	defer util.Zero(ssT)
+
+	return xwingCombine(ssPQ, ssT, ctT, sk.t.PublicKey().Bytes()), nil
+}
@@ -0,0 +1,174 @@
+package naclbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// FingerprintSize is the size of a public key fingerprint.
+const FingerprintSize = 16
+
+// AuthOverhead is the length of additional data added to a message by
+// EncryptAuthenticated. The fingerprints appear twice: in the clear to
+// select keys, and inside the box to authenticate them.
+const AuthOverhead = 4*FingerprintSize + 24 + box.Overhead
+
+var (
+	// ErrUnknownRecipient is returned when a message isn't addressed
+	// to any of the private keys in a keyring.
+	ErrUnknownRecipient = errors.New("secret: unknown recipient")
+
+	// ErrUnknownSender is returned when a message's sender isn't in
+	// the keyring.
+	ErrUnknownSender = errors.New("secret: unknown sender")
+)
+
+// A Fingerprint identifies a public key. It is the first 16 bytes of
+// the SHA-256 digest of the key.
+type Fingerprint [FingerprintSize]byte
+
+// KeyFingerprint returns the fingerprint of the public key.
+func KeyFingerprint(pub *[32]byte) Fingerprint {
+	var fp Fingerprint
+	h := sha256.Sum256(pub[:])
+	copy(fp[:], h[:])
+	return fp
+}
+
+// String returns the fingerprint in hex.
+func (fp Fingerprint) String() string {
+	return hex.EncodeToString(fp[:])
+}
+
+// publicKey derives the public key for a private key.
+func publicKey(priv *[32]byte) *[32]byte {
+	pub := new([32]byte)
+	curve25519.ScalarBaseMult(pub, priv)
+	return pub
+}
+
+// EncryptAuthenticated secures a message from the sender's long-term
+// private key to the peer's public key. Only the sender or the peer
+// could have produced the message. The output is
+//
+//	sender fingerprint || peer fingerprint || nonce ||
+//	    box(sender fingerprint || peer fingerprint || message)
+//
+// The box key is the same in both directions, so the fingerprints are
+// sealed with the message; otherwise a message could be reflected back
+// to its sender with the fingerprints swapped.
+func EncryptAuthenticated(priv, peer *[32]byte, message []byte) ([]byte, error) {
+	nonce, err := util.RandBytes(24)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	pub := publicKey(priv)
+	sender := KeyFingerprint(pub)
+	recipient := KeyFingerprint(peer)
+
+	out := make([]byte, 0, AuthOverhead+len(message))
+	out = append(out, sender[:]...)
+	out = append(out, recipient[:]...)
+	out = append(out, nonce...)
+
+	pt := make([]byte, 0, 2*FingerprintSize+len(message))
+	pt = append(pt, out[:2*FingerprintSize]...)
+	pt = append(pt, message...)
+	defer util.Zero(pt)
+
+	var n [24]byte
+	copy(n[:], nonce)
+	return box.Seal(out, pt, &n, peer, priv), nil
+}
+
+// A Keyring holds the receiver's private keys and the public keys of
+// known senders, indexed by fingerprint. It isn't safe to add keys
+// while decrypting from another goroutine.
+type Keyring struct {
+	private map[Fingerprint]*[32]byte
+	peers   map[Fingerprint]*[32]byte
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		private: map[Fingerprint]*[32]byte{},
+		peers:   map[Fingerprint]*[32]byte{},
+	}
+}
+
+// AddPrivate adds one of the receiver's private keys, returning the
+// fingerprint of its public key.
+func (k *Keyring) AddPrivate(priv *[32]byte) Fingerprint {
+	key := new([32]byte)
+	*key = *priv
+	fp := KeyFingerprint(publicKey(key))
+	k.private[fp] = key
+	return fp
+}
+
+// AddPeer adds a sender's public key, returning its fingerprint.
+func (k *Keyring) AddPeer(pub *[32]byte) Fingerprint {
+	key := new([32]byte)
+	*key = *pub
+	fp := KeyFingerprint(key)
+	k.peers[fp] = key
+	return fp
+}
+
+// Zero wipes the private keys in the keyring.
+func (k *Keyring) Zero() {
+	for fp, priv := range k.private {
+		util.Zero(priv[:])
+		delete(k.private, fp)
+	}
+}
+
+// Decrypt recovers a message sealed with EncryptAuthenticated. It
+// selects the private key from the recipient fingerprint and the
+// sender's public key from the sender fingerprint, and returns the
+// public key of the sender that was authenticated. The fingerprints
+// sealed in the box must match the ones used to select the keys.
+func (k *Keyring) Decrypt(message []byte) ([]byte, *[32]byte, error) {
+	if len(message) < AuthOverhead {
+		return nil, nil, ErrDecrypt
+	}
+
+	var sender, recipient Fingerprint
+	copy(sender[:], message)
+	copy(recipient[:], message[FingerprintSize:])
+
+	priv, ok := k.private[recipient]
+	if !ok {
+		return nil, nil, ErrUnknownRecipient
+	}
+
+	pub, ok := k.peers[sender]
+	if !ok {
+		return nil, nil, ErrUnknownSender
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], message[2*FingerprintSize:])
+	out, ok := box.Open(nil, message[2*FingerprintSize+24:], &nonce, pub, priv)
+	if !ok {
+		return nil, nil, ErrDecrypt
+	}
+
+	if !bytes.Equal(out[:2*FingerprintSize], message[:2*FingerprintSize]) {
+		util.Zero(out)
+		return nil, nil, ErrDecrypt
+	}
+	out = out[2*FingerprintSize:]
+
+	peer := new([32]byte)
+	*peer = *pub
+	return out, peer, nil
+}
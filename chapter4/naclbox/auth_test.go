@@ -0,0 +1,116 @@
+package naclbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestAuthenticated(t *testing.T) {
+	alicePub, alicePriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobPub, bobPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	malloryPub, malloryPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := EncryptAuthenticated(alicePriv, bobPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(out) != len(testMessage)+AuthOverhead {
+		t.Fatal("sealed message has the wrong length")
+	}
+
+	ring := NewKeyring()
+	defer ring.Zero()
+
+	if ring.AddPrivate(bobPriv) != KeyFingerprint(bobPub) {
+		t.Fatal("private key was indexed under the wrong fingerprint")
+	}
+
+	if _, _, err = ring.Decrypt(out); err != ErrUnknownSender {
+		t.Fatal("expected a message from an unknown sender to fail")
+	}
+
+	ring.AddPeer(alicePub)
+	ring.AddPeer(malloryPub)
+
+	pt, sender, err := ring.Decrypt(out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(pt, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	if *sender != *alicePub {
+		t.Fatal("wrong sender was reported")
+	}
+
+	// Mallory can't claim to be Alice: relabelling the sender changes
+	// the key used to open the box.
+	forged, err := EncryptAuthenticated(malloryPriv, bobPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	fp := KeyFingerprint(alicePub)
+	copy(forged, fp[:])
+	if _, _, err = ring.Decrypt(forged); err != ErrDecrypt {
+		t.Fatal("expected a message with a forged sender to fail")
+	}
+
+	toMallory, err := EncryptAuthenticated(alicePriv, malloryPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, _, err = ring.Decrypt(toMallory); err != ErrUnknownRecipient {
+		t.Fatal("expected a message to another recipient to fail")
+	}
+}
+
+// TestAuthenticatedReflection checks that a message from Alice to Bob
+// can't be passed back to Alice as a message from Bob by swapping the
+// fingerprints; the box key is the same in both directions.
+func TestAuthenticatedReflection(t *testing.T) {
+	_, alicePriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobPub, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := EncryptAuthenticated(alicePriv, bobPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ring := NewKeyring()
+	defer ring.Zero()
+	ring.AddPrivate(alicePriv)
+	ring.AddPeer(bobPub)
+
+	reflected := append([]byte{}, out...)
+	copy(reflected, out[FingerprintSize:2*FingerprintSize])
+	copy(reflected[FingerprintSize:], out[:FingerprintSize])
+	if _, _, err = ring.Decrypt(reflected); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt for a reflected message, have %v", err)
+	}
+}
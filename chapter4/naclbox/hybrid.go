@@ -0,0 +1,98 @@
+package naclbox
+
+import (
+	"crypto/mlkem"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/chapter4/kem"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// HybridPublicKeySize is the size of a hybrid public key: an
+	// ML-KEM-768 encapsulation key followed by an X25519 public key.
+	HybridPublicKeySize = mlkem.EncapsulationKeySize768 + 32
+
+	// HybridPrivateKeySize is the size of a hybrid private key, a
+	// seed from which both private keys are derived.
+	HybridPrivateKeySize = 32
+
+	hybridEncSize = mlkem.CiphertextSize768 + 32
+)
+
+// HybridOverhead is the length of additional data that will be added
+// to a message by EncryptHybrid.
+const HybridOverhead = hybridEncSize + secret.NonceSize + secretbox.Overhead
+
+// GenerateHybridKey creates a new key pair for the hybrid mode.
+func GenerateHybridKey() (*[HybridPublicKeySize]byte, *[HybridPrivateKeySize]byte, error) {
+	priv, err := kem.XWing().GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub := new([HybridPublicKeySize]byte)
+	copy(pub[:], priv.Public().Bytes())
+
+	seed := new([HybridPrivateKeySize]byte)
+	sb := priv.Bytes()
+	copy(seed[:], sb)
+	util.Zero(sb)
+	return pub, seed, nil
+}
+
+// EncryptHybrid secures a message to the peer's hybrid public key. The
+// message key comes from an ephemeral X25519 exchange combined with
+// ML-KEM-768 (the X-Wing KEM), so the message stays secret even if one
+// of the two is broken, for example by a future quantum computer.
+func EncryptHybrid(peer *[HybridPublicKeySize]byte, message []byte) ([]byte, error) {
+	pub, err := kem.XWing().ParsePublicKey(peer[:])
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	shared, enc, err := kem.XWing().Encapsulate(pub)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	var key [secret.KeySize]byte
+	copy(key[:], shared)
+	util.Zero(shared)
+	defer util.Zero(key[:])
+
+	ct, err := secret.Encrypt(&key, message)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	return append(enc, ct...), nil
+}
+
+// DecryptHybrid recovers a message secured with EncryptHybrid.
+func DecryptHybrid(priv *[HybridPrivateKeySize]byte, message []byte) ([]byte, error) {
+	if len(message) <= HybridOverhead {
+		return nil, ErrDecrypt
+	}
+
+	sk, err := kem.XWing().ParsePrivateKey(priv[:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	shared, err := kem.XWing().Decapsulate(sk, message[:hybridEncSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	var key [secret.KeySize]byte
+	copy(key[:], shared)
+	util.Zero(shared)
+	defer util.Zero(key[:])
+
+	out, err := secret.Decrypt(&key, message[hybridEncSize:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
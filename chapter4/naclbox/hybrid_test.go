@@ -0,0 +1,50 @@
+package naclbox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHybrid(t *testing.T) {
+	pub, priv, err := GenerateHybridKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	out, err := EncryptHybrid(pub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(out) != len(testMessage)+HybridOverhead {
+		t.Fatal("encrypted message has the wrong length")
+	}
+
+	msg, err := DecryptHybrid(priv, out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(msg, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	// Modifying either the ML-KEM or the X25519 part of the
+	// encapsulation, or the ciphertext, must cause decryption to fail.
+	for _, i := range []int{0, hybridEncSize - 1, len(out) - 1} {
+		out[i] ^= 1
+		if _, err = DecryptHybrid(priv, out); err != ErrDecrypt {
+			t.Fatal("expected a modified message to fail")
+		}
+		out[i] ^= 1
+	}
+
+	_, other, err := GenerateHybridKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = DecryptHybrid(other, out); err != ErrDecrypt {
+		t.Fatal("expected decryption with the wrong key to fail")
+	}
+}
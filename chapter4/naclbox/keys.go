@@ -0,0 +1,284 @@
+package naclbox
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"git.metacircular.net/kyle/gocrypto/util/bech32"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	publicHRP  = "age"
+	privateHRP = "AGE-SECRET-KEY-"
+
+	pemPublic  = "PUBLIC KEY"
+	pemPrivate = "PRIVATE KEY"
+)
+
+var (
+	// ErrInvalidKey is returned when a key can't be parsed.
+	ErrInvalidKey = errors.New("secret: invalid key")
+
+	// ErrInsecurePermissions is returned when loading a private key
+	// from a file that can be read or written by other users.
+	ErrInsecurePermissions = errors.New("secret: private key file has insecure permissions")
+)
+
+// A PublicKey is a Curve25519 public key. Its Key method returns the
+// *[32]byte used by the rest of the package.
+type PublicKey [32]byte
+
+// Key returns the key for use with the rest of the package.
+func (pk *PublicKey) Key() *[32]byte {
+	return (*[32]byte)(pk)
+}
+
+// Equal compares two public keys in constant time.
+func (pk *PublicKey) Equal(other *PublicKey) bool {
+	return subtle.ConstantTimeCompare(pk[:], other[:]) == 1
+}
+
+// Fingerprint returns the key's fingerprint.
+func (pk *PublicKey) Fingerprint() Fingerprint {
+	return KeyFingerprint(pk.Key())
+}
+
+// Base64 returns the standard base64 encoding of the key.
+func (pk *PublicKey) Base64() string {
+	return base64.StdEncoding.EncodeToString(pk[:])
+}
+
+// String returns the age-style "age1..." bech32 encoding of the key,
+// which can be used as a recipient by the age package.
+func (pk *PublicKey) String() string {
+	s, _ := bech32.Encode(publicHRP, pk[:])
+	return s
+}
+
+// PEM returns the key as a PEM-encoded X.509 SubjectPublicKeyInfo, as
+// produced by `openssl pkey -pubout`.
+func (pk *PublicKey) PEM() []byte {
+	key, _ := ecdh.X25519().NewPublicKey(pk[:])
+	der, _ := x509.MarshalPKIXPublicKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublic, Bytes: der})
+}
+
+func publicFromBytes(b []byte) (*PublicKey, error) {
+	if len(b) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	pk := new(PublicKey)
+	copy(pk[:], b)
+	return pk, nil
+}
+
+// ParsePublicKey parses an "age1..." bech32 public key.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	hrp, b, err := bech32.Decode(s)
+	if err != nil || hrp != publicHRP {
+		return nil, ErrInvalidKey
+	}
+	return publicFromBytes(b)
+}
+
+// ParsePublicKeyBase64 parses a base64-encoded public key.
+func ParsePublicKeyBase64(s string) (*PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return publicFromBytes(b)
+}
+
+// ParsePublicKeyPEM parses a PEM-encoded X25519 public key.
+func ParsePublicKeyPEM(in []byte) (*PublicKey, error) {
+	p, _ := pem.Decode(in)
+	if p == nil || p.Type != pemPublic {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := x509.ParsePKIXPublicKey(p.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	pub, ok := key.(*ecdh.PublicKey)
+	if !ok || pub.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+	return publicFromBytes(pub.Bytes())
+}
+
+// A KeyPair is a Curve25519 private key and its public key.
+type KeyPair struct {
+	Public  *PublicKey
+	Private *[32]byte
+}
+
+// GenerateKeyPair creates a new random key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{Public: (*PublicKey)(pub), Private: priv}, nil
+}
+
+// NewKeyPair returns the key pair for the private key, deriving the
+// public key from it. The private key is copied.
+func NewKeyPair(priv *[32]byte) *KeyPair {
+	kp := &KeyPair{Private: new([32]byte)}
+	*kp.Private = *priv
+	kp.Public = (*PublicKey)(publicKey(kp.Private))
+	return kp
+}
+
+func keyPairFromBytes(b []byte) (*KeyPair, error) {
+	defer util.Zero(b)
+	if len(b) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	var priv [32]byte
+	copy(priv[:], b)
+	defer util.Zero(priv[:])
+	return NewKeyPair(&priv), nil
+}
+
+// Equal compares two key pairs in constant time.
+func (kp *KeyPair) Equal(other *KeyPair) bool {
+	return subtle.ConstantTimeCompare(kp.Private[:], other.Private[:]) == 1
+}
+
+// Zero wipes the private key.
+func (kp *KeyPair) Zero() {
+	util.Zero(kp.Private[:])
+}
+
+// Base64 returns the standard base64 encoding of the private key.
+func (kp *KeyPair) Base64() string {
+	return base64.StdEncoding.EncodeToString(kp.Private[:])
+}
+
+// Bech32 returns the age-style "AGE-SECRET-KEY-1..." encoding of the
+// private key, which can be used as an identity by the age package.
+func (kp *KeyPair) Bech32() string {
+	s, _ := bech32.Encode(privateHRP, kp.Private[:])
+	return s
+}
+
+// PEM returns the private key as a PEM-encoded PKCS #8 structure, as
+// produced by `openssl genpkey -algorithm X25519`.
+func (kp *KeyPair) PEM() []byte {
+	key, _ := ecdh.X25519().NewPrivateKey(kp.Private[:])
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	defer util.Zero(der)
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivate, Bytes: der})
+}
+
+// ParseKeyPair parses an "AGE-SECRET-KEY-1..." bech32 private key.
+func ParseKeyPair(s string) (*KeyPair, error) {
+	hrp, b, err := bech32.Decode(s)
+	if err != nil || hrp != privateHRP {
+		return nil, ErrInvalidKey
+	}
+	return keyPairFromBytes(b)
+}
+
+// ParseKeyPairBase64 parses a base64-encoded private key.
+func ParseKeyPairBase64(s string) (*KeyPair, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return keyPairFromBytes(b)
+}
+
+// ParseKeyPairPEM parses a PEM-encoded PKCS #8 X25519 private key.
+func ParseKeyPairPEM(in []byte) (*KeyPair, error) {
+	p, _ := pem.Decode(in)
+	if p == nil || p.Type != pemPrivate {
+		return nil, ErrInvalidKey
+	}
+	defer util.Zero(p.Bytes)
+
+	key, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+	return keyPairFromBytes(priv.Bytes())
+}
+
+// SaveKeyPair writes the private key to a PEM file readable only by
+// its owner.
+func SaveKeyPair(path string, kp *KeyPair) error {
+	out := kp.PEM()
+	defer util.Zero(out)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// The file may have existed with looser permissions.
+	if err = f.Chmod(0600); err == nil {
+		_, err = f.Write(out)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LoadKeyPair reads a private key written by SaveKeyPair. It refuses
+// to load a key that other users could read or modify.
+func LoadKeyPair(path string) (*KeyPair, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, ErrInsecurePermissions
+	}
+
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(in)
+
+	return ParseKeyPairPEM(in)
+}
+
+// SavePublicKey writes the public key to a PEM file.
+func SavePublicKey(path string, pk *PublicKey) error {
+	return ioutil.WriteFile(path, pk.PEM(), 0644)
+}
+
+// LoadPublicKey reads a public key written by SavePublicKey.
+func LoadPublicKey(path string) (*PublicKey, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePublicKeyPEM(in)
+}
@@ -0,0 +1,118 @@
+package naclbox
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// MaxRecipients is the largest number of recipients a message can be
+// sealed to.
+const MaxRecipients = 65535
+
+// stanzaSize is the size of the payload key sealed to one recipient.
+const stanzaSize = secret.KeySize + box.Overhead
+
+// ErrRecipients is returned when a multi-recipient message is sealed
+// to no recipients or too many.
+var ErrRecipients = errors.New("secret: invalid number of recipients")
+
+// EncryptMulti secures a message to several peers at once. The message
+// is encrypted once under a random key with secretbox, and that key is
+// sealed to each peer with a single ephemeral key pair:
+//
+//	count (2 bytes) || ephemeral public key || nonce ||
+//	    count sealed keys || secretbox(nonce' || payload)
+//
+// The sealed keys carry no key IDs, so the message doesn't reveal who
+// the recipients are, only how many there are.
+func EncryptMulti(peers []*[32]byte, message []byte) ([]byte, error) {
+	if len(peers) == 0 || len(peers) > MaxRecipients {
+		return nil, ErrRecipients
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(priv[:])
+
+	key, err := secret.GenerateKey()
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	defer util.Zero(key[:])
+
+	// Each peer has a different shared key, so the nonce can be
+	// shared by all of the stanzas.
+	nonce, err := secret.GenerateNonce()
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	payload, err := secret.Encrypt(key, message)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+
+	out := make([]byte, 2, 2+32+24+len(peers)*stanzaSize+len(payload))
+	binary.BigEndian.PutUint16(out, uint16(len(peers)))
+	out = append(out, pub[:]...)
+	out = append(out, nonce[:]...)
+	for _, peer := range peers {
+		out = box.Seal(out, key[:], nonce, peer, priv)
+	}
+
+	return append(out, payload...), nil
+}
+
+// DecryptMulti recovers a message sealed with EncryptMulti. Every
+// stanza is tried against the private key, so the time taken doesn't
+// reveal which stanza belongs to the recipient.
+func DecryptMulti(priv *[32]byte, message []byte) ([]byte, error) {
+	if len(message) < 2 {
+		return nil, ErrDecrypt
+	}
+
+	count := int(binary.BigEndian.Uint16(message))
+	headerSize := 2 + 32 + 24 + count*stanzaSize
+	if count == 0 || len(message) < headerSize {
+		return nil, ErrDecrypt
+	}
+
+	var pub [32]byte
+	var nonce [24]byte
+	copy(pub[:], message[2:])
+	copy(nonce[:], message[34:])
+
+	var shared [32]byte
+	box.Precompute(&shared, &pub, priv)
+	defer util.Zero(shared[:])
+
+	var key *[secret.KeySize]byte
+	stanzas := message[58:headerSize]
+	for i := 0; i < count; i++ {
+		stanza := stanzas[i*stanzaSize : (i+1)*stanzaSize]
+		out, ok := box.OpenAfterPrecomputation(nil, stanza, &nonce, &shared)
+		if ok && key == nil {
+			key = new([secret.KeySize]byte)
+			copy(key[:], out)
+			util.Zero(out)
+		}
+	}
+
+	if key == nil {
+		return nil, ErrDecrypt
+	}
+	defer util.Zero(key[:])
+
+	out, err := secret.Decrypt(key, message[headerSize:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
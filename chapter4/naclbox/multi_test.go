@@ -0,0 +1,64 @@
+package naclbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestMulti(t *testing.T) {
+	var peers []*[32]byte
+	var privs []*[32]byte
+	for i := 0; i < 5; i++ {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		peers = append(peers, pub)
+		privs = append(privs, priv)
+	}
+
+	out, err := EncryptMulti(peers, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(out) != 2+32+24+5*stanzaSize+24+box.Overhead+len(testMessage) {
+		t.Fatal("sealed message has the wrong length")
+	}
+
+	for _, priv := range privs {
+		pt, err := DecryptMulti(priv, out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(pt, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+
+	_, stranger, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = DecryptMulti(stranger, out); err != ErrDecrypt {
+		t.Fatal("expected decryption by a non-recipient to fail")
+	}
+
+	out[len(out)-1] ^= 1
+	if _, err = DecryptMulti(privs[0], out); err != ErrDecrypt {
+		t.Fatal("expected a modified payload to fail")
+	}
+
+	if _, err = DecryptMulti(privs[0], out[:100]); err != ErrDecrypt {
+		t.Fatal("expected a truncated message to fail")
+	}
+
+	if _, err = EncryptMulti(nil, testMessage); err != ErrRecipients {
+		t.Fatal("expected sealing to no recipients to fail")
+	}
+}
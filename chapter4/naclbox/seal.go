@@ -0,0 +1,36 @@
+package naclbox
+
+import (
+	"crypto/rand"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// AnonymousOverhead is the length of additional data added to a
+// message by SealAnonymous.
+const AnonymousOverhead = box.AnonymousOverhead
+
+// SealAnonymous secures a message to the peer's public key in the same
+// format as libsodium's crypto_box_seal:
+//
+//	ephemeral public key || box(message)
+//
+// The sender is anonymous; the peer can't tell who sealed the message.
+func SealAnonymous(peer *[32]byte, message []byte) ([]byte, error) {
+	out, err := box.SealAnonymous(nil, message, peer, rand.Reader)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	return out, nil
+}
+
+// OpenAnonymous recovers a message sealed with SealAnonymous or
+// libsodium's crypto_box_seal. Both halves of the recipient's key pair
+// are needed to derive the nonce.
+func OpenAnonymous(pub, priv *[32]byte, message []byte) ([]byte, error) {
+	out, ok := box.OpenAnonymous(nil, message, pub, priv)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
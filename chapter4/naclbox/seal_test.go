@@ -0,0 +1,73 @@
+package naclbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// This sealed box was produced by libsodium 1.0.18's crypto_box_seal,
+// to the key pair from crypto_box_seed_keypair with the seed 00 01 ... 1f.
+var (
+	sealPub    = "4701d08488451f545a409fb58ae3e58581ca40ac3f7f114698cd71deac73ca01"
+	sealPriv   = "3d94eea49c580aef816935762be049559d6d1440dede12e6a125f1841fff8e6f"
+	sealedTest = "251813a61cd5c0f567849b4ffc7647a33241d8fe928fc0a9b237d238a004a96a6f1dc3b5e206f4232e4bb190420c6dc4a4803ce670a397b2985b7a35243d4117d6722aadd4d6faa0965d2aa30e2cb1dec92eebc4a1"
+)
+
+func hexKey(s string) *[32]byte {
+	key := new([32]byte)
+	b, _ := hex.DecodeString(s)
+	copy(key[:], b)
+	return key
+}
+
+func TestOpenLibsodium(t *testing.T) {
+	sealed, _ := hex.DecodeString(sealedTest)
+	out, err := OpenAnonymous(hexKey(sealPub), hexKey(sealPriv), sealed)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+}
+
+func TestSealAnonymous(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sealed, err := SealAnonymous(pub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(sealed) != len(testMessage)+AnonymousOverhead {
+		t.Fatal("sealed message has the wrong length")
+	}
+
+	// The standard library's implementation should agree with ours.
+	out, ok := box.OpenAnonymous(nil, sealed, pub, priv)
+	if !ok || !bytes.Equal(out, testMessage) {
+		t.Fatal("sealed box isn't compatible with box.OpenAnonymous")
+	}
+
+	out, err = OpenAnonymous(pub, priv, sealed)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	sealed[0] ^= 1
+	if _, err = OpenAnonymous(pub, priv, sealed); err != ErrDecrypt {
+		t.Fatal("expected a modified sealed box to fail")
+	}
+}
@@ -0,0 +1,317 @@
+package nistecdh
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+)
+
+var (
+	// ErrKeyConfirmation is returned when a peer's key confirmation
+	// tag doesn't match, or confirmation wasn't requested.
+	ErrKeyConfirmation = errors.New("nistecdh: key confirmation failed")
+
+	// ErrUnsupportedScheme is returned for an unknown key agreement
+	// scheme.
+	ErrUnsupportedScheme = errors.New("nistecdh: unsupported key agreement scheme")
+)
+
+// A Scheme is one of the SP 800-56A C(2e, 2s) key agreement schemes,
+// in which both parties contribute a static and an ephemeral key.
+type Scheme int
+
+const (
+	// FullUnified is the Full Unified Model: the shared secret is
+	// the ephemeral-ephemeral ECDH secret followed by the
+	// static-static one.
+	FullUnified Scheme = iota + 1
+
+	// FullMQV is Full MQV, which combines all four keys in a single
+	// point multiplication. Unlike the rest of this package, it uses
+	// crypto/elliptic and math/big arithmetic, which aren't constant
+	// time, on the static private key, so its timing can leak that
+	// key. Use FullUnified unless a peer requires MQV.
+	FullMQV
+)
+
+// A Party holds one side's keys for a key agreement, and the
+// identifier that binds the derived key to it.
+type Party struct {
+	ID        []byte
+	Static    *ecdsa.PrivateKey
+	Ephemeral *ecdsa.PrivateKey
+}
+
+// A Peer holds the other side's identifier and public keys.
+type Peer struct {
+	ID        []byte
+	Static    *ecdsa.PublicKey
+	Ephemeral *ecdsa.PublicKey
+}
+
+// AgreementOptions control how keys are derived from the shared
+// secret. Both sides must use the same options.
+type AgreementOptions struct {
+	// Hash is used by the one-step KDF and for key confirmation.
+	// The default is SHA-256.
+	Hash func() hash.Hash
+
+	// AlgorithmID names what the key will be used for.
+	AlgorithmID []byte
+
+	// KeySize is the size of the derived key; the default is 32
+	// bytes.
+	KeySize int
+
+	// Confirmation derives a MAC key along with the key, so that
+	// each side can prove to the other that it derived the same key.
+	Confirmation bool
+}
+
+// DefaultAgreementOptions derive a 32-byte key with SHA-256 and no
+// key confirmation.
+var DefaultAgreementOptions = &AgreementOptions{}
+
+// macKeySize is the size of the key confirmation MAC key.
+const macKeySize = 32
+
+// An Agreement is the result of a key agreement.
+type Agreement struct {
+	// Key is the derived key.
+	Key []byte
+
+	macKey  []byte
+	tag     []byte
+	peerTag []byte
+}
+
+// Tag returns the key confirmation tag to send to the peer, or nil if
+// confirmation wasn't requested.
+func (a *Agreement) Tag() []byte {
+	if a.tag == nil {
+		return nil
+	}
+	return append([]byte{}, a.tag...)
+}
+
+// Verify checks the peer's key confirmation tag.
+func (a *Agreement) Verify(tag []byte) error {
+	if a.peerTag == nil || !hmac.Equal(tag, a.peerTag) {
+		return ErrKeyConfirmation
+	}
+	return nil
+}
+
+// Zero wipes the agreement's keys.
+func (a *Agreement) Zero() {
+	util.Zero(a.Key)
+	util.Zero(a.macKey)
+}
+
+// agreementKeys are the converted and validated keys for an agreement.
+type agreementKeys struct {
+	static, ephemeral         *ecdh.PrivateKey
+	peerStatic, peerEphemeral *ecdh.PublicKey
+}
+
+func checkAgreementKeys(self *Party, peer *Peer) (*agreementKeys, error) {
+	if self == nil || peer == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	for _, priv := range []*ecdsa.PrivateKey{self.Static, self.Ephemeral} {
+		if err := CheckPrivateKey(priv); err != nil {
+			return nil, err
+		}
+	}
+
+	curve := self.Static.Curve
+	for _, pub := range []*ecdsa.PublicKey{&self.Ephemeral.PublicKey, peer.Static, peer.Ephemeral} {
+		if err := CheckPublicKey(pub); err != nil {
+			return nil, err
+		} else if pub.Curve != curve {
+			return nil, ErrCurveMismatch
+		}
+	}
+
+	// The conversions can't fail after the checks above.
+	keys := &agreementKeys{}
+	keys.static, _ = PrivateKeyFromECDSA(self.Static)
+	keys.ephemeral, _ = PrivateKeyFromECDSA(self.Ephemeral)
+	keys.peerStatic, _ = PublicKeyFromECDSA(peer.Static)
+	keys.peerEphemeral, _ = PublicKeyFromECDSA(peer.Ephemeral)
+	return keys, nil
+}
+
+// unifiedSecret computes Z = Ze || Zs.
+func unifiedSecret(keys *agreementKeys) ([]byte, error) {
+	ze, err := RawSharedSecret(keys.ephemeral, keys.peerEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(ze)
+
+	zs, err := RawSharedSecret(keys.static, keys.peerStatic)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(zs)
+
+	return append(append([]byte{}, ze...), zs...), nil
+}
+
+// avf is the associate value function from SP 800-56A: the low half of
+// the x-coordinate, with the next bit set.
+func avf(x, n *big.Int) *big.Int {
+	bit := new(big.Int).Lsh(big.NewInt(1), uint(n.BitLen()+1)/2)
+	v := new(big.Int).Mod(x, bit)
+	return v.Add(v, bit)
+}
+
+// mqvSecret computes the ECC MQV primitive from SP 800-56A section
+// 5.7.2.3. crypto/ecdh has no MQV, so this falls back to elliptic and
+// big.Int arithmetic, which isn't constant time; see FullMQV.
+func mqvSecret(self *Party, peer *Peer) ([]byte, error) {
+	curve := self.Static.Curve
+	n := curve.Params().N
+
+	// implicitsig = (de + avf(Qe) * ds) mod n
+	sig := avf(self.Ephemeral.X, n)
+	sig.Mul(sig, self.Static.D)
+	sig.Add(sig, self.Ephemeral.D)
+	sig.Mod(sig, n)
+
+	// P = implicitsig * (Qe' + avf(Qe') * Qs'); the cofactor of the
+	// NIST curves is one.
+	e := avf(peer.Ephemeral.X, n)
+	x, y := curve.ScalarMult(peer.Static.X, peer.Static.Y, e.Bytes())
+	x, y = curve.Add(peer.Ephemeral.X, peer.Ephemeral.Y, x, y)
+	x, y = curve.ScalarMult(x, y, sig.Bytes())
+	sig.SetInt64(0)
+
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidPoint
+	}
+	return x.FillBytes(make([]byte, fieldSize(curve))), nil
+}
+
+func (opts *AgreementOptions) hash() func() hash.Hash {
+	if opts.Hash == nil {
+		return sha256.New
+	}
+	return opts.Hash
+}
+
+func (opts *AgreementOptions) keySize() int {
+	if opts.KeySize == 0 {
+		return 32
+	}
+	return opts.KeySize
+}
+
+// macData builds the data for the key confirmation tag sent by the
+// provider P to the recipient R.
+func macData(message string, idP, idR, ephemP, ephemR []byte) []byte {
+	var out []byte
+	out = append(out, message...)
+	out = append(out, idP...)
+	out = append(out, idR...)
+	out = append(out, ephemP...)
+	return append(out, ephemR...)
+}
+
+func mac(h func() hash.Hash, key, data []byte) []byte {
+	m := hmac.New(h, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// Agree runs a C(2e, 2s) key agreement. The initiator is party U in
+// SP 800-56A, and the responder is party V. The key is derived with
+// the one-step KDF, with both parties' identifiers and ephemeral
+// public keys in its OtherInfo. If opts is nil, the default options
+// are used. FullUnified runs in constant time; FullMQV doesn't, so it
+// should only be used where a peer requires it.
+func Agree(scheme Scheme, self *Party, peer *Peer, initiator bool, opts *AgreementOptions) (*Agreement, error) {
+	if opts == nil {
+		opts = DefaultAgreementOptions
+	}
+
+	if opts.keySize() <= 0 {
+		return nil, ErrKDFLength
+	}
+
+	keys, err := checkAgreementKeys(self, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	var z []byte
+	switch scheme {
+	case FullUnified:
+		z, err = unifiedSecret(keys)
+	case FullMQV:
+		z, err = mqvSecret(self, peer)
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(z)
+
+	ephem := keys.ephemeral.PublicKey().Bytes()
+	peerEphem := keys.peerEphemeral.Bytes()
+
+	idU, idV, ephemU, ephemV := self.ID, peer.ID, ephem, peerEphem
+	if !initiator {
+		idU, idV, ephemU, ephemV = peer.ID, self.ID, peerEphem, ephem
+	}
+
+	subkeys := []Subkey{{Name: "key", Size: opts.keySize()}}
+	if opts.Confirmation {
+		subkeys = []Subkey{{Name: "mac", Size: macKeySize}, subkeys[0]}
+	}
+
+	length := 0
+	for _, sk := range subkeys {
+		length += sk.Size
+	}
+
+	kdf := &OneStep{
+		Hash: opts.hash(),
+		Info: OtherInfo{
+			AlgorithmID: opts.AlgorithmID,
+			PartyUInfo:  append(append([]byte{}, idU...), ephemU...),
+			PartyVInfo:  append(append([]byte{}, idV...), ephemV...),
+			SuppPubInfo: binary.BigEndian.AppendUint32(nil, uint32(length*8)),
+		},
+	}
+
+	derived, err := Derive(kdf, z, subkeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Agreement{Key: derived["key"]}
+	if !opts.Confirmation {
+		return a, nil
+	}
+
+	a.macKey = derived["mac"]
+	tagU := mac(opts.hash(), a.macKey, macData("KC_2_U", idU, idV, ephemU, ephemV))
+	tagV := mac(opts.hash(), a.macKey, macData("KC_2_V", idV, idU, ephemV, ephemU))
+	if initiator {
+		a.tag, a.peerTag = tagU, tagV
+	} else {
+		a.tag, a.peerTag = tagV, tagU
+	}
+	return a, nil
+}
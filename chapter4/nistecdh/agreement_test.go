@@ -0,0 +1,339 @@
+package nistecdh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func newParty(t *testing.T, curve elliptic.Curve, id string) *Party {
+	static, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &Party{ID: []byte(id), Static: static, Ephemeral: ephemeral}
+}
+
+func (p *Party) peer() *Peer {
+	return &Peer{ID: p.ID, Static: &p.Static.PublicKey, Ephemeral: &p.Ephemeral.PublicKey}
+}
+
+func TestAgree(t *testing.T) {
+	options := []*AgreementOptions{
+		nil,
+		{Hash: sha512.New384, AlgorithmID: []byte("AES-256-GCM"), KeySize: 48},
+		{Confirmation: true},
+	}
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		alice := newParty(t, curve, "alice")
+		bob := newParty(t, curve, "bob")
+
+		for _, scheme := range []Scheme{FullUnified, FullMQV} {
+			for i, opts := range options {
+				u, err := Agree(scheme, alice, bob.peer(), true, opts)
+				if err != nil {
+					t.Fatalf("%s, case %d: %v", curve.Params().Name, i, err)
+				}
+
+				v, err := Agree(scheme, bob, alice.peer(), false, opts)
+				if err != nil {
+					t.Fatalf("%s, case %d: %v", curve.Params().Name, i, err)
+				}
+
+				if !bytes.Equal(u.Key, v.Key) {
+					t.Fatalf("%s, case %d: keys don't match", curve.Params().Name, i)
+				}
+
+				if opts != nil && opts.KeySize != 0 && len(u.Key) != opts.KeySize {
+					t.Fatalf("%s, case %d: wrong key size %d", curve.Params().Name, i, len(u.Key))
+				}
+			}
+		}
+	}
+}
+
+func TestAgreeBinding(t *testing.T) {
+	alice := newParty(t, elliptic.P256(), "alice")
+	bob := newParty(t, elliptic.P256(), "bob")
+
+	unified, err := Agree(FullUnified, alice, bob.peer(), true, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	mqv, err := Agree(FullMQV, alice, bob.peer(), true, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if bytes.Equal(unified.Key, mqv.Key) {
+		t.Fatal("schemes should derive different keys")
+	}
+
+	// Both sides claiming to be the initiator get different keys.
+	v, err := Agree(FullUnified, bob, alice.peer(), true, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if bytes.Equal(unified.Key, v.Key) {
+		t.Fatal("keys should depend on the parties' roles")
+	}
+
+	peer := bob.peer()
+	peer.ID = []byte("mallory")
+	other, err := Agree(FullUnified, alice, peer, true, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if bytes.Equal(unified.Key, other.Key) {
+		t.Fatal("keys should depend on the party identifiers")
+	}
+
+	// A peer without the static private key can't compute the key,
+	// even with the right ephemeral key.
+	mallory := newParty(t, elliptic.P256(), "bob")
+	mallory.Ephemeral = bob.Ephemeral
+	for _, scheme := range []Scheme{FullUnified, FullMQV} {
+		u, err := Agree(scheme, alice, mallory.peer(), true, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		v, err := Agree(scheme, bob, alice.peer(), false, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if bytes.Equal(u.Key, v.Key) {
+			t.Fatal("keys should depend on the static keys")
+		}
+	}
+}
+
+// TestMQV checks the MQV shared secret against its definition: both
+// sides compute the x-coordinate of (sigU * sigV) * G.
+func TestMQV(t *testing.T) {
+	curve := elliptic.P384()
+	alice := newParty(t, curve, "alice")
+	bob := newParty(t, curve, "bob")
+	n := curve.Params().N
+
+	implicitsig := func(p *Party) *big.Int {
+		sig := avf(p.Ephemeral.X, n)
+		sig.Mul(sig, p.Static.D)
+		sig.Add(sig, p.Ephemeral.D)
+		return sig.Mod(sig, n)
+	}
+
+	s := new(big.Int).Mul(implicitsig(alice), implicitsig(bob))
+	s.Mod(s, n)
+	x, _ := curve.ScalarBaseMult(s.Bytes())
+
+	z, err := mqvSecret(alice, bob.peer())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := x.FillBytes(make([]byte, 48))
+	if !bytes.Equal(z, expected) {
+		t.Fatalf("have %x, want %x", z, expected)
+	}
+
+	// avf keeps the low 192 bits of x and sets bit 192.
+	bit := new(big.Int).Lsh(big.NewInt(1), 192)
+	low := new(big.Int).Mod(alice.Ephemeral.X, bit)
+	if a := avf(alice.Ephemeral.X, n); a.Cmp(low.Add(low, bit)) != 0 {
+		t.Fatalf("bad associate value %x", a)
+	}
+}
+
+func TestKeyConfirmation(t *testing.T) {
+	alice := newParty(t, elliptic.P256(), "alice")
+	bob := newParty(t, elliptic.P256(), "bob")
+	opts := &AgreementOptions{Confirmation: true}
+
+	u, err := Agree(FullMQV, alice, bob.peer(), true, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer u.Zero()
+
+	v, err := Agree(FullMQV, bob, alice.peer(), false, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer v.Zero()
+
+	if err = v.Verify(u.Tag()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = u.Verify(v.Tag()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Each side's tag is different, so a tag can't be reflected.
+	if err = u.Verify(u.Tag()); err != ErrKeyConfirmation {
+		t.Fatalf("expected ErrKeyConfirmation for a reflected tag, have %v", err)
+	}
+
+	tag := u.Tag()
+	tag[0] ^= 1
+	if err = v.Verify(tag); err != ErrKeyConfirmation {
+		t.Fatalf("expected ErrKeyConfirmation, have %v", err)
+	}
+
+	plain, err := Agree(FullMQV, alice, bob.peer(), true, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if plain.Tag() != nil {
+		t.Fatal("expected no tag without confirmation")
+	}
+
+	if err = plain.Verify(v.Tag()); err != ErrKeyConfirmation {
+		t.Fatalf("expected ErrKeyConfirmation, have %v", err)
+	}
+}
+
+func TestAgreeErrors(t *testing.T) {
+	alice := newParty(t, elliptic.P256(), "alice")
+	bob := newParty(t, elliptic.P384(), "bob")
+
+	if _, err := Agree(FullUnified, alice, bob.peer(), true, nil); err != ErrCurveMismatch {
+		t.Fatalf("expected ErrCurveMismatch, have %v", err)
+	}
+
+	bob = newParty(t, elliptic.P256(), "bob")
+	if _, err := Agree(Scheme(0), alice, bob.peer(), true, nil); err != ErrUnsupportedScheme {
+		t.Fatalf("expected ErrUnsupportedScheme, have %v", err)
+	}
+
+	peer := bob.peer()
+	peer.Ephemeral = &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}
+	if _, err := Agree(FullMQV, alice, peer, true, nil); err != ErrInvalidPoint {
+		t.Fatalf("expected ErrInvalidPoint, have %v", err)
+	}
+
+	bad := &Party{ID: alice.ID, Static: alice.Static}
+	if _, err := Agree(FullMQV, bad, bob.peer(), true, nil); !errors.Is(err, ErrKeyExchange) {
+		t.Fatalf("expected a key exchange error, have %v", err)
+	}
+
+	opts := &AgreementOptions{KeySize: -1}
+	if _, err := Agree(FullUnified, alice, bob.peer(), true, opts); err != ErrKDFLength {
+		t.Fatalf("expected ErrKDFLength, have %v", err)
+	}
+}
+
+// kasParty builds a party from fixed static and ephemeral scalars.
+func kasParty(t *testing.T, id, static, ephemeral string) *Party {
+	key := func(d string) *ecdsa.PrivateKey {
+		priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(mustHex(t, d))}
+		priv.Curve = elliptic.P256()
+		priv.X, priv.Y = priv.Curve.ScalarBaseMult(priv.D.Bytes())
+		return priv
+	}
+	return &Party{ID: []byte(id), Static: key(static), Ephemeral: key(ephemeral)}
+}
+
+// The known answers for TestAgreeKAT were computed outside this
+// package: the secrets with a separate P-256 implementation of the
+// SP 800-56A primitives, whose ECDH values match OpenSSL's, the keys
+// with OpenSSL's SSKDF, and the tags with Python's hmac module.
+func TestAgreeKAT(t *testing.T) {
+	alice := kasParty(t, "alice",
+		"2d1b7e2c3f6a4d1b9a0c7e5f4b3a29181706f5e4d3c2b1a0918273645f6e7d8c",
+		"5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b")
+	bob := kasParty(t, "bob",
+		"1f2e3d4c5b6a79880716253443526170f1e2d3c4b5a69788796a5b4c3d2e1f10",
+		"6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d")
+
+	keys, err := checkAgreementKeys(alice, bob.peer())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	z, err := unifiedSecret(keys)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := mustHex(t, "77566ca96a6ba6e61b567553866d69f70a195a61a10e4c8f9ec9c931f7eff0c4"+
+		"03f72381a7b3506b2cb572c1690c27e62eda700928df9df1732a1eb5b544f5cb")
+	if !bytes.Equal(z, expected) {
+		t.Fatalf("Full Unified: have Z %x, want %x", z, expected)
+	}
+
+	z, err = mqvSecret(alice, bob.peer())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected = mustHex(t, "be792aff624a70c2e12fd0038fe349a8f551a0a04292727c2759374a8592d273")
+	if !bytes.Equal(z, expected) {
+		t.Fatalf("Full MQV: have Z %x, want %x", z, expected)
+	}
+
+	vectors := []struct {
+		scheme     Scheme
+		key        string
+		tagU, tagV string
+	}{
+		{
+			FullUnified,
+			"b881b10fe77dbb15a82363bdce7b0640d4fed6b5c3ba691ee8b5c155f87ad6eb",
+			"b5106b0dec6ea1d9a8fe24e4f7a01a7758943b3169884ea90770c0a333df3f08",
+			"acd6ad3cc12d02a0aa8995aa69123e15f682043ac73c01b11266ab456f27aa6d",
+		},
+		{
+			FullMQV,
+			"44a72082861b4628a4b9ede4df015f7fbe319250eb66658ef7905455044dc153",
+			"6e01e1c9adf6712576b922949e33084de7ff505ef0e7dfe390e72ca0ac75172f",
+			"ed41b9f4f922f3dbb2a751ba5a45bf9570a30cd00e76e020ed7cfb022ce9e661",
+		},
+	}
+
+	opts := &AgreementOptions{AlgorithmID: []byte("AES-256-GCM"), Confirmation: true}
+	for _, v := range vectors {
+		u, err := Agree(v.scheme, alice, bob.peer(), true, opts)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(u.Key, mustHex(t, v.key)) {
+			t.Fatalf("scheme %d: have key %x, want %s", v.scheme, u.Key, v.key)
+		}
+
+		if !bytes.Equal(u.Tag(), mustHex(t, v.tagU)) {
+			t.Fatalf("scheme %d: have tag %x, want %s", v.scheme, u.Tag(), v.tagU)
+		}
+
+		if err = u.Verify(mustHex(t, v.tagV)); err != nil {
+			t.Fatalf("scheme %d: %v", v.scheme, err)
+		}
+
+		r, err := Agree(v.scheme, bob, alice.peer(), false, opts)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(r.Key, u.Key) || !bytes.Equal(r.Tag(), mustHex(t, v.tagV)) {
+			t.Fatalf("scheme %d: responder doesn't match the known answer", v.scheme)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package nistecdh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"math/big"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrKeyFormat is returned when a key can't be decoded, or isn't
+	// an EC key.
+	ErrKeyFormat = errors.New("nistecdh: invalid key encoding")
+
+	// ErrPassphrase is returned when an encrypted private key can't
+	// be decrypted with the passphrase, or no passphrase was given.
+	ErrPassphrase = errors.New("nistecdh: incorrect passphrase")
+)
+
+// PKCS8Iterations is the PBKDF2 iteration count used to encrypt
+// PKCS #8 private keys.
+const PKCS8Iterations = 600000
+
+// maxPKCS8Iterations limits the work an encrypted key can ask for.
+const maxPKCS8Iterations = 10000000
+
+// The PEM block types.
+const (
+	pemECPrivateKey        = "EC PRIVATE KEY"
+	pemPrivateKey          = "PRIVATE KEY"
+	pemEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	pemPublicKey           = "PUBLIC KEY"
+)
+
+// fieldSize returns the size of the curve's field elements, or zero if
+// the curve isn't supported.
+func fieldSize(curve elliptic.Curve) int {
+	switch curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		return (curve.Params().BitSize + 7) / 8
+	default:
+		return 0
+	}
+}
+
+// CheckPublicKey checks that the public key is a valid point on one of
+// the supported curves.
+func CheckPublicKey(pub *ecdsa.PublicKey) error {
+	_, err := PublicKeyFromECDSA(pub)
+	return err
+}
+
+// CheckPrivateKey checks that the private key is on one of the
+// supported curves, and that its public key matches its scalar.
+func CheckPrivateKey(priv *ecdsa.PrivateKey) error {
+	ecPriv, err := PrivateKeyFromECDSA(priv)
+	if err != nil {
+		return err
+	}
+
+	ecPub, err := PublicKeyFromECDSA(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if !ecPriv.PublicKey().Equal(ecPub) {
+		return ErrInvalidPrivateKey
+	}
+	return nil
+}
+
+// MarshalPoint encodes a public key as a SEC 1 point, either
+// uncompressed or compressed.
+func MarshalPoint(pub *ecdsa.PublicKey, compressed bool) ([]byte, error) {
+	ecPub, err := PublicKeyFromECDSA(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	point := ecPub.Bytes()
+	if !compressed {
+		return point, nil
+	}
+
+	size := fieldSize(pub.Curve)
+	out := make([]byte, 1+size)
+	out[0] = 2 | point[len(point)-1]&1
+	copy(out[1:], point[1:1+size])
+	return out, nil
+}
+
+// ParsePoint decodes a SEC 1 point on the curve, in either uncompressed
+// or compressed form.
+func ParsePoint(curve elliptic.Curve, in []byte) (*ecdsa.PublicKey, error) {
+	size := fieldSize(curve)
+	if size == 0 {
+		return nil, ErrUnsupportedCurve
+	}
+
+	var x, y *big.Int
+	switch {
+	case len(in) == 1+2*size && in[0] == 4:
+		x = new(big.Int).SetBytes(in[1 : 1+size])
+		y = new(big.Int).SetBytes(in[1+size:])
+	case len(in) == 1+size && (in[0] == 2 || in[0] == 3):
+		x, y = elliptic.UnmarshalCompressed(curve, in)
+		if x == nil {
+			return nil, ErrInvalidPoint
+		}
+	default:
+		return nil, ErrInvalidPoint
+	}
+
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if err := CheckPublicKey(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// ecKey returns the key if it is a valid EC private key on one of the
+// supported curves.
+func ecKey(key interface{}) (*ecdsa.PrivateKey, error) {
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrKeyFormat
+	}
+
+	if err := CheckPrivateKey(priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// ParsePrivateKeyPEM decodes a PEM-encoded EC private key, either a
+// SEC 1 "EC PRIVATE KEY", a PKCS #8 "PRIVATE KEY", or an "ENCRYPTED
+// PRIVATE KEY" that is decrypted with the passphrase.
+func ParsePrivateKeyPEM(in, passphrase []byte) (*ecdsa.PrivateKey, error) {
+	p, _ := pem.Decode(in)
+	if p == nil {
+		return nil, ErrKeyFormat
+	}
+
+	switch p.Type {
+	case pemECPrivateKey:
+		if len(p.Headers) != 0 {
+			// Legacy OpenSSL encryption isn't supported.
+			return nil, ErrKeyFormat
+		}
+
+		priv, err := x509.ParseECPrivateKey(p.Bytes)
+		if err != nil {
+			return nil, ErrKeyFormat
+		}
+		return ecKey(priv)
+	case pemPrivateKey:
+		key, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+		if err != nil {
+			return nil, ErrKeyFormat
+		}
+		return ecKey(key)
+	case pemEncryptedPrivateKey:
+		der, err := decryptPKCS8(p.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		defer util.Zero(der)
+
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, ErrPassphrase
+		}
+		return ecKey(key)
+	default:
+		return nil, ErrKeyFormat
+	}
+}
+
+// ParsePublicKeyPEM decodes a PEM-encoded PKIX "PUBLIC KEY".
+func ParsePublicKeyPEM(in []byte) (*ecdsa.PublicKey, error) {
+	p, _ := pem.Decode(in)
+	if p == nil || p.Type != pemPublicKey {
+		return nil, ErrKeyFormat
+	}
+
+	pub, err := ParseECPublicKey(p.Bytes)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	if err = CheckPublicKey(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// MarshalECPrivateKeyPEM encodes a private key as a SEC 1 "EC PRIVATE
+// KEY".
+func MarshalECPrivateKeyPEM(priv *ecdsa.PrivateKey) ([]byte, error) {
+	if err := CheckPrivateKey(priv); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+	defer util.Zero(der)
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemECPrivateKey, Bytes: der}), nil
+}
+
+// MarshalPKCS8PEM encodes a private key as a PKCS #8 "PRIVATE KEY".
+func MarshalPKCS8PEM(priv *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := marshalPKCS8(priv)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(der)
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+func marshalPKCS8(priv *ecdsa.PrivateKey) ([]byte, error) {
+	if err := CheckPrivateKey(priv); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+	return der, nil
+}
+
+// MarshalEncryptedPKCS8PEM encodes a private key as an "ENCRYPTED
+// PRIVATE KEY", encrypted with AES-256-CBC under a key derived from
+// the passphrase with PBKDF2-HMAC-SHA256. OpenSSL and other PKCS #8
+// implementations can read it.
+func MarshalEncryptedPKCS8PEM(priv *ecdsa.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphrase
+	}
+
+	der, err := marshalPKCS8(priv)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(der)
+
+	enc, err := encryptPKCS8(der, passphrase, PKCS8Iterations)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivateKey, Bytes: enc}), nil
+}
+
+// MarshalPublicKeyPEM encodes a public key as a PKIX "PUBLIC KEY".
+func MarshalPublicKeyPEM(pub *ecdsa.PublicKey) ([]byte, error) {
+	if err := CheckPublicKey(pub); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// Encrypted PKCS #8 keys use PBES2 from RFC 8018, with PBKDF2 and
+// AES-CBC.
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+func prfHash(prf asn1.ObjectIdentifier) func() hash.Hash {
+	switch {
+	case prf == nil, prf.Equal(oidHMACWithSHA1):
+		return sha1.New
+	case prf.Equal(oidHMACWithSHA256):
+		return sha256.New
+	case prf.Equal(oidHMACWithSHA384):
+		return sha512.New384
+	case prf.Equal(oidHMACWithSHA512):
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+func aesKeySize(oid asn1.ObjectIdentifier) int {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return 16
+	case oid.Equal(oidAES192CBC):
+		return 24
+	case oid.Equal(oidAES256CBC):
+		return 32
+	default:
+		return 0
+	}
+}
+
+func encryptPKCS8(der, passphrase []byte, iterations int) ([]byte, error) {
+	salt, err := util.RandBytes(16)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	iv, err := util.RandBytes(aes.BlockSize)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: iterations,
+		PRF: pkix.AlgorithmIdentifier{
+			Algorithm:  oidHMACWithSHA256,
+			Parameters: asn1.NullRawValue,
+		},
+	})
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfParams},
+		},
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParams},
+		},
+	})
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+
+	key := pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New)
+	defer util.Zero(key)
+
+	// NewCipher only returns an error with an invalid key size.
+	c, _ := aes.NewCipher(key)
+	ct := secret.Pad(der)
+	defer util.Zero(ct)
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(ct, ct)
+
+	out, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: ct,
+	})
+	if err != nil {
+		return nil, ErrKeyFormat
+	}
+	return out, nil
+}
+
+// unmarshalAll decodes DER, rejecting trailing data.
+func unmarshalAll(der []byte, out interface{}) error {
+	rest, err := asn1.Unmarshal(der, out)
+	if err != nil || len(rest) != 0 {
+		return ErrKeyFormat
+	}
+	return nil
+}
+
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if err := unmarshalAll(der, &info); err != nil {
+		return nil, err
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, ErrKeyFormat
+	}
+
+	var params pbes2Params
+	if err := unmarshalAll(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, ErrKeyFormat
+	}
+
+	var kdfParams pbkdf2Params
+	if err := unmarshalAll(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+
+	h := prfHash(kdfParams.PRF.Algorithm)
+	keySize := aesKeySize(params.EncryptionScheme.Algorithm)
+	if h == nil || keySize == 0 {
+		return nil, ErrKeyFormat
+	}
+
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keySize {
+		return nil, ErrKeyFormat
+	}
+
+	if kdfParams.Iterations < 1 || kdfParams.Iterations > maxPKCS8Iterations {
+		return nil, ErrKeyFormat
+	}
+
+	var iv []byte
+	if err := unmarshalAll(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	ct := info.EncryptedData
+	if len(iv) != aes.BlockSize || len(ct) == 0 || len(ct)%aes.BlockSize != 0 {
+		return nil, ErrKeyFormat
+	}
+
+	if len(passphrase) == 0 {
+		return nil, ErrPassphrase
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.Iterations, keySize, h)
+	defer util.Zero(key)
+
+	c, _ := aes.NewCipher(key)
+	out := make([]byte, len(ct))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(out, ct)
+
+	pt := secret.Unpad(out)
+	if pt == nil {
+		util.Zero(out)
+		return nil, ErrPassphrase
+	}
+	return pt, nil
+}
+
+// jwk is the subset of a JSON Web Key (RFC 7517) used for EC keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	D   string `json:"d,omitempty"`
+}
+
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// encodeFieldElement encodes a coordinate or scalar at the full size
+// of the curve's field elements, as RFC 7518 requires.
+func encodeFieldElement(size int, n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+func decodeFieldElement(size int, s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(buf) != size {
+		return nil, ErrKeyFormat
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func newJWK(pub *ecdsa.PublicKey) (*jwk, error) {
+	if err := CheckPublicKey(pub); err != nil {
+		return nil, err
+	}
+
+	size := fieldSize(pub.Curve)
+	return &jwk{
+		Kty: "EC",
+		Crv: pub.Curve.Params().Name,
+		X:   encodeFieldElement(size, pub.X),
+		Y:   encodeFieldElement(size, pub.Y),
+	}, nil
+}
+
+// MarshalJWK encodes a public key as a JWK.
+func MarshalJWK(pub *ecdsa.PublicKey) ([]byte, error) {
+	key, err := newJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(key)
+}
+
+// MarshalPrivateJWK encodes a private key as a JWK.
+func MarshalPrivateJWK(priv *ecdsa.PrivateKey) ([]byte, error) {
+	if err := CheckPrivateKey(priv); err != nil {
+		return nil, err
+	}
+
+	key, err := newJWK(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key.D = encodeFieldElement(fieldSize(priv.Curve), priv.D)
+	return json.Marshal(key)
+}
+
+func parseJWK(in []byte) (*jwk, *ecdsa.PublicKey, error) {
+	key := &jwk{}
+	if err := json.Unmarshal(in, key); err != nil || key.Kty != "EC" {
+		return nil, nil, ErrKeyFormat
+	}
+
+	curve, ok := jwkCurves[key.Crv]
+	if !ok {
+		return nil, nil, ErrUnsupportedCurve
+	}
+
+	size := fieldSize(curve)
+	x, err := decodeFieldElement(size, key.X)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	y, err := decodeFieldElement(size, key.Y)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if err = CheckPublicKey(pub); err != nil {
+		return nil, nil, err
+	}
+	return key, pub, nil
+}
+
+// ParseJWK decodes the public key from an EC JWK, which may also hold
+// the private key.
+func ParseJWK(in []byte) (*ecdsa.PublicKey, error) {
+	_, pub, err := parseJWK(in)
+	return pub, err
+}
+
+// ParsePrivateJWK decodes the private key from an EC JWK, checking it
+// against the public key.
+func ParsePrivateJWK(in []byte) (*ecdsa.PrivateKey, error) {
+	key, pub, err := parseJWK(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.D == "" {
+		return nil, ErrKeyFormat
+	}
+
+	d, err := decodeFieldElement(fieldSize(pub.Curve), key.D)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+	if err = CheckPrivateKey(priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
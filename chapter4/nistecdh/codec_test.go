@@ -0,0 +1,328 @@
+package nistecdh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"testing"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+func readTestData(t *testing.T, name string) []byte {
+	in, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return in
+}
+
+func testKeys(t *testing.T) []*ecdsa.PrivateKey {
+	var keys []*ecdsa.PrivateKey
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		keys = append(keys, priv)
+	}
+	return keys
+}
+
+// The keys in testdata were generated with OpenSSL.
+func TestParseOpenSSLKeys(t *testing.T) {
+	files := []struct {
+		name  string
+		curve elliptic.Curve
+	}{
+		{"p256-sec1.pem", elliptic.P256()},
+		{"p384-pkcs8.pem", elliptic.P384()},
+		{"p521-encrypted.pem", elliptic.P521()},
+		{"p256-encrypted-sha1.pem", elliptic.P256()},
+	}
+
+	for _, file := range files {
+		priv, err := ParsePrivateKeyPEM(readTestData(t, file.name), testPassphrase)
+		if err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+
+		if priv.Curve != file.curve {
+			t.Fatalf("%s: wrong curve %s", file.name, priv.Curve.Params().Name)
+		}
+	}
+
+	priv, err := ParsePrivateKeyPEM(readTestData(t, "p256-sec1.pem"), nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pub, err := ParsePublicKeyPEM(readTestData(t, "p256-public.pem"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !pub.Equal(&priv.PublicKey) {
+		t.Fatal("public key doesn't match the private key")
+	}
+
+	point, err := MarshalPoint(pub, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := mustHex(t, "03757b8d9def5527673c996f92b6efe62f9335531eeb511c7e68a513f9737853d1")
+	if !bytes.Equal(point, expected) {
+		t.Fatalf("have %x, want %x", point, expected)
+	}
+}
+
+func TestEncryptedPKCS8Errors(t *testing.T) {
+	in := readTestData(t, "p521-encrypted.pem")
+	if _, err := ParsePrivateKeyPEM(in, nil); err != ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase without a passphrase, have %v", err)
+	}
+
+	if _, err := ParsePrivateKeyPEM(in, []byte("wrong")); err != ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase with the wrong passphrase, have %v", err)
+	}
+
+	if _, err := MarshalEncryptedPKCS8PEM(testKeys(t)[0], nil); err != ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase, have %v", err)
+	}
+}
+
+func TestPEMRoundTrip(t *testing.T) {
+	for _, priv := range testKeys(t) {
+		name := priv.Curve.Params().Name
+		for _, marshal := range []func(*ecdsa.PrivateKey) ([]byte, error){
+			MarshalECPrivateKeyPEM, MarshalPKCS8PEM,
+		} {
+			out, err := marshal(priv)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			key, err := ParsePrivateKeyPEM(out, nil)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !key.Equal(priv) {
+				t.Fatalf("%s: private key round trip failed", name)
+			}
+		}
+
+		out, err := MarshalPublicKeyPEM(&priv.PublicKey)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		pub, err := ParsePublicKeyPEM(out)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !pub.Equal(&priv.PublicKey) {
+			t.Fatalf("%s: public key round trip failed", name)
+		}
+	}
+
+	priv := testKeys(t)[0]
+	out, err := MarshalEncryptedPKCS8PEM(priv, testPassphrase)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	key, err := ParsePrivateKeyPEM(out, testPassphrase)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !key.Equal(priv) {
+		t.Fatal("encrypted private key round trip failed")
+	}
+}
+
+func TestPEMErrors(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err = ParsePrivateKeyPEM(edPEM, nil); err != ErrKeyFormat {
+		t.Fatalf("expected ErrKeyFormat for an Ed25519 key, have %v", err)
+	}
+
+	der, err = x509.MarshalPKIXPublicKey(edPriv.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	edPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if _, err = ParsePublicKeyPEM(edPEM); err != ErrKeyFormat {
+		t.Fatalf("expected ErrKeyFormat for an Ed25519 key, have %v", err)
+	}
+
+	if _, err = ParsePrivateKeyPEM([]byte("not a key"), nil); err != ErrKeyFormat {
+		t.Fatalf("expected ErrKeyFormat, have %v", err)
+	}
+
+	if _, err = ParsePublicKeyPEM(readTestData(t, "p256-sec1.pem")); err != ErrKeyFormat {
+		t.Fatalf("expected ErrKeyFormat for a private key, have %v", err)
+	}
+
+	// A private key whose public key belongs to another key.
+	keys := testKeys(t)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bad := &ecdsa.PrivateKey{PublicKey: other.PublicKey, D: keys[0].D}
+	if _, err = MarshalPKCS8PEM(bad); !errors.Is(err, ErrInvalidPrivateKey) {
+		t.Fatalf("expected ErrInvalidPrivateKey, have %v", err)
+	}
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = MarshalPublicKeyPEM(&p224.PublicKey); err != ErrUnsupportedCurve {
+		t.Fatalf("expected ErrUnsupportedCurve, have %v", err)
+	}
+}
+
+func TestPoints(t *testing.T) {
+	for _, priv := range testKeys(t) {
+		pub := &priv.PublicKey
+		for _, compressed := range []bool{false, true} {
+			point, err := MarshalPoint(pub, compressed)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			parsed, err := ParsePoint(pub.Curve, point)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			if !parsed.Equal(pub) {
+				t.Fatal("point round trip failed")
+			}
+		}
+
+		point, _ := MarshalPoint(pub, false)
+		point[len(point)-1] ^= 1
+		if _, err := ParsePoint(pub.Curve, point); err != ErrInvalidPoint {
+			t.Fatalf("expected ErrInvalidPoint for a point off the curve, have %v", err)
+		}
+
+		if _, err := ParsePoint(pub.Curve, point[:len(point)-1]); err != ErrInvalidPoint {
+			t.Fatalf("expected ErrInvalidPoint for a truncated point, have %v", err)
+		}
+
+		if _, err := ParsePoint(pub.Curve, []byte{0}); err != ErrInvalidPoint {
+			t.Fatalf("expected ErrInvalidPoint for the point at infinity, have %v", err)
+		}
+	}
+
+	if _, err := ParsePoint(elliptic.P224(), []byte{4}); err != ErrUnsupportedCurve {
+		t.Fatalf("expected ErrUnsupportedCurve, have %v", err)
+	}
+}
+
+// rfc7517Key is the example EC private key from RFC 7517, appendix A.2.
+var rfc7517Key = []byte(`{"kty":"EC","crv":"P-256",
+	"x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+	"y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM",
+	"d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE",
+	"use":"enc","kid":"1"}`)
+
+func TestJWK(t *testing.T) {
+	priv, err := ParsePrivateJWK(rfc7517Key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pub, err := ParseJWK(rfc7517Key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !pub.Equal(&priv.PublicKey) {
+		t.Fatal("public key doesn't match the private key")
+	}
+
+	for _, priv := range testKeys(t) {
+		out, err := MarshalPrivateJWK(priv)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		key, err := ParsePrivateJWK(out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !key.Equal(priv) {
+			t.Fatal("private JWK round trip failed")
+		}
+
+		out, err = MarshalJWK(&priv.PublicKey)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		pub, err := ParseJWK(out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !pub.Equal(&priv.PublicKey) {
+			t.Fatal("public JWK round trip failed")
+		}
+
+		if _, err = ParsePrivateJWK(out); err != ErrKeyFormat {
+			t.Fatalf("expected ErrKeyFormat for a public JWK, have %v", err)
+		}
+	}
+
+	bad := [][]byte{
+		[]byte(`{"kty":"oct","k":"AAAA"}`),
+		[]byte(`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4"}`),
+		[]byte(`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IF"}`),
+		[]byte(`{"kty":"EC","crv":"secp256k1","x":"","y":""}`),
+		[]byte(`not json`),
+	}
+	for i, in := range bad {
+		if _, err = ParseJWK(in); err == nil {
+			t.Fatalf("case %d: invalid JWK should fail to parse", i)
+		}
+	}
+
+	// The RFC's private scalar with another public key.
+	other := testKeys(t)[0]
+	mismatched, err := MarshalJWK(&other.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	mismatched = append(mismatched[:len(mismatched)-1],
+		[]byte(`,"d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE"}`)...)
+	if _, err = ParsePrivateJWK(mismatched); !errors.Is(err, ErrInvalidPrivateKey) {
+		t.Fatalf("expected ErrInvalidPrivateKey, have %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package nistecdh
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrKDFLength is returned when a KDF is asked for no output or
+	// for more output than it can produce.
+	ErrKDFLength = errors.New("nistecdh: invalid KDF output length")
+
+	// ErrKDFHash is returned when a KDF has no hash function.
+	ErrKDFHash = errors.New("nistecdh: KDF has no hash function")
+
+	// ErrSubkey is returned when a subkey has no name, a duplicate
+	// name, or a length that isn't positive.
+	ErrSubkey = errors.New("nistecdh: invalid subkey")
+)
+
+// A KDF derives keying material from a shared secret.
+type KDF interface {
+	// Derive returns length bytes of keying material derived from
+	// the shared secret z.
+	Derive(z []byte, length int) ([]byte, error)
+}
+
+// OtherInfo is the context that binds derived keys to their purpose
+// and to the parties that agreed on them. Its encoding follows the
+// concatenation format of SP 800-56A: AlgorithmID, PartyUInfo and
+// PartyVInfo are each prefixed with their 32-bit big-endian length,
+// and SuppPubInfo and SuppPrivInfo are appended as they are. This is
+// the layout JWE uses for ECDH-ES.
+type OtherInfo struct {
+	// AlgorithmID names what the derived keys will be used for.
+	AlgorithmID []byte
+
+	// PartyUInfo and PartyVInfo identify the initiator and the
+	// responder, usually with their public keys.
+	PartyUInfo []byte
+	PartyVInfo []byte
+
+	// SuppPubInfo and SuppPrivInfo carry any other public and
+	// private data both sides know, such as the key length.
+	SuppPubInfo  []byte
+	SuppPrivInfo []byte
+}
+
+func appendLengthPrefixed(out, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+// Bytes returns the encoded OtherInfo.
+func (info *OtherInfo) Bytes() []byte {
+	var out []byte
+	out = appendLengthPrefixed(out, info.AlgorithmID)
+	out = appendLengthPrefixed(out, info.PartyUInfo)
+	out = appendLengthPrefixed(out, info.PartyVInfo)
+	out = append(out, info.SuppPubInfo...)
+	return append(out, info.SuppPrivInfo...)
+}
+
+// counterKDF runs the hash-based KDFs that differ only in where the
+// 32-bit counter goes: H(counter || z || info) if counterFirst is
+// set, or H(z || counter || info) otherwise.
+func counterKDF(h func() hash.Hash, counterFirst bool, z, info []byte, length int) ([]byte, error) {
+	if h == nil {
+		return nil, ErrKDFHash
+	}
+
+	size := h().Size()
+	if length <= 0 || uint64(length+size-1)/uint64(size) > math.MaxUint32 {
+		return nil, ErrKDFLength
+	}
+
+	out := make([]byte, 0, length+size)
+	var counter [4]byte
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		d := h()
+		if counterFirst {
+			d.Write(counter[:])
+			d.Write(z)
+		} else {
+			d.Write(z)
+			d.Write(counter[:])
+		}
+		d.Write(info)
+		out = d.Sum(out)
+	}
+
+	util.Zero(out[length:])
+	return out[:length], nil
+}
+
+// OneStep is the one-step KDF from NIST SP 800-56C, which computes
+// H(counter || z || FixedInfo) for each block of output. H is the
+// hash function, or HMAC keyed with Salt if HMAC is set; a nil Salt
+// is the default salt of all zeros.
+type OneStep struct {
+	Hash func() hash.Hash
+	HMAC bool
+	Salt []byte
+	Info OtherInfo
+}
+
+// Derive implements KDF.
+func (kdf *OneStep) Derive(z []byte, length int) ([]byte, error) {
+	h := kdf.Hash
+	if kdf.HMAC && h != nil {
+		h = func() hash.Hash { return hmac.New(kdf.Hash, kdf.Salt) }
+	}
+	return counterKDF(h, true, z, kdf.Info.Bytes(), length)
+}
+
+// X963 is the KDF from ANSI X9.63, also used by SEC 1, which computes
+// H(z || counter || SharedInfo) for each block of output. An encoded
+// OtherInfo can be used as SharedInfo to bind keys to the parties.
+type X963 struct {
+	Hash       func() hash.Hash
+	SharedInfo []byte
+}
+
+// Derive implements KDF.
+func (kdf *X963) Derive(z []byte, length int) ([]byte, error) {
+	return counterKDF(kdf.Hash, false, z, kdf.SharedInfo, length)
+}
+
+// HKDF is the HMAC-based KDF from RFC 5869, which NIST SP 800-56C
+// allows as a two-step KDF. If either party's identifier is given,
+// both are appended to Info, each prefixed with its 32-bit length;
+// otherwise Info is used as it is, so keys match any other HKDF.
+type HKDF struct {
+	Hash       func() hash.Hash
+	Salt       []byte
+	Info       []byte
+	PartyUInfo []byte
+	PartyVInfo []byte
+}
+
+// Derive implements KDF.
+func (kdf *HKDF) Derive(z []byte, length int) ([]byte, error) {
+	if kdf.Hash == nil {
+		return nil, ErrKDFHash
+	}
+
+	if length <= 0 || length > 255*kdf.Hash().Size() {
+		return nil, ErrKDFLength
+	}
+
+	info := append([]byte{}, kdf.Info...)
+	if kdf.PartyUInfo != nil || kdf.PartyVInfo != nil {
+		info = appendLengthPrefixed(info, kdf.PartyUInfo)
+		info = appendLengthPrefixed(info, kdf.PartyVInfo)
+	}
+
+	out := make([]byte, length)
+	if _, err := hkdf.New(kdf.Hash, z, kdf.Salt, info).Read(out); err != nil {
+		return nil, ErrKDFLength
+	}
+	return out, nil
+}
+
+// A Subkey names one of the keys to split from a KDF's output.
+type Subkey struct {
+	Name string
+	Size int
+}
+
+// Keys holds named subkeys.
+type Keys map[string][]byte
+
+// Zero wipes all of the keys.
+func (keys Keys) Zero() {
+	for _, key := range keys {
+		util.Zero(key)
+	}
+}
+
+// Derive runs the KDF over the shared secret z for the total size of
+// the subkeys, then splits the output into them in the order they are
+// given. For example, a session could derive one key for each
+// direction; both sides must list the subkeys in the same order.
+func Derive(kdf KDF, z []byte, subkeys ...Subkey) (Keys, error) {
+	if len(subkeys) == 0 {
+		return nil, ErrSubkey
+	}
+
+	length := 0
+	for i, sk := range subkeys {
+		if sk.Name == "" || sk.Size <= 0 || sk.Size > math.MaxInt32 {
+			return nil, ErrSubkey
+		}
+
+		for _, other := range subkeys[:i] {
+			if other.Name == sk.Name {
+				return nil, ErrSubkey
+			}
+		}
+
+		length += sk.Size
+		if length > math.MaxInt32 {
+			return nil, ErrKDFLength
+		}
+	}
+
+	out, err := kdf.Derive(z, length)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(out)
+
+	keys := make(Keys, len(subkeys))
+	for _, sk := range subkeys {
+		keys[sk.Name] = append([]byte{}, out[:sk.Size]...)
+		out = out[sk.Size:]
+	}
+	return keys, nil
+}
+
+// DeriveKeys computes the raw shared secret between the keys and
+// derives the subkeys from it with the KDF.
+func DeriveKeys(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, kdf KDF, subkeys ...Subkey) (Keys, error) {
+	z, err := RawSharedSecret(priv, pub)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(z)
+
+	return Derive(kdf, z, subkeys...)
+}
+
+// DeriveECDH is DeriveKeys for ECDSA keys.
+func DeriveECDH(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, kdf KDF, subkeys ...Subkey) (Keys, error) {
+	ecPriv, ecPub, err := convert(priv, pub)
+	if err != nil {
+		return nil, err
+	}
+	return DeriveKeys(ecPriv, ecPub, kdf, subkeys...)
+}
@@ -0,0 +1,258 @@
+package nistecdh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+var (
+	kdfSecret, _ = hex.DecodeString("96c05619d56c328ab95fe84b18264b08725b85e33fd34f08")
+	kdfSalt, _   = hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	kdfInfo      = OtherInfo{
+		AlgorithmID: []byte("A128GCM"),
+		PartyUInfo:  []byte("Alice"),
+		PartyVInfo:  []byte("Bob"),
+		SuppPubInfo: []byte{0, 0, 1, 0x40},
+	}
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	out, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return out
+}
+
+// The expected outputs for these tests were computed with OpenSSL's
+// SSKDF, X963KDF and HKDF implementations.
+func TestKDFVectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		kdf      KDF
+		expected string
+	}{
+		{
+			name:     "one-step hash",
+			kdf:      &OneStep{Hash: sha256.New, Info: kdfInfo},
+			expected: "f1d5cf841260766755401165ee9db7eee3e012d6bc91ba088cc747ebf7d79f7c04716ea3b17aa9f3",
+		},
+		{
+			name:     "one-step HMAC",
+			kdf:      &OneStep{Hash: sha256.New, HMAC: true, Salt: kdfSalt, Info: kdfInfo},
+			expected: "343858b077342504a7168ab3f40d410169f4e32cbf677dec50e2baf0e497460aea46ae6f190717ec",
+		},
+		{
+			name:     "one-step HMAC default salt",
+			kdf:      &OneStep{Hash: sha256.New, HMAC: true, Info: kdfInfo},
+			expected: "2b3e7518000c674698273b499c6aaf0cd3155c53e27da798fa4e44a52dbb531b90b98700224f3609",
+		},
+		{
+			name:     "X9.63",
+			kdf:      &X963{Hash: sha256.New, SharedInfo: []byte("nistecdh test")},
+			expected: "6796a36fb66570eaaa12a38a9497b181e6c987ce8fcfc8aa702b80698ba6651f40a2efad0bba6563",
+		},
+		{
+			name:     "HKDF",
+			kdf:      &HKDF{Hash: sha256.New, Salt: kdfSalt, Info: []byte("nistecdh test")},
+			expected: "7e7c3b79f463fd65668663d5898d1ec7e0d25e833aa41c0d95e5f493ad07406f235a1d0795411f44",
+		},
+		{
+			name: "HKDF with parties",
+			kdf: &HKDF{Hash: sha256.New, Salt: kdfSalt, Info: []byte("nistecdh test"),
+				PartyUInfo: []byte("Alice"), PartyVInfo: []byte("Bob")},
+			expected: "dc6840e827dea8aee499132e2eb34cf20bdc86f23bd36295534e37dad2bf04b1f37322b4f220073d",
+		},
+	}
+
+	for _, test := range tests {
+		out, err := test.kdf.Derive(kdfSecret, 40)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if !bytes.Equal(out, mustHex(t, test.expected)) {
+			t.Fatalf("%s: have %x, want %s", test.name, out, test.expected)
+		}
+	}
+}
+
+// TestConcatKDF checks the one-step KDF against the JWE ECDH-ES
+// example in RFC 7518, appendix C.
+func TestConcatKDF(t *testing.T) {
+	z := mustHex(t, "9e56d91d817135d372834283bf84269cfb316ea3da806a48f6daa7798cfe90c4")
+	kdf := &OneStep{
+		Hash: sha256.New,
+		Info: OtherInfo{
+			AlgorithmID: []byte("A128GCM"),
+			PartyUInfo:  []byte("Alice"),
+			PartyVInfo:  []byte("Bob"),
+			SuppPubInfo: []byte{0, 0, 0, 128},
+		},
+	}
+
+	key, err := kdf.Derive(z, 16)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []byte{86, 170, 141, 234, 248, 35, 109, 32,
+		92, 34, 40, 205, 113, 167, 16, 26}
+	if !bytes.Equal(key, expected) {
+		t.Fatalf("have %x, want %x", key, expected)
+	}
+}
+
+func TestKDFErrors(t *testing.T) {
+	if _, err := (&X963{}).Derive(kdfSecret, 32); err != ErrKDFHash {
+		t.Fatalf("expected ErrKDFHash, have %v", err)
+	}
+
+	if _, err := (&OneStep{HMAC: true}).Derive(kdfSecret, 32); err != ErrKDFHash {
+		t.Fatalf("expected ErrKDFHash, have %v", err)
+	}
+
+	if _, err := (&OneStep{Hash: sha256.New}).Derive(kdfSecret, 0); err != ErrKDFLength {
+		t.Fatalf("expected ErrKDFLength, have %v", err)
+	}
+
+	hkdf := &HKDF{Hash: sha256.New}
+	if _, err := hkdf.Derive(kdfSecret, 255*sha256.Size+1); err != ErrKDFLength {
+		t.Fatalf("expected ErrKDFLength, have %v", err)
+	}
+
+	if _, err := hkdf.Derive(kdfSecret, 255*sha256.Size); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestDeriveSubkeys(t *testing.T) {
+	kdf := &X963{Hash: sha256.New, SharedInfo: []byte("nistecdh test")}
+	keys, err := Derive(kdf, kdfSecret,
+		Subkey{Name: "alice->bob", Size: 32},
+		Subkey{Name: "bob->alice", Size: 8})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer keys.Zero()
+
+	out, err := kdf.Derive(kdfSecret, 40)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 subkeys, have %d", len(keys))
+	} else if !bytes.Equal(keys["alice->bob"], out[:32]) {
+		t.Fatal("first subkey doesn't match the start of the output")
+	} else if !bytes.Equal(keys["bob->alice"], out[32:]) {
+		t.Fatal("second subkey doesn't match the end of the output")
+	}
+
+	bad := [][]Subkey{
+		nil,
+		{{Name: "", Size: 32}},
+		{{Name: "key", Size: 0}},
+		{{Name: "key", Size: 32}, {Name: "key", Size: 32}},
+	}
+	for i, subkeys := range bad {
+		if _, err := Derive(kdf, kdfSecret, subkeys...); err != ErrSubkey {
+			t.Fatalf("case %d: expected ErrSubkey, have %v", i, err)
+		}
+	}
+}
+
+func TestDeriveKeys(t *testing.T) {
+	alice, err := GenerateKey(P384)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, err := GenerateKey(P384)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Binding the public keys into the KDF gives keys that are
+	// specific to this pair of parties.
+	kdf := &HKDF{
+		Hash:       sha256.New,
+		Info:       []byte("nistecdh session"),
+		PartyUInfo: alice.PublicKey().Bytes(),
+		PartyVInfo: bob.PublicKey().Bytes(),
+	}
+	subkeys := []Subkey{{"alice->bob", 32}, {"bob->alice", 32}}
+
+	aliceKeys, err := DeriveKeys(alice, bob.PublicKey(), kdf, subkeys...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobKeys, err := DeriveKeys(bob, alice.PublicKey(), kdf, subkeys...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, sk := range subkeys {
+		if !bytes.Equal(aliceKeys[sk.Name], bobKeys[sk.Name]) {
+			t.Fatalf("subkey %s doesn't match", sk.Name)
+		}
+	}
+
+	if bytes.Equal(aliceKeys["alice->bob"], aliceKeys["bob->alice"]) {
+		t.Fatal("subkeys for each direction should differ")
+	}
+
+	kdf.PartyUInfo, kdf.PartyVInfo = kdf.PartyVInfo, kdf.PartyUInfo
+	swapped, err := DeriveKeys(alice, bob.PublicKey(), kdf, subkeys...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if bytes.Equal(swapped["alice->bob"], aliceKeys["alice->bob"]) {
+		t.Fatal("keys should depend on the party identifiers")
+	}
+
+	other, err := GenerateKey(X25519)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = DeriveKeys(alice, other.PublicKey(), kdf, subkeys...)
+	if !errors.Is(err, ErrCurveMismatch) {
+		t.Fatalf("expected ErrCurveMismatch, have %v", err)
+	}
+}
+
+func TestDeriveECDH(t *testing.T) {
+	alice, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	kdf := &OneStep{Hash: sha256.New, Info: OtherInfo{AlgorithmID: []byte("test")}}
+	abKeys, err := DeriveECDH(alice, &bob.PublicKey, kdf, Subkey{"key", 32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	baKeys, err := DeriveECDH(bob, &alice.PublicKey, kdf, Subkey{"key", 32})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(abKeys["key"], baKeys["key"]) {
+		t.Fatal("key exchange failed")
+	}
+}
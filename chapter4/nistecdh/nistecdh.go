@@ -1,35 +1,257 @@
 // Package nistecdh performs ECDH using standard library ECDSA keys.
+//
+// The exchange itself is done with crypto/ecdh, which is constant time
+// and always produces shared secrets of the full field element size.
+// ECDSA keys are converted on the way in, so existing NIST curve keys
+// keep working; X25519 is available through the Curve type for new
+// code that doesn't need NIST curves.
+//
+// ECDH and KeyExchange hash the shared secret into a single key with
+// no context. DeriveKeys and DeriveECDH instead run it through a KDF
+// (the SP 800-56C one-step KDF, ANSI X9.63, or HKDF) that can bind the
+// keys to their purpose and to both parties, and split the output into
+// named subkeys, such as one for each direction. Agree runs the
+// SP 800-56A Full Unified Model and Full MQV schemes, which use both
+// parties' static and ephemeral keys. Full MQV isn't constant time, so
+// Full Unified is the one to use unless MQV is required.
+//
+// Keys can be read and written as PEM (SEC 1, PKCS #8, passphrase
+// encrypted PKCS #8, and PKIX public keys), as SEC 1 points, and as
+// JWKs. Every key that is read is checked to be on a supported curve,
+// and private keys are checked against their public keys.
 package nistecdh
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha512"
 	"crypto/x509"
 	"errors"
 
 	"git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
+	"git.metacircular.net/kyle/gocrypto/util"
 )
 
-// ErrKeyExchange is returned if the key exchange fails.
-var ErrKeyExchange = errors.New("key exchange failed")
+var (
+	// ErrKeyExchange is returned if the key exchange fails.
+	ErrKeyExchange = errors.New("key exchange failed")
 
-// ECDH computes a shared key from a private key and a peer's public key.
-func ECDH(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
-	if pub == nil || priv == nil {
-		return nil, ErrKeyExchange
+	// ErrInvalidPoint is returned when a public key isn't a valid
+	// point on its curve, including the point at infinity.
+	ErrInvalidPoint = &KeyError{Reason: "invalid public key point"}
+
+	// ErrLowOrderPoint is returned when an X25519 public key is a
+	// low-order point, which would make the shared secret zero.
+	ErrLowOrderPoint = &KeyError{Reason: "low-order public key point"}
+
+	// ErrInvalidPrivateKey is returned when a private key can't be
+	// used.
+	ErrInvalidPrivateKey = &KeyError{Reason: "invalid private key"}
+
+	// ErrCurveMismatch is returned when the keys are on different
+	// curves.
+	ErrCurveMismatch = &KeyError{Reason: "keys are on different curves"}
+
+	// ErrUnsupportedCurve is returned for curves that aren't supported.
+	ErrUnsupportedCurve = &KeyError{Reason: "unsupported curve"}
+)
+
+// A KeyError is returned when a key exchange fails because of one of
+// the keys. It matches ErrKeyExchange with errors.Is, so callers that
+// only check for a failed exchange don't need to know the reason.
+type KeyError struct {
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return "nistecdh: " + e.Reason
+}
+
+// Is reports whether the target is ErrKeyExchange.
+func (e *KeyError) Is(target error) bool {
+	return target == ErrKeyExchange
+}
+
+// A Curve selects the curve for keys created with GenerateKey.
+type Curve int
+
+// The supported curves.
+const (
+	P256 Curve = iota + 1
+	P384
+	P521
+	X25519
+)
+
+// ECDH returns the crypto/ecdh curve.
+func (c Curve) ECDH() ecdh.Curve {
+	switch c {
+	case P256:
+		return ecdh.P256()
+	case P384:
+		return ecdh.P384()
+	case P521:
+		return ecdh.P521()
+	case X25519:
+		return ecdh.X25519()
+	default:
+		return nil
+	}
+}
+
+// GenerateKey generates a new key pair on the curve.
+func GenerateKey(c Curve) (*ecdh.PrivateKey, error) {
+	curve := c.ECDH()
+	if curve == nil {
+		return nil, ErrUnsupportedCurve
+	}
+	return curve.GenerateKey(rand.Reader)
+}
+
+// ParsePublicKey parses a public key on the curve: an uncompressed
+// point for the NIST curves, or 32 bytes for X25519.
+func ParsePublicKey(c Curve, in []byte) (*ecdh.PublicKey, error) {
+	curve := c.ECDH()
+	if curve == nil {
+		return nil, ErrUnsupportedCurve
+	}
+
+	pub, err := curve.NewPublicKey(in)
+	if err != nil {
+		return nil, ErrInvalidPoint
+	}
+	return pub, nil
+}
+
+// PrivateKeyFromECDSA converts an ECDSA private key for use with
+// crypto/ecdh.
+func PrivateKeyFromECDSA(priv *ecdsa.PrivateKey) (*ecdh.PrivateKey, error) {
+	if priv == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	switch priv.Curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+	default:
+		return nil, ErrUnsupportedCurve
+	}
+
+	key, err := priv.ECDH()
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	return key, nil
+}
+
+// PublicKeyFromECDSA converts an ECDSA public key for use with
+// crypto/ecdh, checking that it is a valid point.
+func PublicKeyFromECDSA(pub *ecdsa.PublicKey) (*ecdh.PublicKey, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return nil, ErrInvalidPoint
+	}
+
+	switch pub.Curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+	default:
+		return nil, ErrUnsupportedCurve
+	}
+
+	key, err := pub.ECDH()
+	if err != nil {
+		return nil, ErrInvalidPoint
+	}
+	return key, nil
+}
+
+// RawSharedSecret computes the raw shared secret between keys on the
+// same curve. It has a fixed length for each curve: the field element
+// size for the NIST curves, and 32 bytes for X25519. This should be
+// passed through a KDF before it is used as a key.
+func RawSharedSecret(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	if priv == nil {
+		return nil, ErrInvalidPrivateKey
+	} else if pub == nil {
+		return nil, ErrInvalidPoint
+	} else if priv.Curve() != pub.Curve() {
+		return nil, ErrCurveMismatch
+	}
+
+	z, err := priv.ECDH(pub)
+	if err != nil {
+		// X25519 is the only curve whose public keys can be
+		// valid encodings and still fail the exchange.
+		if pub.Curve() == ecdh.X25519() {
+			return nil, ErrLowOrderPoint
+		}
+		return nil, ErrInvalidPoint
+	}
+	return z, nil
+}
+
+// KeyExchange computes a shared key from a private key and a peer's
+// public key on the same curve, in the same way as ECDH.
+func KeyExchange(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	z, err := RawSharedSecret(priv, pub)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(z)
+
+	shared := sha512.Sum512(z)
+	defer util.Zero(shared[:])
+	return append([]byte{}, shared[:secret.KeySize]...), nil
+}
+
+func convert(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) (*ecdh.PrivateKey, *ecdh.PublicKey, error) {
+	if priv == nil {
+		return nil, nil, ErrInvalidPrivateKey
+	} else if pub == nil {
+		return nil, nil, ErrInvalidPoint
 	} else if priv.Curve != pub.Curve {
-		return nil, ErrKeyExchange
-	} else if !priv.Curve.IsOnCurve(pub.X, pub.Y) {
-		return nil, ErrKeyExchange
+		return nil, nil, ErrCurveMismatch
+	}
+
+	ecPriv, err := PrivateKeyFromECDSA(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ecPub, err := PublicKeyFromECDSA(pub)
+	if err != nil {
+		return nil, nil, err
 	}
+	return ecPriv, ecPub, nil
+}
 
-	x, _ := pub.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
-	if x == nil {
-		return nil, ErrKeyExchange
+// ECDH computes a shared key from a private key and a peer's public key.
+// The key is the first secret.KeySize bytes of the SHA-512 hash of the
+// fixed-length shared secret. New code should use DeriveECDH.
+//
+// Earlier versions hashed the shared secret with its leading zero bytes
+// removed, so for the key pairs whose secret starts with a zero byte,
+// about 1 in 256 on P-256 and P-384 and about half on P-521, old and new
+// versions derive different keys. Both sides of an exchange need to be
+// updated together.
+func ECDH(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
+	ecPriv, ecPub, err := convert(priv, pub)
+	if err != nil {
+		return nil, err
 	}
+	return KeyExchange(ecPriv, ecPub)
+}
 
-	shared := sha512.Sum512(x.Bytes())
-	return shared[:secret.KeySize], nil
+// SharedSecret computes the raw ECDH shared secret, the x-coordinate
+// of the shared point. It is left-padded to the size of the curve's
+// field elements so that its length doesn't depend on the value. This
+// should be passed through a KDF before it is used as a key.
+func SharedSecret(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
+	ecPriv, ecPub, err := convert(priv, pub)
+	if err != nil {
+		return nil, err
+	}
+	return RawSharedSecret(ecPriv, ecPub)
 }
 
 // ParseECPublicKey decodes a PKIX-encoded EC public key.
@@ -6,7 +6,10 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha512"
 	"crypto/x509"
+	"errors"
+	"math/big"
 	"testing"
 
 	"git.metacircular.net/kyle/gocrypto/chapter3/aescbc"
@@ -152,3 +155,138 @@ func TestParse(t *testing.T) {
 		t.Fatalf("%v")
 	}
 }
+
+func TestErrors(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	offCurve := priv.PublicKey
+	offCurve.X = new(big.Int).Add(offCurve.X, big.NewInt(1))
+
+	tests := []struct {
+		pub *ecdsa.PublicKey
+		err error
+	}{
+		{nil, ErrInvalidPoint},
+		{&other.PublicKey, ErrCurveMismatch},
+		{&offCurve, ErrInvalidPoint},
+	}
+
+	for _, tc := range tests {
+		_, err = ECDH(priv, tc.pub)
+		if err != tc.err {
+			t.Fatalf("expected %v, have %v", tc.err, err)
+		}
+
+		if !errors.Is(err, ErrKeyExchange) {
+			t.Fatal("key errors should match ErrKeyExchange")
+		}
+	}
+
+	// The identity point can't be encoded as an uncompressed point.
+	if _, err = ParsePublicKey(P256, []byte{0}); err != ErrInvalidPoint {
+		t.Fatal("expected the point at infinity to fail")
+	}
+
+	if _, err = GenerateKey(Curve(0)); err != ErrUnsupportedCurve {
+		t.Fatal("expected an unsupported curve to fail")
+	}
+}
+
+func TestX25519(t *testing.T) {
+	alice, err := GenerateKey(X25519)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, err := GenerateKey(X25519)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ab, err := KeyExchange(alice, bob.PublicKey())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ba, err := KeyExchange(bob, alice.PublicKey())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(ab, ba) || len(ab) != secret.KeySize {
+		t.Fatal("key exchange failed")
+	}
+
+	// The all-zero point has order 1, and 1 has order 4.
+	for _, b := range []byte{0, 1} {
+		point := make([]byte, 32)
+		point[0] = b
+
+		pub, err := ParsePublicKey(X25519, point)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if _, err = KeyExchange(alice, pub); err != ErrLowOrderPoint {
+			t.Fatal("expected a low-order point to fail")
+		}
+	}
+
+	p256, err := GenerateKey(P256)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = KeyExchange(alice, p256.PublicKey()); err != ErrCurveMismatch {
+		t.Fatal("expected keys on different curves to fail")
+	}
+}
+
+// TestFixedLength checks that shared secrets with a leading zero byte
+// keep their full length, and that ECDH hashes the full secret.
+func TestFixedLength(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for i := 0; i < 4096; i++ {
+		peer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		z, err := SharedSecret(priv, &peer.PublicKey)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if len(z) != 32 {
+			t.Fatal("shared secret has the wrong length")
+		}
+
+		if z[0] != 0 {
+			continue
+		}
+
+		shared, err := ECDH(priv, &peer.PublicKey)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		h := sha512.Sum512(z)
+		if !bytes.Equal(shared, h[:secret.KeySize]) {
+			t.Fatal("ECDH didn't hash the full shared secret")
+		}
+		return
+	}
+	t.Fatal("no shared secret with a leading zero byte was found")
+}
@@ -1,11 +1,29 @@
+// Package passcrypt encrypts messages with keys derived from
+// passphrases.
+//
+// Messages start with a header that records the key derivation
+// function, scrypt or Argon2id, along with its parameters and the
+// salt. The header is authenticated as additional data for the
+// XChaCha20-Poly1305 encryption that follows it, so the parameters
+// can't be changed without detection. Decrypt uses the parameters in
+// the header, so the cost for new messages can be changed without
+// breaking existing ones; NeedsUpgrade and Reencrypt move existing
+// messages to new parameters.
+//
+// Messages from earlier versions of this package, which had no header
+// and used NaCl secretbox with fixed scrypt parameters, can still be
+// decrypted.
 package passcrypt
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
-	"log"
 
 	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
 	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/crypto/scrypt"
 )
@@ -18,64 +36,343 @@ var (
 
 	// ErrDecrypt is returned when decryption fails.
 	ErrDecrypt = errors.New("secret: decryption failed")
+
+	// ErrParams is returned when key derivation parameters are
+	// invalid or outside the limits this package accepts.
+	ErrParams = errors.New("passcrypt: invalid key derivation parameters")
 )
 
-// deriveKey generates a new NaCl key from a passphrase and salt.
-func deriveKey(pass, salt []byte) (*[secret.KeySize]byte, error) {
-	var naclKey = new([secret.KeySize]byte)
-	key, err := scrypt.Key(pass, salt, 1048576, 8, 1, secret.KeySize)
-	if err != nil {
+// A KDF identifies a password-based key derivation function.
+type KDF byte
+
+// The supported KDFs.
+const (
+	Scrypt   KDF = 1
+	Argon2id KDF = 2
+)
+
+// Params are the key derivation parameters recorded in a message's
+// header.
+type Params struct {
+	KDF KDF
+
+	// WorkFactor, R and P are the scrypt parameters; WorkFactor is
+	// the base-2 logarithm of N.
+	WorkFactor int
+	R, P       int
+
+	// Time, Memory (in KiB) and Threads are the Argon2id
+	// parameters.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var (
+	// DefaultParams are used for new messages: Argon2id with the
+	// second recommended option from RFC 9106.
+	DefaultParams = &Params{KDF: Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// LegacyParams are the scrypt parameters used for messages
+	// without a header.
+	LegacyParams = &Params{KDF: Scrypt, WorkFactor: 20, R: 8, P: 1}
+)
+
+// MaxMemory is the most memory, in bytes, that parameters may ask the
+// KDF to use: 128 * R * N bytes for scrypt, or Memory KiB for Argon2id.
+// LegacyParams are exactly at the limit.
+const MaxMemory = 1 << 30
+
+// Limits on the other parameters, so that a message can't force a very
+// long computation.
+const (
+	maxWorkFactor = 22
+	maxScryptRP   = 64
+	maxTime       = 64
+	maxThreads    = 64
+)
+
+// Check verifies that the parameters are valid and within the limits
+// this package accepts, returning ErrParams if they aren't.
+func (p *Params) Check() error {
+	switch p.KDF {
+	case Scrypt:
+		if p.WorkFactor < 1 || p.WorkFactor > maxWorkFactor {
+			return ErrParams
+		} else if p.R < 1 || p.R > maxScryptRP || p.P < 1 || p.P > maxScryptRP {
+			return ErrParams
+		} else if 128*uint64(p.R)<<uint(p.WorkFactor) > MaxMemory {
+			return ErrParams
+		}
+	case Argon2id:
+		if p.Time < 1 || p.Time > maxTime || p.Threads < 1 || p.Threads > maxThreads {
+			return ErrParams
+		} else if p.Memory < 8*uint32(p.Threads) || uint64(p.Memory)*1024 > MaxMemory {
+			return ErrParams
+		}
+	default:
+		return ErrParams
+	}
+	return nil
+}
+
+// Equal reports whether the parameters are the same. Only the
+// parameters for the KDF are compared.
+func (p *Params) Equal(other *Params) bool {
+	if p.KDF != other.KDF {
+		return false
+	}
+
+	if p.KDF == Scrypt {
+		return p.WorkFactor == other.WorkFactor && p.R == other.R && p.P == other.P
+	}
+	return p.Time == other.Time && p.Memory == other.Memory && p.Threads == other.Threads
+}
+
+// deriveKey derives a key of the given size from a passphrase and salt.
+func (p *Params) deriveKey(pass, salt []byte, size int) ([]byte, error) {
+	if err := p.Check(); err != nil {
 		return nil, err
 	}
 
-	copy(naclKey[:], key)
-	util.Zero(key)
-	return naclKey, nil
+	if p.KDF == Argon2id {
+		return argon2.IDKey(pass, salt, p.Time, p.Memory, p.Threads, uint32(size)), nil
+	}
+	return scrypt.Key(pass, salt, 1<<uint(p.WorkFactor), p.R, p.P, size)
 }
 
-// Encrypt secures a message using a passphrase.
+// The header is the magic string, a version byte, the KDF, nine bytes
+// of parameters, and the salt.
+var magic = []byte("pcry")
+
+const (
+	version    = 1
+	paramsSize = 9
+	headerSize = 4 + 1 + 1 + paramsSize + SaltSize
+)
+
+// Overhead is the length of additional data that will be added to the
+// message.
+const Overhead = headerSize + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+// LegacyOverhead is the overhead for messages without a header.
+const LegacyOverhead = SaltSize + secretbox.Overhead + secret.NonceSize
+
+// marshalHeader encodes the header. Scrypt's parameters are the work
+// factor, R and P; Argon2id's are the time, memory and threads.
+func marshalHeader(p *Params, salt []byte) []byte {
+	hdr := make([]byte, 0, headerSize)
+	hdr = append(hdr, magic...)
+	hdr = append(hdr, version, byte(p.KDF))
+	if p.KDF == Scrypt {
+		hdr = append(hdr, byte(p.WorkFactor))
+		hdr = binary.BigEndian.AppendUint32(hdr, uint32(p.R))
+		hdr = binary.BigEndian.AppendUint32(hdr, uint32(p.P))
+	} else {
+		hdr = binary.BigEndian.AppendUint32(hdr, p.Time)
+		hdr = binary.BigEndian.AppendUint32(hdr, p.Memory)
+		hdr = append(hdr, p.Threads)
+	}
+	return append(hdr, salt...)
+}
+
+// isVersioned reports whether the message starts with the magic
+// string and version. A message without a header starts with its
+// random salt, so there is a 2^-40 chance that an old message looks
+// like a new one; Decrypt falls back to the old format if the header
+// doesn't parse or the message doesn't decrypt under it.
+func isVersioned(message []byte) bool {
+	return len(message) > len(magic) && bytes.HasPrefix(message, magic) &&
+		message[len(magic)] == version
+}
+
+// parseHeader decodes the header at the start of a versioned message,
+// returning the parameters and salt.
+func parseHeader(message []byte) (*Params, []byte, error) {
+	if len(message) < headerSize {
+		return nil, nil, ErrDecrypt
+	}
+
+	p := &Params{KDF: KDF(message[5])}
+	params := message[6 : 6+paramsSize]
+	switch p.KDF {
+	case Scrypt:
+		p.WorkFactor = int(params[0])
+		p.R = int(binary.BigEndian.Uint32(params[1:]))
+		p.P = int(binary.BigEndian.Uint32(params[5:]))
+	case Argon2id:
+		p.Time = binary.BigEndian.Uint32(params)
+		p.Memory = binary.BigEndian.Uint32(params[4:])
+		p.Threads = params[8]
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, nil, err
+	}
+	return p, message[6+paramsSize : headerSize], nil
+}
+
+// ParseParams returns the key derivation parameters used for a
+// message. Messages without a header return LegacyParams.
+func ParseParams(message []byte) (*Params, error) {
+	if isVersioned(message) {
+		p, _, err := parseHeader(message)
+		return p, err
+	}
+
+	if len(message) < LegacyOverhead {
+		return nil, ErrDecrypt
+	}
+	p := *LegacyParams
+	return &p, nil
+}
+
+// Encrypt secures a message using a passphrase and the default
+// parameters.
 func Encrypt(pass, message []byte) ([]byte, error) {
+	return EncryptWithParams(pass, message, DefaultParams)
+}
+
+// EncryptWithParams secures a message using a passphrase, deriving the
+// key with the given parameters.
+func EncryptWithParams(pass, message []byte, p *Params) ([]byte, error) {
+	if p == nil {
+		p = DefaultParams
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
 	salt, err := util.RandBytes(SaltSize)
 	if err != nil {
 		return nil, ErrEncrypt
 	}
 
-	key, err := deriveKey(pass, salt)
+	key, err := p.deriveKey(pass, salt, chacha20poly1305.KeySize)
 	if err != nil {
 		return nil, ErrEncrypt
 	}
+	defer util.Zero(key)
 
-	out, err := secret.Encrypt(key, message)
-	util.Zero(key[:]) // Zero key immediately after
+	aead, err := chacha20poly1305.NewX(key)
 	if err != nil {
 		return nil, ErrEncrypt
 	}
 
-	out = append(salt, out...)
-	return out, nil
-}
+	nonce, err := util.RandBytes(aead.NonceSize())
+	if err != nil {
+		return nil, ErrEncrypt
+	}
 
-const Overhead = SaltSize + secretbox.Overhead + secret.NonceSize
+	hdr := marshalHeader(p, salt)
+	out := append(hdr, nonce...)
+	return aead.Seal(out, nonce, message, hdr), nil
+}
 
-// Decrypt recovers a message encrypted using a passphrase.
+// Decrypt recovers a message encrypted using a passphrase, using the
+// parameters recorded in its header. A message that has no header, or
+// doesn't decrypt under its header, is tried as a message from an
+// earlier version, so a failed decryption also costs a key derivation
+// with LegacyParams.
 func Decrypt(pass, message []byte) ([]byte, error) {
+	if isVersioned(message) {
+		if out, err := decryptVersioned(pass, message); err == nil {
+			return out, nil
+		}
+	}
+
+	// An old message whose salt happens to look like a header is
+	// still readable.
+	return decryptLegacy(pass, message)
+}
+
+// decryptVersioned decrypts a message with a header.
+func decryptVersioned(pass, message []byte) ([]byte, error) {
 	if len(message) < Overhead {
-		log.Print("length")
 		return nil, ErrDecrypt
 	}
 
-	key, err := deriveKey(pass, message[:SaltSize])
+	p, salt, err := parseHeader(message)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	key, err := p.deriveKey(pass, salt, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	defer util.Zero(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	hdr := message[:headerSize]
+	nonce := message[headerSize : headerSize+aead.NonceSize()]
+	out, err := aead.Open(nil, nonce, message[headerSize+aead.NonceSize():], hdr)
 	if err != nil {
-		log.Print("scrypt")
 		return nil, ErrDecrypt
 	}
+	return out, nil
+}
 
-	out, err := secret.Decrypt(key, message[SaltSize:])
-	util.Zero(key[:]) // Zero key immediately after
+// decryptLegacy decrypts a message without a header: the salt followed
+// by a NaCl secretbox.
+func decryptLegacy(pass, message []byte) ([]byte, error) {
+	if len(message) < LegacyOverhead {
+		return nil, ErrDecrypt
+	}
+
+	key, err := LegacyParams.deriveKey(pass, message[:SaltSize], secret.KeySize)
 	if err != nil {
-		log.Printf("decrypt")
 		return nil, ErrDecrypt
 	}
+	defer util.Zero(key)
 
+	var naclKey [secret.KeySize]byte
+	copy(naclKey[:], key)
+	out, err := secret.Decrypt(&naclKey, message[SaltSize:])
+	util.Zero(naclKey[:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
 	return out, nil
 }
+
+// NeedsUpgrade returns true if the message wasn't encrypted with the
+// given parameters, or the default parameters if p is nil. This
+// includes all messages without a header.
+func NeedsUpgrade(message []byte, p *Params) bool {
+	if p == nil {
+		p = DefaultParams
+	}
+
+	if !isVersioned(message) {
+		return true
+	}
+
+	current, _, err := parseHeader(message)
+	return err != nil || !current.Equal(p)
+}
+
+// Reencrypt decrypts a message and encrypts it again with the given
+// parameters, or the default parameters if p is nil, and a new salt.
+func Reencrypt(pass, message []byte, p *Params) ([]byte, error) {
+	if p == nil {
+		p = DefaultParams
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
+	pt, err := Decrypt(pass, message)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Zero(pt)
+
+	return EncryptWithParams(pass, pt, p)
+}
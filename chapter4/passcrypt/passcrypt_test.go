@@ -2,7 +2,10 @@ package passcrypt
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
 )
 
 var (
@@ -11,6 +14,25 @@ var (
 	testPassword2 = []byte("correct horse battery staple")
 )
 
+// Cheap parameters keep the tests fast.
+var (
+	testScrypt   = &Params{KDF: Scrypt, WorkFactor: 10, R: 8, P: 1}
+	testArgon2id = &Params{KDF: Argon2id, Time: 1, Memory: 1024, Threads: 1}
+)
+
+// legacyMessage was encrypted with testPassword1 by the version of
+// this package that wrote messages without a header.
+const legacyMessage = "ff105c762c22765b6de61a5c3813a79adb33158120dd0314f7250190459958882b7e6ba557336f853d9b0a8f45b9a8a57b90f43844be05efbf55757eba42ee02ea3c1a1d4597c28ffa58460a49a6553c931057da183f63d0ddec5aa17f3bb20248f7a29e69b6922b319fff13aa"
+
+// cheapLegacy swaps in cheap legacy parameters, so that failed
+// decryptions, which fall back to the legacy format, stay fast. It
+// returns a function that restores them.
+func cheapLegacy() func() {
+	saved := LegacyParams
+	LegacyParams = testScrypt
+	return func() { LegacyParams = saved }
+}
+
 func TestEncryptCycle(t *testing.T) {
 	out, err := Encrypt(testPassword1, testMessage)
 	if err != nil {
@@ -26,3 +48,220 @@ func TestEncryptCycle(t *testing.T) {
 		t.Fatal("recovered plaintext doesn't match original")
 	}
 }
+
+func TestParams(t *testing.T) {
+	defer cheapLegacy()()
+
+	for _, p := range []*Params{testScrypt, testArgon2id} {
+		out, err := EncryptWithParams(testPassword1, testMessage, p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if len(out) != len(testMessage)+Overhead {
+			t.Fatalf("expected %d bytes, have %d", len(testMessage)+Overhead, len(out))
+		}
+
+		parsed, err := ParseParams(out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !parsed.Equal(p) {
+			t.Fatalf("have params %+v, want %+v", parsed, p)
+		}
+
+		pt, err := Decrypt(testPassword2, out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(testMessage, pt) {
+			t.Fatal("recovered plaintext doesn't match original")
+		}
+
+		if _, err = Decrypt([]byte("wrong"), out); err != ErrDecrypt {
+			t.Fatalf("expected ErrDecrypt with the wrong password, have %v", err)
+		}
+	}
+}
+
+func TestHeaderTampering(t *testing.T) {
+	defer cheapLegacy()()
+
+	out, err := EncryptWithParams(testPassword1, testMessage, testArgon2id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Changing the magic string or version would make this a legacy
+	// message, which fails to decrypt as well but takes much longer.
+	for i := len(magic) + 1; i < headerSize; i++ {
+		tampered := append([]byte{}, out...)
+		tampered[i] ^= 1
+		if _, err = Decrypt(testPassword1, tampered); err == nil {
+			t.Fatalf("tampered header byte %d should fail to decrypt", i)
+		}
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	defer cheapLegacy()()
+
+	bad := []*Params{
+		{},
+		{KDF: Scrypt, WorkFactor: 30, R: 8, P: 1},
+		{KDF: Scrypt, WorkFactor: 10},
+		{KDF: Argon2id, Time: 1, Memory: 1024},
+		{KDF: Argon2id, Time: 1, Memory: 1 << 30, Threads: 1},
+	}
+
+	for i, p := range bad {
+		if _, err := EncryptWithParams(testPassword1, testMessage, p); err != ErrParams {
+			t.Fatalf("case %d: expected ErrParams, have %v", i, err)
+		}
+	}
+
+	// A header asking for too much memory is rejected before any key
+	// derivation.
+	out, err := EncryptWithParams(testPassword1, testMessage, testArgon2id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out[10] = 0xff
+
+	if _, err = ParseParams(out); err != ErrParams {
+		t.Fatalf("expected ErrParams, have %v", err)
+	}
+
+	if _, err = Decrypt(testPassword1, out); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt, have %v", err)
+	}
+}
+
+func TestLegacyUpgrade(t *testing.T) {
+	legacy, err := hex.DecodeString(legacyMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p, err := ParseParams(legacy)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !p.Equal(LegacyParams) {
+		t.Fatalf("expected legacy params, have %+v", p)
+	}
+
+	if !NeedsUpgrade(legacy, testArgon2id) {
+		t.Fatal("legacy messages should need upgrading")
+	}
+
+	out, err := Reencrypt(testPassword1, legacy, testArgon2id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if NeedsUpgrade(out, testArgon2id) {
+		t.Fatal("upgraded message shouldn't need upgrading")
+	}
+
+	if !NeedsUpgrade(out, testScrypt) || !NeedsUpgrade(out, nil) {
+		t.Fatal("message should need upgrading to other params")
+	}
+
+	pt, err := Decrypt(testPassword1, out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(testMessage, pt) {
+		t.Fatal("recovered plaintext doesn't match original")
+	}
+
+	if _, err = Reencrypt([]byte("wrong"), out, testScrypt); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt, have %v", err)
+	}
+}
+
+// TestLegacyLookalike checks a message without a header whose random
+// salt happens to start with a valid header.
+func TestLegacyLookalike(t *testing.T) {
+	defer cheapLegacy()()
+
+	salt := marshalHeader(testScrypt, nil)
+	salt = append(salt, make([]byte, SaltSize-len(salt))...)
+
+	key, err := LegacyParams.deriveKey(testPassword1, salt, secret.KeySize)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var naclKey [secret.KeySize]byte
+	copy(naclKey[:], key)
+	box, err := secret.Encrypt(&naclKey, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	legacy := append(salt, box...)
+
+	if !isVersioned(legacy) {
+		t.Fatal("message should look like it has a header")
+	}
+
+	pt, err := Decrypt(testPassword1, legacy)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(testMessage, pt) {
+		t.Fatal("recovered plaintext doesn't match original")
+	}
+
+	out, err := Reencrypt(testPassword1, legacy, testArgon2id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if NeedsUpgrade(out, testArgon2id) {
+		t.Fatal("upgraded message shouldn't need upgrading")
+	}
+}
+
+// TestMemoryLimit checks headers on either side of the memory limit.
+// Only the headers are parsed, so no key is ever derived.
+func TestMemoryLimit(t *testing.T) {
+	cases := []struct {
+		p  *Params
+		ok bool
+	}{
+		{LegacyParams, true},
+		{&Params{KDF: Scrypt, WorkFactor: 21, R: 4, P: 1}, true},
+		{&Params{KDF: Scrypt, WorkFactor: 20, R: 9, P: 1}, false},
+		{&Params{KDF: Scrypt, WorkFactor: 22, R: 64, P: 1}, false},
+		{&Params{KDF: Argon2id, Time: 1, Memory: 1 << 20, Threads: 4}, true},
+		{&Params{KDF: Argon2id, Time: 1, Memory: 1<<20 + 1, Threads: 4}, false},
+		{&Params{KDF: Argon2id, Time: 1, Memory: 1 << 20, Threads: 255}, false},
+	}
+	defer cheapLegacy()()
+
+	salt := make([]byte, SaltSize)
+	for i, c := range cases {
+		msg := marshalHeader(c.p, salt)
+		msg = append(msg, make([]byte, Overhead-headerSize)...)
+
+		_, err := ParseParams(msg)
+		if c.ok && err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		} else if !c.ok && err != ErrParams {
+			t.Fatalf("case %d: expected ErrParams, have %v", i, err)
+		}
+
+		if !c.ok {
+			if _, err = Decrypt(testPassword1, msg); err != ErrDecrypt {
+				t.Fatalf("case %d: expected ErrDecrypt, have %v", i, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,407 @@
+// Package passhash hashes passwords for storage and verifies them.
+//
+// Hashes are strings in the PHC string format, using Argon2id or
+// scrypt:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//	$scrypt$ln=16,r=8,p=1$<salt>$<hash>
+//
+// The salt and hash are base64-encoded without padding. The parameters
+// are recorded in the string, so a Policy can be changed without
+// breaking existing hashes; NeedsRehash reports which hashes should be
+// replaced the next time the password is available.
+//
+// To help migrate from other systems, Verify also accepts bcrypt hashes
+// ($2a$, $2b$ and $2y$, with a cost of at most MaxBcryptCost) and
+// PBKDF2-SHA256 hashes in the passlib format
+// ($pbkdf2-sha256$<rounds>$<salt>$<hash>). New hashes are never written
+// in these formats.
+package passhash
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/passcrypt"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrMismatch is returned when a password doesn't match a hash.
+	ErrMismatch = errors.New("passhash: password doesn't match")
+
+	// ErrFormat is returned when a hash can't be parsed, or its
+	// parameters are outside the limits this package accepts.
+	ErrFormat = errors.New("passhash: invalid hash format")
+
+	// ErrPolicy is returned when a policy is invalid.
+	ErrPolicy = errors.New("passhash: invalid policy")
+)
+
+// An Algorithm identifies a password hashing function.
+type Algorithm int
+
+// The algorithms used for new hashes. Bcrypt and PBKDF2 are only
+// verified.
+const (
+	Argon2id Algorithm = iota + 1
+	Scrypt
+	Bcrypt
+	PBKDF2SHA256
+)
+
+// A Policy chooses the algorithm and parameters for new hashes.
+type Policy struct {
+	Algorithm Algorithm
+
+	// Time, Memory (in KiB) and Threads are the Argon2id
+	// parameters.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+
+	// WorkFactor, R and P are the scrypt parameters; WorkFactor is
+	// the base-2 logarithm of N.
+	WorkFactor int
+	R, P       int
+
+	// SaltSize and KeySize are the sizes of the salt and the hash.
+	SaltSize int
+	KeySize  int
+}
+
+// DefaultPolicy uses Argon2id with the second recommended option from
+// RFC 9106, a 16-byte salt and a 32-byte hash.
+var DefaultPolicy = &Policy{
+	Algorithm: Argon2id,
+	Time:      3,
+	Memory:    64 * 1024,
+	Threads:   4,
+	SaltSize:  16,
+	KeySize:   32,
+}
+
+// Limits on the salt and hash sizes, the PBKDF2 rounds and the bcrypt
+// cost. The Argon2id and scrypt parameters are held to passcrypt's
+// limits, so that a stored hash can't force a very long or
+// memory-hungry computation.
+const (
+	minSaltSize = 8
+	maxSaltSize = 64
+	minKeySize  = 16
+	maxKeySize  = 64
+	maxRounds   = 10000000
+
+	// MaxBcryptCost is the highest bcrypt cost Verify accepts; each
+	// step doubles the work, and 16 already takes several seconds.
+	MaxBcryptCost = 16
+)
+
+// params returns the policy's parameters in passcrypt's form.
+func (p *Policy) params() *passcrypt.Params {
+	params := &passcrypt.Params{
+		WorkFactor: p.WorkFactor,
+		R:          p.R,
+		P:          p.P,
+		Time:       p.Time,
+		Memory:     p.Memory,
+		Threads:    p.Threads,
+	}
+
+	switch p.Algorithm {
+	case Argon2id:
+		params.KDF = passcrypt.Argon2id
+	case Scrypt:
+		params.KDF = passcrypt.Scrypt
+	}
+	return params
+}
+
+// check verifies that the policy is valid and within limits.
+func (p *Policy) check() error {
+	if p.SaltSize < minSaltSize || p.SaltSize > maxSaltSize {
+		return ErrPolicy
+	} else if p.KeySize < minKeySize || p.KeySize > maxKeySize {
+		return ErrPolicy
+	} else if p.params().Check() != nil {
+		return ErrPolicy
+	}
+	return nil
+}
+
+// A hash is a parsed hash string. For PBKDF2, Time holds the number of
+// rounds.
+type hash struct {
+	policy Policy
+	salt   []byte
+	key    []byte
+}
+
+// derive computes the hash of a password with the parameters and salt
+// in h.
+func (h *hash) derive(password []byte) ([]byte, error) {
+	p := &h.policy
+	switch p.Algorithm {
+	case Argon2id:
+		return argon2.IDKey(password, h.salt, p.Time, p.Memory, p.Threads, uint32(p.KeySize)), nil
+	case Scrypt:
+		return scrypt.Key(password, h.salt, 1<<uint(p.WorkFactor), p.R, p.P, p.KeySize)
+	case PBKDF2SHA256:
+		return pbkdf2.Key(password, h.salt, int(p.Time), p.KeySize, sha256.New), nil
+	}
+	return nil, ErrFormat
+}
+
+// String encodes the hash in the PHC string format.
+func (h *hash) String() string {
+	p := &h.policy
+	var params string
+	if p.Algorithm == Argon2id {
+		params = fmt.Sprintf("argon2id$v=%d$m=%d,t=%d,p=%d", argon2.Version, p.Memory, p.Time, p.Threads)
+	} else {
+		params = fmt.Sprintf("scrypt$ln=%d,r=%d,p=%d", p.WorkFactor, p.R, p.P)
+	}
+
+	return "$" + params + "$" + base64.RawStdEncoding.EncodeToString(h.salt) +
+		"$" + base64.RawStdEncoding.EncodeToString(h.key)
+}
+
+// Hash hashes a password with the given policy, or the default policy
+// if policy is nil, and a random salt.
+func Hash(password []byte, policy *Policy) (string, error) {
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+
+	if err := policy.check(); err != nil {
+		return "", err
+	}
+
+	salt, err := util.RandBytes(policy.SaltSize)
+	if err != nil {
+		return "", err
+	}
+
+	h := &hash{policy: *policy, salt: salt}
+	h.key, err = h.derive(password)
+	if err != nil {
+		return "", err
+	}
+	defer util.Zero(h.key)
+
+	return h.String(), nil
+}
+
+// Verify checks a password against a hash, returning ErrMismatch if
+// it doesn't match. The comparison is done in constant time.
+func Verify(password []byte, encoded string) error {
+	if isBcrypt(encoded) {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil || cost > MaxBcryptCost {
+			return ErrFormat
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), password)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrMismatch
+		} else if err != nil {
+			return ErrFormat
+		}
+		return nil
+	}
+
+	h, err := parse(encoded)
+	if err != nil {
+		return err
+	}
+
+	key, err := h.derive(password)
+	if err != nil {
+		return ErrFormat
+	}
+	defer util.Zero(key)
+
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash returns true if the hash wasn't made with the given
+// policy, or the default policy if policy is nil. This includes all
+// bcrypt and PBKDF2 hashes, and any hash that can't be parsed.
+func NeedsRehash(encoded string, policy *Policy) bool {
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+
+	if isBcrypt(encoded) {
+		return true
+	}
+
+	h, err := parse(encoded)
+	if err != nil {
+		return true
+	}
+
+	p := &h.policy
+	if p.Algorithm != policy.Algorithm || len(h.salt) < policy.SaltSize || p.KeySize < policy.KeySize {
+		return true
+	}
+
+	switch p.Algorithm {
+	case Argon2id:
+		return p.Time != policy.Time || p.Memory != policy.Memory || p.Threads != policy.Threads
+	case Scrypt:
+		return p.WorkFactor != policy.WorkFactor || p.R != policy.R || p.P != policy.P
+	}
+	return true
+}
+
+// isBcrypt reports whether the hash looks like a bcrypt hash.
+func isBcrypt(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse decodes a PHC or passlib PBKDF2 hash string and checks its
+// parameters against the limits.
+func parse(encoded string) (*hash, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 5 || fields[0] != "" {
+		return nil, ErrFormat
+	}
+
+	var (
+		h   = &hash{}
+		err error
+	)
+	switch fields[1] {
+	case "argon2id":
+		h.policy.Algorithm = Argon2id
+		if len(fields) != 6 || fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return nil, ErrFormat
+		}
+		err = parseArgon2Params(&h.policy, fields[3])
+	case "scrypt":
+		h.policy.Algorithm = Scrypt
+		if len(fields) != 5 {
+			return nil, ErrFormat
+		}
+		err = parseScryptParams(&h.policy, fields[2])
+	case "pbkdf2-sha256":
+		h.policy.Algorithm = PBKDF2SHA256
+		if len(fields) != 5 {
+			return nil, ErrFormat
+		}
+		return parsePBKDF2(h, fields[2:])
+	default:
+		return nil, ErrFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	salt, key := fields[len(fields)-2], fields[len(fields)-1]
+	if h.salt, err = base64.RawStdEncoding.Strict().DecodeString(salt); err != nil {
+		return nil, ErrFormat
+	}
+
+	if h.key, err = base64.RawStdEncoding.Strict().DecodeString(key); err != nil {
+		return nil, ErrFormat
+	}
+	h.policy.SaltSize, h.policy.KeySize = len(h.salt), len(h.key)
+
+	if err = h.policy.check(); err != nil {
+		return nil, ErrFormat
+	}
+	return h, nil
+}
+
+// parseParams splits a PHC parameter list into its values, requiring
+// exactly the given names in order.
+func parseParams(in string, names ...string) ([]uint64, error) {
+	params := strings.Split(in, ",")
+	if len(params) != len(names) {
+		return nil, ErrFormat
+	}
+
+	values := make([]uint64, len(names))
+	for i, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name != names[i] {
+			return nil, ErrFormat
+		}
+
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, ErrFormat
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parseArgon2Params(p *Policy, in string) error {
+	values, err := parseParams(in, "m", "t", "p")
+	if err != nil {
+		return err
+	} else if values[2] > 255 {
+		return ErrFormat
+	}
+
+	p.Memory, p.Time, p.Threads = uint32(values[0]), uint32(values[1]), uint8(values[2])
+	return nil
+}
+
+func parseScryptParams(p *Policy, in string) error {
+	values, err := parseParams(in, "ln", "r", "p")
+	if err != nil {
+		return err
+	}
+
+	p.WorkFactor, p.R, p.P = int(values[0]), int(values[1]), int(values[2])
+	return nil
+}
+
+// passlibEncoding is passlib's adapted base64, which uses '.' in place
+// of '+'.
+var passlibEncoding = base64.NewEncoding(
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./",
+).WithPadding(base64.NoPadding).Strict()
+
+// parsePBKDF2 decodes the rounds, salt and hash of a passlib
+// pbkdf2-sha256 hash.
+func parsePBKDF2(h *hash, fields []string) (*hash, error) {
+	rounds, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil || rounds < 1 || rounds > maxRounds {
+		return nil, ErrFormat
+	}
+	h.policy.Time = uint32(rounds)
+
+	if h.salt, err = passlibEncoding.DecodeString(fields[1]); err != nil || len(h.salt) == 0 {
+		return nil, ErrFormat
+	}
+
+	if h.key, err = passlibEncoding.DecodeString(fields[2]); err != nil {
+		return nil, ErrFormat
+	} else if len(h.key) < minKeySize || len(h.key) > maxKeySize {
+		return nil, ErrFormat
+	}
+
+	h.policy.SaltSize, h.policy.KeySize = len(h.salt), len(h.key)
+	return h, nil
+}
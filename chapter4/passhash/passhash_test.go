@@ -0,0 +1,174 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testPassword = []byte("correct horse battery staple")
+
+// Cheap parameters keep the tests fast.
+var (
+	testArgon2id = &Policy{Algorithm: Argon2id, Time: 1, Memory: 1024, Threads: 1, SaltSize: 16, KeySize: 32}
+	testScrypt   = &Policy{Algorithm: Scrypt, WorkFactor: 10, R: 8, P: 1, SaltSize: 16, KeySize: 32}
+)
+
+// The Argon2id hash was made by libsodium's crypto_pwhash_str, and the
+// others from Python's hashlib.
+var vectors = []string{
+	"$argon2id$v=19$m=1024,t=2,p=1$h6YE17PZ7Doy1lYWoO0XLA$pz+EOIbN9tSFO4gWGOiJrHp4fQaE5hPdLSx1M0Jjsw0",
+	"$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$7AnzIswxwEinpwz+ntydndYfVHPvOACmX9Vvo8hO1qM",
+	"$pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$yqSq2SygY1sB4EcH9f2FG0JTMES.wqLsOT5YmiRBplI",
+}
+
+func TestHashCycle(t *testing.T) {
+	for _, policy := range []*Policy{testArgon2id, testScrypt} {
+		h, err := Hash(testPassword, policy)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if err = Verify(testPassword, h); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if err = Verify([]byte("wrong"), h); err != ErrMismatch {
+			t.Fatalf("expected ErrMismatch, have %v", err)
+		}
+
+		other, err := Hash(testPassword, policy)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if h == other {
+			t.Fatal("hashes of the same password should use different salts")
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	for _, h := range vectors {
+		if err := Verify(testPassword, h); err != nil {
+			t.Fatalf("%s: %v", h, err)
+		}
+
+		if err := Verify([]byte("wrong"), h); err != ErrMismatch {
+			t.Fatalf("%s: expected ErrMismatch, have %v", h, err)
+		}
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	encoded, err := bcrypt.GenerateFromPassword(testPassword, bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		h := prefix + string(encoded[4:])
+		if err = Verify(testPassword, h); err != nil {
+			t.Fatalf("%s: %v", prefix, err)
+		}
+
+		if err = Verify([]byte("wrong"), h); err != ErrMismatch {
+			t.Fatalf("%s: expected ErrMismatch, have %v", prefix, err)
+		}
+	}
+
+	if err = Verify(testPassword, "$2b$04$short"); err != ErrFormat {
+		t.Fatalf("expected ErrFormat, have %v", err)
+	}
+
+	// A stored hash mustn't be able to force hours of work.
+	h := "$2b$31$" + string(encoded[7:])
+	if err = Verify(testPassword, h); err != ErrFormat {
+		t.Fatalf("expected ErrFormat for cost 31, have %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	h, err := Hash(testPassword, testArgon2id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if NeedsRehash(h, testArgon2id) {
+		t.Fatal("hash shouldn't need rehashing under its own policy")
+	}
+
+	stronger := *testArgon2id
+	stronger.Time++
+	longer := *testArgon2id
+	longer.KeySize = 64
+	for _, policy := range []*Policy{nil, testScrypt, &stronger, &longer} {
+		if !NeedsRehash(h, policy) {
+			t.Fatalf("hash should need rehashing under %+v", policy)
+		}
+	}
+
+	h, err = Hash(testPassword, testScrypt)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if NeedsRehash(h, testScrypt) {
+		t.Fatal("hash shouldn't need rehashing under its own policy")
+	}
+
+	for _, h := range append(vectors[2:], "$2b$04$abc", "garbage") {
+		if !NeedsRehash(h, testScrypt) {
+			t.Fatalf("%s should need rehashing", h)
+		}
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	bad := []*Policy{
+		{},
+		{Algorithm: Bcrypt, SaltSize: 16, KeySize: 32},
+		{Algorithm: Argon2id, Time: 1, Memory: 1024, Threads: 1},
+		{Algorithm: Argon2id, Time: 1, Memory: 1 << 30, Threads: 1, SaltSize: 16, KeySize: 32},
+		{Algorithm: Scrypt, WorkFactor: 30, R: 8, P: 1, SaltSize: 16, KeySize: 32},
+		{Algorithm: Scrypt, WorkFactor: 10, R: 8, P: 1, SaltSize: 4, KeySize: 32},
+	}
+
+	for i, policy := range bad {
+		if _, err := Hash(testPassword, policy); err != ErrPolicy {
+			t.Fatalf("case %d: expected ErrPolicy, have %v", i, err)
+		}
+	}
+}
+
+func TestInvalidHashes(t *testing.T) {
+	argon := vectors[0]
+	bad := []string{
+		"",
+		"argon2id$v=19$m=1024,t=2,p=1$h6YE17PZ7Doy1lYWoO0XLA$pz+EOIbN9tSFO4gWGOiJrHp4fQaE5hPdLSx1M0Jjsw0",
+		strings.Replace(argon, "argon2id", "argon2i", 1),
+		strings.Replace(argon, "v=19", "v=16", 1),
+		strings.Replace(argon, "m=1024,t=2,p=1", "t=2,m=1024,p=1", 1),
+		strings.Replace(argon, "m=1024", "m=4294967295", 1),
+		strings.Replace(argon, "t=2", "t=-2", 1),
+		strings.Replace(argon, "p=1", "p=256", 1),
+		strings.Replace(argon, "h6YE17PZ7Doy1lYWoO0XLA", "h6YE17PZ7Doy1lYWoO0XLA==", 1),
+		argon[:len(argon)-30],
+		strings.Replace(vectors[1], "ln=10", "ln=40", 1),
+		strings.Replace(vectors[1], "r=8", "r=0", 1),
+		strings.Replace(vectors[1], "ln=10,r=8,p=1", "ln=22,r=64,p=64", 1),
+		strings.Replace(vectors[1], "ln=10,r=8", "ln=20,r=9", 1),
+		strings.Replace(argon, "m=1024", "m=1048577", 1),
+		strings.Replace(argon, "m=1024,t=2,p=1", "m=1048576,t=2,p=255", 1),
+		strings.Replace(vectors[2], "1000", "100000000", 1),
+		strings.Replace(vectors[2], "yqSq2SygY1sB4EcH9f2FG0JTMES.", "yqSq2SygY1sB4EcH9f2FG0JTMES+", 1),
+		vectors[2] + "$extra",
+	}
+
+	for i, h := range bad {
+		if err := Verify(testPassword, h); err != ErrFormat {
+			t.Fatalf("case %d: expected ErrFormat, have %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"crypto/mlkem"
+	"crypto/sha256"
+	"io"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The hybrid handshake adds an ML-KEM-768 exchange to the Curve25519
+// one, so that traffic recorded today can't be decrypted by an attacker
+// who later gets a quantum computer. The dialer sends an ML-KEM
+// encapsulation key after its public keys, and the listener answers
+// with a ciphertext after its own. Each link key is then derived from
+// both the Curve25519 key for that link and the ML-KEM shared secret,
+// so the session is secure as long as either exchange is.
+const (
+	// HybridDialSize is the size of the dialer's hybrid handshake
+	// message.
+	HybridDialSize = 64 + mlkem.EncapsulationKeySize768
+
+	// HybridListenSize is the size of the listener's hybrid handshake
+	// message.
+	HybridListenSize = 64 + mlkem.CiphertextSize768
+)
+
+const (
+	hybridInfoAB = "session hybrid A->B"
+	hybridInfoBA = "session hybrid B->A"
+)
+
+// hybridKey replaces a Curve25519 link key with one derived from it and
+// the ML-KEM shared secret.
+func hybridKey(key *[32]byte, pq []byte, info string) {
+	ikm := make([]byte, 0, 32+len(pq))
+	ikm = append(ikm, key[:]...)
+	ikm = append(ikm, pq...)
+	defer util.Zero(ikm)
+
+	io.ReadFull(hkdf.New(sha256.New, ikm, nil, []byte(info)), key[:])
+}
+
+// HybridKeyExchange performs KeyExchange, then mixes the ML-KEM shared
+// secret into both link keys. The dialer argument has the same meaning
+// as for KeyExchange. The shared secret is zeroised.
+func (s *Session) HybridKeyExchange(priv, peer *[64]byte, pq []byte, dialer bool) {
+	s.KeyExchange(priv, peer, dialer)
+	defer util.Zero(pq)
+
+	ab, ba := s.sendKey, s.recvKey
+	if !dialer {
+		ab, ba = s.recvKey, s.sendKey
+	}
+	hybridKey(ab, pq, hybridInfoAB)
+	hybridKey(ba, pq, hybridInfoBA)
+}
+
+// DialHybrid is like Dial, but uses the hybrid Curve25519 and
+// ML-KEM-768 handshake. The peer must use ListenHybrid.
+func DialHybrid(ch Channel) (*Session, error) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ch.Write(append(pub[:], dk.EncapsulationKey().Bytes()...))
+	if err != nil {
+		return nil, err
+	}
+
+	var reply [HybridListenSize]byte
+	_, err = io.ReadFull(ch, reply[:])
+	if err != nil {
+		return nil, err
+	}
+
+	pq, err := dk.Decapsulate(reply[64:])
+	if err != nil {
+		return nil, err
+	}
+
+	var peer [64]byte
+	copy(peer[:], reply[:64])
+
+	s := &Session{
+		recvKey: new([32]byte),
+		sendKey: new([32]byte),
+		Channel: ch,
+	}
+
+	s.HybridKeyExchange(priv, &peer, pq, true)
+	return s, nil
+}
+
+// ListenHybrid waits for a peer to DialHybrid in, then completes the
+// hybrid handshake.
+func ListenHybrid(ch Channel) (*Session, error) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	var hello [HybridDialSize]byte
+	_, err = io.ReadFull(ch, hello[:])
+	if err != nil {
+		return nil, err
+	}
+
+	ek, err := mlkem.NewEncapsulationKey768(hello[64:])
+	if err != nil {
+		return nil, err
+	}
+	pq, ct := ek.Encapsulate()
+
+	_, err = ch.Write(append(pub[:], ct...))
+	if err != nil {
+		return nil, err
+	}
+
+	var peer [64]byte
+	copy(peer[:], hello[:64])
+
+	s := &Session{
+		recvKey: new([32]byte),
+		sendKey: new([32]byte),
+		Channel: ch,
+	}
+
+	s.HybridKeyExchange(priv, &peer, pq, false)
+	return s, nil
+}
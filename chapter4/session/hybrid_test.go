@@ -0,0 +1,102 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestHybridSession(t *testing.T) {
+	dialConn, listenConn := net.Pipe()
+	defer dialConn.Close()
+	defer listenConn.Close()
+
+	type result struct {
+		s   *Session
+		err error
+	}
+	listened := make(chan result, 1)
+	go func() {
+		s, err := ListenHybrid(listenConn)
+		listened <- result{s, err}
+	}()
+
+	alice, err := DialHybrid(dialConn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	r := <-listened
+	if r.err != nil {
+		t.Fatalf("%v", r.err)
+	}
+	bob := r.s
+
+	if *alice.sendKey != *bob.recvKey || *alice.recvKey != *bob.sendKey {
+		t.Fatal("hybrid handshake produced different keys")
+	}
+
+	if *alice.sendKey == *alice.recvKey {
+		t.Fatal("both links have the same key")
+	}
+
+	for _, pair := range [][2]*Session{{alice, bob}, {bob, alice}} {
+		m, err := pair[0].Encrypt(testMessage)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		out, err := pair[1].Decrypt(m)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+	}
+
+	alice.Close()
+	bob.Close()
+}
+
+func TestHybridKeyExchange(t *testing.T) {
+	alicePub, alicePriv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobPub, bobPriv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	newSession := func() *Session {
+		return &Session{recvKey: new([32]byte), sendKey: new([32]byte)}
+	}
+
+	// The hybrid keys must differ from the Curve25519-only keys, and
+	// depend on the ML-KEM shared secret.
+	plain := newSession()
+	plain.KeyExchange(copyKeys(alicePriv), bobPub, true)
+
+	hybrid := newSession()
+	hybrid.HybridKeyExchange(copyKeys(alicePriv), bobPub, bytes.Repeat([]byte{1}, 32), true)
+
+	other := newSession()
+	other.HybridKeyExchange(copyKeys(bobPriv), alicePub, bytes.Repeat([]byte{2}, 32), false)
+
+	if *plain.sendKey == *hybrid.sendKey {
+		t.Fatal("hybrid key exchange didn't change the keys")
+	}
+
+	if *hybrid.sendKey == *other.recvKey {
+		t.Fatal("different ML-KEM secrets produced the same keys")
+	}
+}
+
+// copyKeys copies a private key, as key exchanges zeroise it.
+func copyKeys(priv *[64]byte) *[64]byte {
+	c := *priv
+	return &c
+}
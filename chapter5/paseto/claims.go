@@ -0,0 +1,135 @@
+package paseto
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrExpired is returned when a token's expiration time has
+	// passed.
+	ErrExpired = errors.New("paseto: token has expired")
+
+	// ErrNotYetValid is returned when a token's not-before time
+	// hasn't been reached.
+	ErrNotYetValid = errors.New("paseto: token is not yet valid")
+
+	// ErrIssuedInFuture is returned when a token claims to have been
+	// issued after the current time.
+	ErrIssuedInFuture = errors.New("paseto: token was issued in the future")
+
+	// ErrAudience is returned when a token is intended for a
+	// different audience.
+	ErrAudience = errors.New("paseto: token is for a different audience")
+
+	// ErrInvalidClaims is returned when the claims can't be parsed.
+	ErrInvalidClaims = errors.New("paseto: invalid claims")
+)
+
+// Claims contains the registered claims from the PASETO specification.
+// Times are encoded as RFC 3339 strings; zero times and empty strings
+// are left out of the encoded claims.
+type Claims struct {
+	Issuer     string
+	Subject    string
+	Audience   string
+	Expiration time.Time
+	NotBefore  time.Time
+	IssuedAt   time.Time
+	TokenID    string
+}
+
+// wireClaims is the JSON form of Claims.
+type wireClaims struct {
+	Issuer     string `json:"iss,omitempty"`
+	Subject    string `json:"sub,omitempty"`
+	Audience   string `json:"aud,omitempty"`
+	Expiration string `json:"exp,omitempty"`
+	NotBefore  string `json:"nbf,omitempty"`
+	IssuedAt   string `json:"iat,omitempty"`
+	TokenID    string `json:"jti,omitempty"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Marshal encodes the claims as JSON, suitable for use as a token's
+// message.
+func (c *Claims) Marshal() ([]byte, error) {
+	return json.Marshal(&wireClaims{
+		Issuer:     c.Issuer,
+		Subject:    c.Subject,
+		Audience:   c.Audience,
+		Expiration: formatTime(c.Expiration),
+		NotBefore:  formatTime(c.NotBefore),
+		IssuedAt:   formatTime(c.IssuedAt),
+		TokenID:    c.TokenID,
+	})
+}
+
+// ParseClaims decodes the registered claims from a token's message.
+// Any other claims in the message are ignored.
+func ParseClaims(in []byte) (*Claims, error) {
+	var w wireClaims
+	if err := json.Unmarshal(in, &w); err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	c := &Claims{
+		Issuer:   w.Issuer,
+		Subject:  w.Subject,
+		Audience: w.Audience,
+		TokenID:  w.TokenID,
+	}
+
+	var err error
+	if c.Expiration, err = parseTime(w.Expiration); err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	if c.NotBefore, err = parseTime(w.NotBefore); err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	if c.IssuedAt, err = parseTime(w.IssuedAt); err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	return c, nil
+}
+
+// Validate checks the time-based claims against now, and checks that
+// the token was intended for the audience. An empty audience skips the
+// audience check. A token without an expiration is rejected, as it
+// would be valid forever.
+func (c *Claims) Validate(audience string, now time.Time) error {
+	if c.Expiration.IsZero() || !now.Before(c.Expiration) {
+		return ErrExpired
+	}
+
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore) {
+		return ErrNotYetValid
+	}
+
+	if !c.IssuedAt.IsZero() && now.Before(c.IssuedAt) {
+		return ErrIssuedInFuture
+	}
+
+	if audience != "" && c.Audience != audience {
+		return ErrAudience
+	}
+
+	return nil
+}
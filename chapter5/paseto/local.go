@@ -0,0 +1,122 @@
+package paseto
+
+import (
+	"crypto/hmac"
+
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	// KeySize is the size of a v4.local key.
+	KeySize = 32
+
+	// NonceSize is the size of the random nonce in a v4.local token.
+	NonceSize = 32
+
+	localHeader = "v4.local."
+	tagSize     = 32
+)
+
+// GenerateKey creates a new random v4.local key.
+func GenerateKey() (*[KeySize]byte, error) {
+	key := new([KeySize]byte)
+	r, err := util.RandBytes(KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(key[:], r)
+	util.Zero(r)
+	return key, nil
+}
+
+// splitKey derives the encryption key, XChaCha20 nonce, and
+// authentication key from the shared key and the token's nonce.
+func splitKey(key *[KeySize]byte, nonce []byte) (ek, n2, ak []byte) {
+	h, _ := blake2b.New(56, key[:])
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+	ek, n2 = tmp[:32], tmp[32:]
+
+	h, _ = blake2b.New(32, key[:])
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(nonce)
+	ak = h.Sum(nil)
+	return ek, n2, ak
+}
+
+// encrypt builds a v4.local token using the given nonce.
+func encrypt(key *[KeySize]byte, nonce, message, footer, implicit []byte) (string, error) {
+	ek, n2, ak := splitKey(key, nonce)
+	defer util.Zero(ek)
+	defer util.Zero(ak)
+
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	ct := make([]byte, len(message))
+	c.XORKeyStream(ct, message)
+
+	h, _ := blake2b.New(tagSize, ak)
+	h.Write(pae([]byte(localHeader), nonce, ct, footer, implicit))
+
+	body := make([]byte, 0, len(nonce)+len(ct)+tagSize)
+	body = append(body, nonce...)
+	body = append(body, ct...)
+	body = h.Sum(body)
+	return encode(localHeader, body, footer), nil
+}
+
+// Encrypt produces a v4.local token containing the message. The footer
+// is authenticated and sent in the clear; the implicit assertion is
+// authenticated but not sent. Either may be nil.
+func Encrypt(key *[KeySize]byte, message, footer, implicit []byte) (string, error) {
+	nonce, err := util.RandBytes(NonceSize)
+	if err != nil {
+		return "", ErrEncrypt
+	}
+
+	return encrypt(key, nonce, message, footer, implicit)
+}
+
+// Decrypt authenticates and decrypts a v4.local token, returning the
+// message and footer. The implicit assertion must match the one the
+// token was created with.
+func Decrypt(key *[KeySize]byte, token string, implicit []byte) (message, footer []byte, err error) {
+	body, footer, err := decode(localHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < NonceSize+tagSize {
+		return nil, nil, ErrInvalidToken
+	}
+
+	nonce := body[:NonceSize]
+	ct := body[NonceSize : len(body)-tagSize]
+	tag := body[len(body)-tagSize:]
+
+	ek, n2, ak := splitKey(key, nonce)
+	defer util.Zero(ek)
+	defer util.Zero(ak)
+
+	h, _ := blake2b.New(tagSize, ak)
+	h.Write(pae([]byte(localHeader), nonce, ct, footer, implicit))
+	if !hmac.Equal(h.Sum(nil), tag) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	message = make([]byte, len(ct))
+	c.XORKeyStream(message, ct)
+	return message, footer, nil
+}
@@ -0,0 +1,45 @@
+package paseto
+
+import (
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"github.com/agl/ed25519"
+)
+
+const publicHeader = "v4.public."
+
+// Sign produces a v4.public token containing the message, signed by
+// the Identity. The message is not encrypted. The footer is signed
+// and sent; the implicit assertion is signed but not sent. Either may
+// be nil.
+func Sign(id *sessions.Identity, message, footer, implicit []byte) string {
+	sig := id.Sign(pae([]byte(publicHeader), message, footer, implicit))
+
+	body := make([]byte, 0, len(message)+ed25519.SignatureSize)
+	body = append(body, message...)
+	body = append(body, sig[:]...)
+	return encode(publicHeader, body, footer)
+}
+
+// Verify checks the signature on a v4.public token against the
+// signer's public key, and returns the message and footer. The
+// implicit assertion must match the one the token was signed with.
+func Verify(pub *[ed25519.PublicKeySize]byte, token string, implicit []byte) (message, footer []byte, err error) {
+	body, footer, err := decode(publicHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, ErrInvalidToken
+	}
+
+	message = body[:len(body)-ed25519.SignatureSize]
+	var sig [ed25519.SignatureSize]byte
+	copy(sig[:], body[len(message):])
+
+	if !ed25519.Verify(pub, pae([]byte(publicHeader), message, footer, implicit), &sig) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	return message, footer, nil
+}
@@ -0,0 +1,255 @@
+package ratchet
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/nacl/box"
+)
+
+type header struct {
+	dh    [32]byte
+	pn, n uint32
+}
+
+func parseHeader(in []byte) *header {
+	h := &header{}
+	copy(h.dh[:], in)
+	h.pn = binary.BigEndian.Uint32(in[32:])
+	h.n = binary.BigEndian.Uint32(in[36:])
+	return h
+}
+
+func copyKey(k *[32]byte) *[32]byte {
+	if k == nil {
+		return nil
+	}
+	out := new([32]byte)
+	*out = *k
+	return out
+}
+
+// clone returns a deep copy of the session, so that a failed decryption
+// can be discarded without changing the session.
+func (s *Session) clone() *Session {
+	c := *s
+	c.dhr = copyKey(s.dhr)
+	c.cks, c.ckr = copyKey(s.cks), copyKey(s.ckr)
+	c.hks, c.hkr = copyKey(s.hks), copyKey(s.hkr)
+	c.nhks, c.nhkr = copyKey(s.nhks), copyKey(s.nhkr)
+	c.skipped = append([]skippedKey{}, s.skipped...)
+	return &c
+}
+
+// receivingChain identifies the current receiving chain for skipped
+// keys: the peer's ratchet key, or the receiving header key.
+func (s *Session) receivingChain() [32]byte {
+	if s.opts.HeaderEncryption {
+		return *s.hkr
+	}
+	return *s.dhr
+}
+
+// skip stores the message keys for messages in the receiving chain up
+// to (but not including) until.
+func (s *Session) skip(until uint32) error {
+	if s.ckr == nil {
+		return nil
+	}
+
+	if uint64(s.nr)+uint64(s.opts.MaxSkip) < uint64(until) {
+		return ErrTooManySkipped
+	}
+
+	chain := s.receivingChain()
+	for s.nr < until {
+		ck, mk := kdfCK(s.ckr)
+		util.Zero(s.ckr[:])
+		s.ckr = ck
+		s.skipped = append(s.skipped, skippedKey{chain: chain, n: s.nr, mk: *mk})
+		util.Zero(mk[:])
+		s.nr++
+	}
+
+	if over := len(s.skipped) - s.opts.MaxSkipped; over > 0 {
+		for i := 0; i < over; i++ {
+			util.Zero(s.skipped[i].mk[:])
+		}
+		s.skipped = append([]skippedKey{}, s.skipped[over:]...)
+	}
+	return nil
+}
+
+// ratchet performs a DH ratchet step on receiving a new ratchet public
+// key from the peer.
+func (s *Session) ratchet(peer *[32]byte) error {
+	s.pn = s.ns
+	s.ns, s.nr = 0, 0
+	s.dhr = copyKey(peer)
+	if s.opts.HeaderEncryption {
+		s.hks, s.hkr = s.nhks, s.nhkr
+	}
+
+	dhOut, err := dh(&s.dhsPriv, s.dhr)
+	if err != nil {
+		return err
+	}
+
+	rk, ckr, nhkr := kdfRK(&s.rk, dhOut)
+	s.rk, s.ckr = *rk, ckr
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	s.dhsPub, s.dhsPriv = *pub, *priv
+	util.Zero(priv[:])
+
+	dhOut, err = dh(&s.dhsPriv, s.dhr)
+	if err != nil {
+		return err
+	}
+
+	rk, cks, nhks := kdfRK(&s.rk, dhOut)
+	s.rk, s.cks = *rk, cks
+	if s.opts.HeaderEncryption {
+		s.nhkr, s.nhks = nhkr, nhks
+	}
+	return nil
+}
+
+func openBody(mk *[32]byte, ad, header, body []byte) ([]byte, error) {
+	key := messageKey(mk, ad, header)
+	defer util.Zero(key[:])
+	return secret.Decrypt(key, body)
+}
+
+// trySkipped looks for a stored key for the message, returning the
+// index of the key used or -1.
+func (s *Session) trySkipped(hdr, body, ad []byte) ([]byte, int) {
+	for i := range s.skipped {
+		sk := &s.skipped[i]
+
+		var h *header
+		if s.opts.HeaderEncryption {
+			chain := sk.chain
+			plain, err := secret.Decrypt(&chain, hdr)
+			if err != nil {
+				continue
+			}
+			h = parseHeader(plain)
+		} else {
+			h = parseHeader(hdr)
+			if subtle.ConstantTimeCompare(h.dh[:], sk.chain[:]) != 1 {
+				continue
+			}
+		}
+
+		if h.n != sk.n {
+			continue
+		}
+
+		out, err := openBody(&sk.mk, ad, hdr, body)
+		if err == nil {
+			return out, i
+		}
+	}
+	return nil, -1
+}
+
+// decryptHeader decrypts an encrypted header with the current or next
+// receiving header key, reporting whether the next key was used (which
+// means the peer has performed a DH ratchet step).
+func (s *Session) decryptHeader(in []byte) (*header, bool, error) {
+	if s.hkr != nil {
+		if plain, err := secret.Decrypt(s.hkr, in); err == nil {
+			return parseHeader(plain), false, nil
+		}
+	}
+
+	if s.nhkr != nil {
+		if plain, err := secret.Decrypt(s.nhkr, in); err == nil {
+			return parseHeader(plain), true, nil
+		}
+	}
+	return nil, false, ErrDecrypt
+}
+
+// Decrypt decrypts a message from the peer with the same associated
+// data passed to Encrypt. If decryption fails, the session is left
+// unchanged.
+func (s *Session) Decrypt(message, ad []byte) ([]byte, error) {
+	size := headerSize
+	if s.opts.HeaderEncryption {
+		size = encHeaderSize
+	}
+
+	if len(message) < size {
+		return nil, ErrDecrypt
+	}
+	hdr, body := message[:size], message[size:]
+
+	if out, i := s.trySkipped(hdr, body, ad); i >= 0 {
+		util.Zero(s.skipped[i].mk[:])
+		s.skipped = append(s.skipped[:i], s.skipped[i+1:]...)
+		return out, nil
+	}
+
+	st := s.clone()
+
+	var h *header
+	var newChain bool
+	if st.opts.HeaderEncryption {
+		var err error
+		h, newChain, err = st.decryptHeader(hdr)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+	} else {
+		h = parseHeader(hdr)
+		newChain = st.dhr == nil || subtle.ConstantTimeCompare(h.dh[:], st.dhr[:]) != 1
+	}
+
+	if newChain {
+		if err := st.skip(h.pn); err != nil {
+			return nil, err
+		}
+
+		if err := st.ratchet(&h.dh); err != nil {
+			return nil, ErrDecrypt
+		}
+	}
+
+	if err := st.skip(h.n); err != nil {
+		return nil, err
+	}
+
+	// A message number behind the chain is a replay, or a message
+	// whose skipped key has already been used or discarded.
+	if h.n < st.nr {
+		return nil, ErrDecrypt
+	}
+
+	// An initiator has no receiving chain until the responder's first
+	// message ratchets; a forged header naming the responder's
+	// current key must not get this far.
+	if st.ckr == nil {
+		return nil, ErrDecrypt
+	}
+
+	ck, mk := kdfCK(st.ckr)
+	st.ckr = ck
+	st.nr++
+
+	out, err := openBody(mk, ad, hdr, body)
+	util.Zero(mk[:])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	*s = *st
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package ratchet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const stateVersion = 1
+
+// ErrInvalidState is returned when a serialised session can't be
+// parsed.
+var ErrInvalidState = errors.New("ratchet: invalid session state")
+
+func writeKey(buf *bytes.Buffer, k *[32]byte) {
+	if k == nil {
+		buf.WriteByte(0)
+		return
+	}
+	buf.WriteByte(1)
+	buf.Write(k[:])
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+// Marshal serialises the session, so that it can be stored between
+// messages. The output contains the session's secret keys, and must be
+// protected (for example, with secretbox) as carefully as the keys
+// themselves.
+func (s *Session) Marshal() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(stateVersion)
+	if s.opts.HeaderEncryption {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	writeUint32(buf, uint32(s.opts.MaxSkip))
+	writeUint32(buf, uint32(s.opts.MaxSkipped))
+
+	buf.Write(s.dhsPriv[:])
+	buf.Write(s.dhsPub[:])
+	writeKey(buf, s.dhr)
+	buf.Write(s.rk[:])
+	writeKey(buf, s.cks)
+	writeKey(buf, s.ckr)
+	writeUint32(buf, s.ns)
+	writeUint32(buf, s.nr)
+	writeUint32(buf, s.pn)
+	for _, k := range []*[32]byte{s.hks, s.hkr, s.nhks, s.nhkr} {
+		writeKey(buf, k)
+	}
+
+	writeUint32(buf, uint32(len(s.skipped)))
+	for i := range s.skipped {
+		buf.Write(s.skipped[i].chain[:])
+		writeUint32(buf, s.skipped[i].n)
+		buf.Write(s.skipped[i].mk[:])
+	}
+	return buf.Bytes()
+}
+
+// stateReader reads fields from a serialised session, remembering the
+// first error.
+type stateReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (sr *stateReader) read(p []byte) {
+	if sr.err == nil {
+		_, sr.err = io.ReadFull(sr.r, p)
+	}
+}
+
+func (sr *stateReader) byte() byte {
+	var b [1]byte
+	sr.read(b[:])
+	return b[0]
+}
+
+func (sr *stateReader) uint32() uint32 {
+	var b [4]byte
+	sr.read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (sr *stateReader) key() *[32]byte {
+	switch sr.byte() {
+	case 0:
+		return nil
+	case 1:
+		k := new([32]byte)
+		sr.read(k[:])
+		return k
+	default:
+		sr.err = ErrInvalidState
+		return nil
+	}
+}
+
+// Unmarshal restores a session serialised with Marshal.
+func Unmarshal(in []byte) (*Session, error) {
+	sr := &stateReader{r: bytes.NewReader(in)}
+	if sr.byte() != stateVersion {
+		return nil, ErrInvalidState
+	}
+
+	s := &Session{}
+	switch sr.byte() {
+	case 0:
+	case 1:
+		s.opts.HeaderEncryption = true
+	default:
+		return nil, ErrInvalidState
+	}
+	s.opts.MaxSkip = int(sr.uint32())
+	s.opts.MaxSkipped = int(sr.uint32())
+
+	sr.read(s.dhsPriv[:])
+	sr.read(s.dhsPub[:])
+	s.dhr = sr.key()
+	sr.read(s.rk[:])
+	s.cks = sr.key()
+	s.ckr = sr.key()
+	s.ns = sr.uint32()
+	s.nr = sr.uint32()
+	s.pn = sr.uint32()
+	s.hks = sr.key()
+	s.hkr = sr.key()
+	s.nhks = sr.key()
+	s.nhkr = sr.key()
+
+	count := sr.uint32()
+	if sr.err != nil || uint64(count)*68 != uint64(sr.r.Len()) {
+		return nil, ErrInvalidState
+	}
+
+	s.skipped = make([]skippedKey, count)
+	for i := range s.skipped {
+		sr.read(s.skipped[i].chain[:])
+		s.skipped[i].n = sr.uint32()
+		sr.read(s.skipped[i].mk[:])
+	}
+
+	if sr.err != nil || s.opts.MaxSkip <= 0 || s.opts.MaxSkipped <= 0 {
+		return nil, ErrInvalidState
+	}
+
+	// A session that has a receiving chain must know which chain it is.
+	if s.ckr != nil && s.dhr == nil {
+		return nil, ErrInvalidState
+	}
+	if s.opts.HeaderEncryption && s.ckr != nil && s.hkr == nil {
+		return nil, ErrInvalidState
+	}
+
+	// Likewise, a session that can send must have a header key to
+	// encrypt its headers with.
+	if s.opts.HeaderEncryption && s.cks != nil && s.hks == nil {
+		return nil, ErrInvalidState
+	}
+	return s, nil
+}
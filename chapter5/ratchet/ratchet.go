@@ -0,0 +1,300 @@
+// Package ratchet implements the Double Ratchet algorithm for
+// messaging sessions. Each message is encrypted under a fresh key from
+// a symmetric-key ratchet, and every round trip performs a new X25519
+// exchange (the DH ratchet), so compromising the session state exposes
+// neither earlier messages nor, once the peers have exchanged messages
+// again, later ones.
+//
+// Sessions start from a shared secret, such as the key agreed with
+// x3dh, and the responder's initial ratchet key pair. Messages may
+// arrive out of order: keys for skipped messages are kept, up to a
+// limit, until those messages arrive.
+//
+// Messages are encrypted with the chapter 3 NaCl secretbox suite. As
+// secretbox doesn't take additional data, the header and associated
+// data are bound to the message by deriving the secretbox key from the
+// message key, the associated data, and the header. Optionally, the
+// header itself is encrypted, hiding the ratchet public keys and
+// message numbers from observers.
+package ratchet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"git.metacircular.net/kyle/gocrypto/chapter3/nacl"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// KeySize is the size of the shared secret a session starts from.
+	KeySize = 32
+
+	// DefaultMaxSkip is the default limit on how many messages can be
+	// skipped in a single chain.
+	DefaultMaxSkip = 1000
+
+	// DefaultMaxSkipped is the default limit on how many skipped
+	// message keys are stored; the oldest are discarded first.
+	DefaultMaxSkipped = 2000
+
+	headerSize = 32 + 4 + 4
+
+	// encHeaderSize is the size of an encrypted header: a secretbox
+	// nonce, the header, and the Poly1305 tag.
+	encHeaderSize = secret.NonceSize + headerSize + 16
+
+	rootInfo       = "ratchet root"
+	headerKeysInfo = "ratchet header keys"
+	messageInfo    = "ratchet message"
+)
+
+var (
+	// ErrEncrypt is returned when a message can't be encrypted.
+	ErrEncrypt = errors.New("ratchet: encryption failed")
+
+	// ErrDecrypt is returned when a message can't be decrypted. The
+	// session state is unchanged.
+	ErrDecrypt = errors.New("ratchet: decryption failed")
+
+	// ErrNoSendingChain is returned when the responder tries to send
+	// before it has received a message.
+	ErrNoSendingChain = errors.New("ratchet: no sending chain yet")
+
+	// ErrTooManySkipped is returned when a message would require
+	// skipping more than the session's limit.
+	ErrTooManySkipped = errors.New("ratchet: too many skipped messages")
+)
+
+// Options configure a session. Both peers must agree on
+// HeaderEncryption.
+type Options struct {
+	// HeaderEncryption encrypts message headers.
+	HeaderEncryption bool
+
+	// MaxSkip limits how far ahead in a chain a message may be.
+	MaxSkip int
+
+	// MaxSkipped limits the number of stored skipped message keys.
+	MaxSkipped int
+}
+
+// DefaultOptions don't encrypt headers, and use the default limits.
+var DefaultOptions = &Options{
+	MaxSkip:    DefaultMaxSkip,
+	MaxSkipped: DefaultMaxSkipped,
+}
+
+// skippedKey is a stored message key, indexed by the ratchet public
+// key (or, with header encryption, the header key) of its chain and its
+// message number.
+type skippedKey struct {
+	chain [32]byte
+	n     uint32
+	mk    [32]byte
+}
+
+// A Session is one side of a Double Ratchet conversation. It isn't safe
+// for concurrent use.
+type Session struct {
+	opts Options
+
+	dhsPriv, dhsPub [32]byte
+	dhr             *[32]byte
+	rk              [32]byte
+	cks, ckr        *[32]byte
+	ns, nr, pn      uint32
+
+	// Header keys; only used with header encryption.
+	hks, hkr, nhks, nhkr *[32]byte
+
+	skipped []skippedKey
+}
+
+func (o *Options) normalise() Options {
+	if o == nil {
+		o = DefaultOptions
+	}
+
+	opts := *o
+	if opts.MaxSkip <= 0 {
+		opts.MaxSkip = DefaultMaxSkip
+	}
+	if opts.MaxSkipped <= 0 {
+		opts.MaxSkipped = DefaultMaxSkipped
+	}
+	return opts
+}
+
+func dh(priv, pub *[32]byte) ([]byte, error) {
+	return curve25519.X25519(priv[:], pub[:])
+}
+
+// kdfRK advances the root key with a DH output, producing a new root
+// key, a chain key and, with header encryption, the next header key.
+func kdfRK(rk *[32]byte, dhOut []byte) (newRK, ck, nhk *[32]byte) {
+	defer util.Zero(dhOut)
+	var buf [96]byte
+	io.ReadFull(hkdf.New(sha256.New, dhOut, rk[:], []byte(rootInfo)), buf[:])
+	defer util.Zero(buf[:])
+
+	newRK, ck, nhk = new([32]byte), new([32]byte), new([32]byte)
+	copy(newRK[:], buf[:32])
+	copy(ck[:], buf[32:64])
+	copy(nhk[:], buf[64:])
+	return newRK, ck, nhk
+}
+
+// kdfCK advances a chain key, returning the new chain key and the
+// message key.
+func kdfCK(ck *[32]byte) (newCK, mk *[32]byte) {
+	newCK, mk = new([32]byte), new([32]byte)
+
+	h := hmac.New(sha256.New, ck[:])
+	h.Write([]byte{1})
+	copy(mk[:], h.Sum(nil))
+
+	h = hmac.New(sha256.New, ck[:])
+	h.Write([]byte{2})
+	copy(newCK[:], h.Sum(nil))
+	return newCK, mk
+}
+
+// headerKeys derives the initial shared header keys from the shared
+// secret: the initiator's first sending header key, and the responder's
+// first next header key.
+func headerKeys(sk *[KeySize]byte) (hka, nhkb *[32]byte) {
+	var buf [64]byte
+	io.ReadFull(hkdf.New(sha256.New, sk[:], nil, []byte(headerKeysInfo)), buf[:])
+	defer util.Zero(buf[:])
+
+	hka, nhkb = new([32]byte), new([32]byte)
+	copy(hka[:], buf[:32])
+	copy(nhkb[:], buf[32:])
+	return hka, nhkb
+}
+
+// messageKey derives the secretbox key for a message from its message
+// key, the associated data, and the (possibly encrypted) header.
+func messageKey(mk *[32]byte, ad, header []byte) *[secret.KeySize]byte {
+	h := hmac.New(sha256.New, mk[:])
+	h.Write([]byte(messageInfo))
+	var adLen [4]byte
+	binary.BigEndian.PutUint32(adLen[:], uint32(len(ad)))
+	h.Write(adLen[:])
+	h.Write(ad)
+	h.Write(header)
+
+	key := new([secret.KeySize]byte)
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func newSession(opts *Options) *Session {
+	return &Session{opts: opts.normalise()}
+}
+
+// NewInitiator starts a session as the party sending the first message,
+// from the shared secret and the responder's ratchet public key.
+func NewInitiator(sk *[KeySize]byte, peer *[32]byte, opts *Options) (*Session, error) {
+	s := newSession(opts)
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.dhsPub, s.dhsPriv = *pub, *priv
+	util.Zero(priv[:])
+
+	s.dhr = new([32]byte)
+	*s.dhr = *peer
+
+	dhOut, err := dh(&s.dhsPriv, s.dhr)
+	if err != nil {
+		return nil, err
+	}
+
+	rk, cks, nhks := kdfRK(sk, dhOut)
+	s.rk, s.cks = *rk, cks
+	if s.opts.HeaderEncryption {
+		s.hks, s.nhkr = headerKeys(sk)
+		s.nhks = nhks
+	}
+	return s, nil
+}
+
+// NewResponder starts a session as the party receiving the first
+// message, from the shared secret and its ratchet key pair. The
+// responder can't send until it has received a message.
+func NewResponder(sk *[KeySize]byte, pub, priv *[32]byte, opts *Options) *Session {
+	s := newSession(opts)
+	s.dhsPub, s.dhsPriv = *pub, *priv
+	s.rk = *sk
+	if s.opts.HeaderEncryption {
+		s.nhkr, s.nhks = headerKeys(sk)
+	}
+	return s
+}
+
+// Zero wipes the session's keys.
+func (s *Session) Zero() {
+	util.Zero(s.dhsPriv[:])
+	util.Zero(s.rk[:])
+	for _, k := range []*[32]byte{s.cks, s.ckr, s.hks, s.hkr, s.nhks, s.nhkr} {
+		if k != nil {
+			util.Zero(k[:])
+		}
+	}
+	for i := range s.skipped {
+		util.Zero(s.skipped[i].mk[:])
+	}
+	s.skipped = nil
+}
+
+func (s *Session) header() []byte {
+	out := make([]byte, headerSize)
+	copy(out, s.dhsPub[:])
+	binary.BigEndian.PutUint32(out[32:], s.pn)
+	binary.BigEndian.PutUint32(out[36:], s.ns)
+	return out
+}
+
+// Encrypt encrypts the next message in the session. The associated
+// data is authenticated but not sent; the peer must supply the same
+// associated data to decrypt.
+func (s *Session) Encrypt(message, ad []byte) ([]byte, error) {
+	if s.cks == nil {
+		return nil, ErrNoSendingChain
+	}
+
+	header := s.header()
+	if s.opts.HeaderEncryption {
+		var err error
+		header, err = secret.Encrypt(s.hks, header)
+		if err != nil {
+			return nil, ErrEncrypt
+		}
+	}
+
+	ck, mk := kdfCK(s.cks)
+	key := messageKey(mk, ad, header)
+	util.Zero(mk[:])
+	defer util.Zero(key[:])
+
+	body, err := secret.Encrypt(key, message)
+	if err != nil {
+		util.Zero(ck[:])
+		return nil, ErrEncrypt
+	}
+
+	util.Zero(s.cks[:])
+	s.cks = ck
+	s.ns++
+	return append(header, body...), nil
+}
@@ -0,0 +1,292 @@
+package ratchet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"git.metacircular.net/kyle/gocrypto/chapter5/x3dh"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+// setup agrees a shared secret with x3dh, and starts Alice's and Bob's
+// sessions from it. Bob's initial ratchet public key would normally be
+// sent alongside the prekey bundle.
+func setup(t *testing.T, opts *Options) (alice, bob *Session, ad []byte) {
+	aliceID, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobID, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	prekeys, err := x3dh.NewPrekeys(bobID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	server := x3dh.NewServer()
+	if err = server.Publish(bobID.Public(), prekeys.SignedPrekey(), nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bundle, err := server.Fetch(bobID.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m, aliceAgreement, err := x3dh.Initiate(aliceID, bundle, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, bobAgreement, err := prekeys.Respond(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	alice, err = NewInitiator(&aliceAgreement.Key, pub, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob = NewResponder(&bobAgreement.Key, pub, priv, opts)
+	return alice, bob, aliceAgreement.AssociatedData
+}
+
+func send(t *testing.T, s *Session, msg, ad []byte) []byte {
+	out, err := s.Encrypt(msg, ad)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return out
+}
+
+func receive(t *testing.T, s *Session, msg, ad, expected []byte) {
+	out, err := s.Decrypt(msg, ad)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, expected) {
+		t.Fatal("recovered message doesn't match original")
+	}
+}
+
+func numbered(i int) []byte {
+	return []byte(fmt.Sprintf("%s %d", testMessage, i))
+}
+
+var testOptions = []*Options{
+	nil,
+	{HeaderEncryption: true},
+}
+
+func TestConversation(t *testing.T) {
+	for _, opts := range testOptions {
+		alice, bob, ad := setup(t, opts)
+
+		if _, err := bob.Encrypt(testMessage, ad); err != ErrNoSendingChain {
+			t.Fatal("expected the responder to be unable to send first")
+		}
+
+		// Several round trips, each with a few messages in each
+		// direction, exercise both ratchets.
+		for round := 0; round < 4; round++ {
+			for i := 0; i < 3; i++ {
+				receive(t, bob, send(t, alice, numbered(i), ad), ad, numbered(i))
+			}
+
+			for i := 0; i < 3; i++ {
+				receive(t, alice, send(t, bob, numbered(i), ad), ad, numbered(i))
+			}
+		}
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	for _, opts := range testOptions {
+		alice, bob, ad := setup(t, opts)
+
+		var msgs [][]byte
+		for i := 0; i < 5; i++ {
+			msgs = append(msgs, send(t, alice, numbered(i), ad))
+		}
+
+		receive(t, bob, msgs[3], ad, numbered(3))
+		receive(t, bob, msgs[0], ad, numbered(0))
+
+		// Bob replies, so Alice's next messages are on a new chain;
+		// the rest of the old chain must still be readable.
+		receive(t, alice, send(t, bob, testMessage, ad), ad, testMessage)
+		next := send(t, alice, numbered(5), ad)
+
+		receive(t, bob, next, ad, numbered(5))
+		receive(t, bob, msgs[4], ad, numbered(4))
+		receive(t, bob, msgs[1], ad, numbered(1))
+		receive(t, bob, msgs[2], ad, numbered(2))
+
+		// Each message can only be decrypted once.
+		for _, m := range append(msgs, next) {
+			if _, err := bob.Decrypt(m, ad); err != ErrDecrypt {
+				t.Fatal("expected a replayed message to fail")
+			}
+		}
+	}
+}
+
+func TestSkipLimits(t *testing.T) {
+	for _, headers := range []bool{false, true} {
+		opts := &Options{HeaderEncryption: headers, MaxSkip: 4, MaxSkipped: 6}
+		alice, bob, ad := setup(t, opts)
+
+		var msgs [][]byte
+		for i := 0; i < 6; i++ {
+			msgs = append(msgs, send(t, alice, numbered(i), ad))
+		}
+
+		if _, err := bob.Decrypt(msgs[5], ad); err != ErrTooManySkipped {
+			t.Fatal("expected a message too far ahead to fail")
+		}
+
+		receive(t, bob, msgs[4], ad, numbered(4))
+		receive(t, bob, msgs[5], ad, numbered(5))
+
+		// Skip another four messages; only the six newest skipped
+		// keys are kept.
+		for i := 6; i < 11; i++ {
+			msgs = append(msgs, send(t, alice, numbered(i), ad))
+		}
+		receive(t, bob, msgs[10], ad, numbered(10))
+
+		if len(bob.skipped) != 6 {
+			t.Fatalf("expected 6 stored keys, have %d", len(bob.skipped))
+		}
+
+		for _, i := range []int{0, 1} {
+			if _, err := bob.Decrypt(msgs[i], ad); err != ErrDecrypt {
+				t.Fatal("expected a message with a discarded key to fail")
+			}
+		}
+
+		for _, i := range []int{2, 3, 6, 7, 8, 9} {
+			receive(t, bob, msgs[i], ad, numbered(i))
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	for _, opts := range testOptions {
+		alice, bob, ad := setup(t, opts)
+		msg := send(t, alice, testMessage, ad)
+		state := bob.Marshal()
+
+		for _, i := range []int{0, 35, len(msg) - 1} {
+			msg[i] ^= 1
+			if _, err := bob.Decrypt(msg, ad); err == nil {
+				t.Fatal("expected a modified message to fail")
+			}
+			msg[i] ^= 1
+		}
+
+		if _, err := bob.Decrypt(msg, []byte("wrong")); err != ErrDecrypt {
+			t.Fatal("expected the wrong associated data to fail")
+		}
+
+		if _, err := bob.Decrypt(msg[:10], ad); err != ErrDecrypt {
+			t.Fatal("expected a truncated message to fail")
+		}
+
+		if !bytes.Equal(state, bob.Marshal()) {
+			t.Fatal("failed decryptions changed the session")
+		}
+
+		receive(t, bob, msg, ad, testMessage)
+	}
+}
+
+// TestForgedHeader sends the initiator, before it has a receiving
+// chain, a header naming the responder's current ratchet key.
+func TestForgedHeader(t *testing.T) {
+	var sk [KeySize]byte
+	pub, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	alice, err := NewInitiator(&sk, pub, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	msg := make([]byte, 104)
+	copy(msg, pub[:])
+	if _, err = alice.Decrypt(msg, nil); err != ErrDecrypt {
+		t.Fatalf("expected ErrDecrypt, have %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, opts := range testOptions {
+		alice, bob, ad := setup(t, opts)
+
+		var held [][]byte
+		for i := 0; i < 3; i++ {
+			held = append(held, send(t, alice, numbered(i), ad))
+		}
+		receive(t, bob, held[2], ad, numbered(2))
+		receive(t, alice, send(t, bob, testMessage, ad), ad, testMessage)
+
+		// Restore both sides mid-conversation, with skipped keys
+		// outstanding.
+		restored, err := Unmarshal(alice.Marshal())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		alice.Zero()
+		alice = restored
+
+		restored, err = Unmarshal(bob.Marshal())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		bob.Zero()
+		bob = restored
+
+		receive(t, bob, held[0], ad, numbered(0))
+		receive(t, bob, send(t, alice, testMessage, ad), ad, testMessage)
+		receive(t, bob, held[1], ad, numbered(1))
+		receive(t, alice, send(t, bob, testMessage, ad), ad, testMessage)
+
+		state := bob.Marshal()
+		if _, err = Unmarshal(state[:len(state)-1]); err != ErrInvalidState {
+			t.Fatal("expected truncated state to fail")
+		}
+
+		state[0]++
+		if _, err = Unmarshal(state); err != ErrInvalidState {
+			t.Fatal("expected an unknown state version to fail")
+		}
+
+		// A sending chain without a header key to go with it.
+		if opts != nil && opts.HeaderEncryption {
+			broken := alice.clone()
+			broken.hks = nil
+			if _, err = Unmarshal(broken.Marshal()); err != ErrInvalidState {
+				t.Fatal("expected a sending chain without a header key to fail")
+			}
+		}
+	}
+}
@@ -70,6 +70,11 @@ func (id *Identity) Public() *[ed25519.PublicKeySize]byte {
 	return pub
 }
 
+// Sign signs the message with the Identity's private key.
+func (id *Identity) Sign(message []byte) *[ed25519.SignatureSize]byte {
+	return ed25519.Sign(id.private, message)
+}
+
 // Marshal serialises a copy of the Identity. It is intended to support
 // persistent Identities.
 func Marshal(id *Identity) []byte {
@@ -0,0 +1,66 @@
+package sessions
+
+import (
+	"crypto/sha512"
+	"errors"
+
+	"filippo.io/edwards25519"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"github.com/agl/ed25519"
+	"golang.org/x/crypto/curve25519"
+)
+
+// ErrInvalidPeerKey is returned when an Ed25519 public key can't be
+// mapped to Curve25519.
+var ErrInvalidPeerKey = errors.New("sessions: invalid peer key")
+
+// x25519Private maps the Ed25519 private key to the equivalent
+// Curve25519 private key: the first half of the SHA-512 digest of the
+// seed, which X25519 clamps in the same way Ed25519 does.
+func (id *Identity) x25519Private() []byte {
+	h := sha512.Sum512(id.private[:32])
+	defer util.Zero(h[:])
+	return append([]byte{}, h[:32]...)
+}
+
+// X25519Public returns the Identity's public key mapped to Curve25519.
+func (id *Identity) X25519Public() *[32]byte {
+	priv := id.x25519Private()
+	defer util.Zero(priv)
+
+	pub := new([32]byte)
+	out, _ := curve25519.X25519(priv, curve25519.Basepoint)
+	copy(pub[:], out)
+	return pub
+}
+
+// DH performs an X25519 key agreement between the Identity's key,
+// mapped to Curve25519, and a Curve25519 public key. This lets the
+// signing key also be used for key agreement, as in X3DH.
+func (id *Identity) DH(peer *[32]byte) (*[32]byte, error) {
+	priv := id.x25519Private()
+	defer util.Zero(priv)
+
+	out, err := curve25519.X25519(priv, peer[:])
+	if err != nil {
+		return nil, err
+	}
+
+	shared := new([32]byte)
+	copy(shared[:], out)
+	util.Zero(out)
+	return shared, nil
+}
+
+// PeerX25519 maps a peer's Ed25519 public key to the Curve25519 public
+// key matching Identity.X25519Public.
+func PeerX25519(peer *[ed25519.PublicKeySize]byte) (*[32]byte, error) {
+	p, err := new(edwards25519.Point).SetBytes(peer[:])
+	if err != nil {
+		return nil, ErrInvalidPeerKey
+	}
+
+	pub := new([32]byte)
+	copy(pub[:], p.BytesMontgomery())
+	return pub, nil
+}
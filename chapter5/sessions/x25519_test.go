@@ -0,0 +1,50 @@
+package sessions
+
+import "testing"
+
+func TestX25519(t *testing.T) {
+	alice, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pub, err := PeerX25519(alice.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if *pub != *alice.X25519Public() {
+		t.Fatal("mapped public key doesn't match the mapped private key")
+	}
+
+	bobPub, err := PeerX25519(bob.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ab, err := alice.DH(bobPub)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ba, err := bob.DH(pub)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if *ab != *ba {
+		t.Fatal("shared keys don't match")
+	}
+
+	// y = 2 isn't the encoding of a point on the curve.
+	var bad [32]byte
+	bad[0] = 2
+	if _, err = PeerX25519(&bad); err != ErrInvalidPeerKey {
+		t.Fatal("expected an invalid public key to fail")
+	}
+}
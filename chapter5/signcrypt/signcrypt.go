@@ -0,0 +1,99 @@
+// Package signcrypt produces messages that are both confidential to a
+// recipient and signed by the sender. The message is signed with the
+// sender's sessions Identity, then the signer's public key, signature,
+// and message are sealed to the recipient's Curve25519 key with
+// naclbox:
+//
+//	naclbox(signer public key || signature || message)
+//
+// Signing and then encrypting is open to surreptitious forwarding: the
+// recipient could decrypt the message and seal it, still signed, to a
+// third party, who would believe it was meant for them. To prevent
+// this, the signature covers the recipient's public key as well as the
+// message, so a forwarded message fails to verify.
+package signcrypt
+
+import (
+	"errors"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/naclbox"
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"github.com/agl/ed25519"
+	"golang.org/x/crypto/curve25519"
+)
+
+const domain = "signcrypt v1"
+
+// Overhead is the length of additional data added to a message.
+const Overhead = ed25519.PublicKeySize + ed25519.SignatureSize + naclbox.Overhead
+
+var (
+	// ErrEncrypt is returned when sealing fails.
+	ErrEncrypt = errors.New("signcrypt: encryption failed")
+
+	// ErrDecrypt is returned when a message can't be decrypted.
+	ErrDecrypt = errors.New("signcrypt: decryption failed")
+
+	// ErrSignature is returned when a message decrypts but its
+	// signature is invalid, including when it was forwarded from
+	// another recipient.
+	ErrSignature = errors.New("signcrypt: invalid signature")
+)
+
+// signedData is the data covered by the signature.
+func signedData(recipient *[32]byte, message []byte) []byte {
+	out := make([]byte, 0, len(domain)+len(recipient)+len(message))
+	out = append(out, domain...)
+	out = append(out, recipient[:]...)
+	return append(out, message...)
+}
+
+// Seal signs the message with the identity and encrypts it to the
+// recipient's Curve25519 public key.
+func Seal(id *sessions.Identity, recipient *[32]byte, message []byte) ([]byte, error) {
+	sig := id.Sign(signedData(recipient, message))
+
+	inner := make([]byte, 0, ed25519.PublicKeySize+ed25519.SignatureSize+len(message))
+	inner = append(inner, id.Public()[:]...)
+	inner = append(inner, sig[:]...)
+	inner = append(inner, message...)
+	defer util.Zero(inner)
+
+	out, err := naclbox.Encrypt(recipient, inner)
+	if err != nil {
+		return nil, ErrEncrypt
+	}
+	return out, nil
+}
+
+// Open decrypts a sealed message with the recipient's Curve25519
+// private key and verifies its signature. It returns the message and
+// the signer's Ed25519 public key; the caller decides whether the
+// signer is trusted.
+func Open(priv *[32]byte, sealed []byte) ([]byte, *[ed25519.PublicKeySize]byte, error) {
+	inner, err := naclbox.Decrypt(priv, sealed)
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+
+	if len(inner) < ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, nil, ErrDecrypt
+	}
+
+	signer := new([ed25519.PublicKeySize]byte)
+	copy(signer[:], inner)
+
+	var sig [ed25519.SignatureSize]byte
+	copy(sig[:], inner[ed25519.PublicKeySize:])
+	message := inner[ed25519.PublicKeySize+ed25519.SignatureSize:]
+
+	var recipient [32]byte
+	curve25519.ScalarBaseMult(&recipient, priv)
+	if !ed25519.Verify(signer, signedData(&recipient, message), &sig) {
+		util.Zero(inner)
+		return nil, nil, ErrSignature
+	}
+
+	return message, signer, nil
+}
@@ -0,0 +1,93 @@
+package signcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter4/naclbox"
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+func TestSignCrypt(t *testing.T) {
+	alice, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobPub, bobPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sealed, err := Seal(alice, bobPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(sealed) != len(testMessage)+Overhead {
+		t.Fatal("sealed message has the wrong length")
+	}
+
+	out, signer, err := Open(bobPriv, sealed)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(out, testMessage) {
+		t.Fatal("recovered message doesn't match original")
+	}
+
+	if *signer != *alice.Public() {
+		t.Fatal("wrong signer was reported")
+	}
+
+	_, evePriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, _, err = Open(evePriv, sealed); err != ErrDecrypt {
+		t.Fatal("expected decryption by another recipient to fail")
+	}
+}
+
+func TestForwarding(t *testing.T) {
+	alice, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bobPub, bobPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	carolPub, carolPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sealed, err := Seal(alice, bobPub, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Bob decrypts the inner signed message and re-seals it to Carol.
+	inner, err := naclbox.Decrypt(bobPriv, sealed)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	forwarded, err := naclbox.Encrypt(carolPub, inner)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, _, err = Open(carolPriv, forwarded); err != ErrSignature {
+		t.Fatal("expected a forwarded message to fail verification")
+	}
+}
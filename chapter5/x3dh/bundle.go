@@ -0,0 +1,136 @@
+package x3dh
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/agl/ed25519"
+)
+
+const (
+	bundleSize  = ed25519.PublicKeySize + 4 + 32 + ed25519.SignatureSize + 1
+	oneTimeSize = 4 + 32
+	initialSize = ed25519.PublicKeySize + 32 + 4 + 1 + 4
+)
+
+// ErrMalformed is returned when a bundle or initial message can't be
+// parsed.
+var ErrMalformed = errors.New("x3dh: malformed message")
+
+// A Bundle is the set of public keys a sender needs to start a
+// conversation: the recipient's identity key, signed prekey, and (if
+// the server has any left) one one-time prekey.
+type Bundle struct {
+	Identity        [ed25519.PublicKeySize]byte
+	SignedPrekeyID  uint32
+	SignedPrekey    [32]byte
+	Signature       [ed25519.SignatureSize]byte
+	OneTimePrekeyID uint32
+	OneTimePrekey   *[32]byte
+}
+
+// Verify checks that the signed prekey was signed by the identity key.
+func (b *Bundle) Verify() bool {
+	spk := SignedPrekey{
+		ID:        b.SignedPrekeyID,
+		Public:    b.SignedPrekey,
+		Signature: b.Signature,
+	}
+	return spk.Verify(&b.Identity)
+}
+
+// Marshal serialises the bundle as
+//
+//	identity key || signed prekey ID || signed prekey || signature ||
+//	    flag || [one-time prekey ID || one-time prekey]
+//
+// where the flag is 1 if a one-time prekey follows. IDs are 32-bit
+// big-endian integers.
+func (b *Bundle) Marshal() []byte {
+	out := make([]byte, 0, bundleSize+oneTimeSize)
+	out = append(out, b.Identity[:]...)
+	out = binary.BigEndian.AppendUint32(out, b.SignedPrekeyID)
+	out = append(out, b.SignedPrekey[:]...)
+	out = append(out, b.Signature[:]...)
+	if b.OneTimePrekey == nil {
+		return append(out, 0)
+	}
+
+	out = append(out, 1)
+	out = binary.BigEndian.AppendUint32(out, b.OneTimePrekeyID)
+	return append(out, b.OneTimePrekey[:]...)
+}
+
+// ParseBundle parses a serialised bundle. It doesn't verify the
+// signature; Initiate does that.
+func ParseBundle(in []byte) (*Bundle, error) {
+	if len(in) != bundleSize && len(in) != bundleSize+oneTimeSize {
+		return nil, ErrMalformed
+	}
+
+	b := &Bundle{}
+	copy(b.Identity[:], in)
+	in = in[ed25519.PublicKeySize:]
+	b.SignedPrekeyID = binary.BigEndian.Uint32(in)
+	copy(b.SignedPrekey[:], in[4:])
+	copy(b.Signature[:], in[36:])
+	in = in[36+ed25519.SignatureSize:]
+
+	switch {
+	case in[0] == 0 && len(in) == 1:
+		return b, nil
+	case in[0] == 1 && len(in) == 1+oneTimeSize:
+		b.OneTimePrekeyID = binary.BigEndian.Uint32(in[1:])
+		b.OneTimePrekey = new([32]byte)
+		copy(b.OneTimePrekey[:], in[5:])
+		return b, nil
+	default:
+		return nil, ErrMalformed
+	}
+}
+
+// An InitialMessage is sent by the initiator to start a conversation.
+type InitialMessage struct {
+	Identity        [ed25519.PublicKeySize]byte
+	Ephemeral       [32]byte
+	SignedPrekeyID  uint32
+	HasOneTime      bool
+	OneTimePrekeyID uint32
+	Ciphertext      []byte
+}
+
+// Marshal serialises the initial message as
+//
+//	identity key || ephemeral key || signed prekey ID || flag ||
+//	    one-time prekey ID || nonce || ciphertext
+//
+// where the flag is 1 if a one-time prekey was used.
+func (m *InitialMessage) Marshal() []byte {
+	out := make([]byte, 0, initialSize+len(m.Ciphertext))
+	out = append(out, m.Identity[:]...)
+	out = append(out, m.Ephemeral[:]...)
+	out = binary.BigEndian.AppendUint32(out, m.SignedPrekeyID)
+	if m.HasOneTime {
+		out = append(out, 1)
+	} else {
+		out = append(out, 0)
+	}
+	out = binary.BigEndian.AppendUint32(out, m.OneTimePrekeyID)
+	return append(out, m.Ciphertext...)
+}
+
+// ParseInitialMessage parses a serialised initial message.
+func ParseInitialMessage(in []byte) (*InitialMessage, error) {
+	if len(in) < initialSize || in[68] > 1 {
+		return nil, ErrMalformed
+	}
+
+	m := &InitialMessage{}
+	copy(m.Identity[:], in)
+	copy(m.Ephemeral[:], in[32:])
+	m.SignedPrekeyID = binary.BigEndian.Uint32(in[64:])
+	m.HasOneTime = in[68] == 1
+	m.OneTimePrekeyID = binary.BigEndian.Uint32(in[69:])
+	m.Ciphertext = append([]byte{}, in[initialSize:]...)
+	return m, nil
+}
@@ -0,0 +1,193 @@
+package x3dh
+
+import (
+	"crypto/rand"
+
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"github.com/agl/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A SignedPrekey is the public half of a medium-term prekey, signed by
+// the owner's identity key.
+type SignedPrekey struct {
+	ID        uint32
+	Public    [32]byte
+	Signature [ed25519.SignatureSize]byte
+}
+
+// A OneTimePrekey is the public half of a prekey that is used for at
+// most one key agreement.
+type OneTimePrekey struct {
+	ID     uint32
+	Public [32]byte
+}
+
+// Prekeys holds the private prekeys belonging to an Identity, and
+// answers initial messages sent to it. It isn't safe for concurrent
+// use.
+type Prekeys struct {
+	id      *sessions.Identity
+	signed  map[uint32]*[32]byte
+	current SignedPrekey
+	oneTime map[uint32]*[32]byte
+	nextID  uint32
+}
+
+// NewPrekeys creates the prekey store for the Identity, with a new
+// signed prekey.
+func NewPrekeys(id *sessions.Identity) (*Prekeys, error) {
+	p := &Prekeys{
+		id:      id,
+		signed:  map[uint32]*[32]byte{},
+		oneTime: map[uint32]*[32]byte{},
+		nextID:  1,
+	}
+
+	if err := p.RotateSignedPrekey(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RotateSignedPrekey replaces the current signed prekey with a new
+// one. The old private key is kept so that initial messages already in
+// flight can still be answered; DeleteSignedPrekey removes it.
+func (p *Prekeys) RotateSignedPrekey() error {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	spk := SignedPrekey{ID: p.nextID, Public: *pub}
+	spk.Signature = *p.id.Sign(signedPrekeyData(pub))
+	p.nextID++
+
+	p.signed[spk.ID] = priv
+	p.current = spk
+	return nil
+}
+
+// DeleteSignedPrekey removes an old signed prekey. The current signed
+// prekey can't be deleted.
+func (p *Prekeys) DeleteSignedPrekey(id uint32) {
+	if id == p.current.ID {
+		return
+	}
+
+	if priv, ok := p.signed[id]; ok {
+		util.Zero(priv[:])
+		delete(p.signed, id)
+	}
+}
+
+// SignedPrekey returns the current signed prekey for publication.
+func (p *Prekeys) SignedPrekey() SignedPrekey {
+	return p.current
+}
+
+// GenerateOneTimePrekeys creates n new one-time prekeys, returning the
+// public halves for publication.
+func (p *Prekeys) GenerateOneTimePrekeys(n int) ([]OneTimePrekey, error) {
+	out := make([]OneTimePrekey, 0, n)
+	for i := 0; i < n; i++ {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		opk := OneTimePrekey{ID: p.nextID, Public: *pub}
+		p.nextID++
+		p.oneTime[opk.ID] = priv
+		out = append(out, opk)
+	}
+	return out, nil
+}
+
+// Respond processes an initial message, returning the decrypted first
+// message and the agreement. The one-time prekey used, if any, is
+// deleted, so the message can't be replayed; a message that didn't use
+// a one-time prekey can be. If the Identity has a PeerLookup function,
+// the sender must pass it.
+func (p *Prekeys) Respond(m *InitialMessage) ([]byte, *Agreement, error) {
+	if p.id.PeerLookup != nil && !p.id.PeerLookup(&m.Identity) {
+		return nil, nil, ErrUntrustedPeer
+	}
+
+	spk, ok := p.signed[m.SignedPrekeyID]
+	if !ok {
+		return nil, nil, ErrUnknownPrekey
+	}
+
+	var opk *[32]byte
+	if m.HasOneTime {
+		opk, ok = p.oneTime[m.OneTimePrekeyID]
+		if !ok {
+			return nil, nil, ErrUnknownPrekey
+		}
+	}
+
+	peerIK, err := sessions.PeerX25519(&m.Identity)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	dh1, err := dh(spk, peerIK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dh2, err := p.id.DH(&m.Ephemeral)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	dh3, err := dh(spk, &m.Ephemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dhs := []*[32]byte{dh1, dh2, dh3}
+	if opk != nil {
+		dh4, err := dh(opk, &m.Ephemeral)
+		if err != nil {
+			return nil, nil, err
+		}
+		dhs = append(dhs, dh4)
+	}
+
+	a := &Agreement{
+		Key:            deriveKey(dhs...),
+		AssociatedData: associatedData(&m.Identity, p.id.Public()),
+		Peer:           m.Identity,
+	}
+
+	gcm := messageCipher(a)
+	if len(m.Ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		a.Zero()
+		return nil, nil, ErrDecrypt
+	}
+
+	nonce := m.Ciphertext[:gcm.NonceSize()]
+	out, err := gcm.Open(nil, nonce, m.Ciphertext[gcm.NonceSize():], a.AssociatedData)
+	if err != nil {
+		a.Zero()
+		return nil, nil, ErrDecrypt
+	}
+
+	// The one-time prekey is only consumed once the message has been
+	// authenticated, so a forged message can't exhaust them.
+	if opk != nil {
+		util.Zero(opk[:])
+		delete(p.oneTime, m.OneTimePrekeyID)
+	}
+	return out, a, nil
+}
+
+// Verify checks the signature on the signed prekey against an
+// identity key.
+func (spk *SignedPrekey) Verify(identity *[ed25519.PublicKeySize]byte) bool {
+	sig := spk.Signature
+	return ed25519.Verify(identity, signedPrekeyData(&spk.Public), &sig)
+}
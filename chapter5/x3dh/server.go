@@ -0,0 +1,94 @@
+package x3dh
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/agl/ed25519"
+)
+
+// ErrNoBundle is returned when the server has no prekeys for an
+// identity.
+var ErrNoBundle = errors.New("x3dh: no prekey bundle for identity")
+
+type serverEntry struct {
+	signed  SignedPrekey
+	oneTime []OneTimePrekey
+}
+
+// A Server is an in-memory stand-in for a prekey server. It stores
+// each identity's published prekeys and hands out bundles, giving each
+// one-time prekey to at most one sender. It is safe for concurrent
+// use.
+type Server struct {
+	lock    sync.Mutex
+	entries map[[ed25519.PublicKeySize]byte]*serverEntry
+}
+
+// NewServer returns an empty prekey server.
+func NewServer() *Server {
+	return &Server{entries: map[[ed25519.PublicKeySize]byte]*serverEntry{}}
+}
+
+// Publish stores the identity's signed prekey, replacing any previous
+// one, and adds the one-time prekeys. The signature is checked so the
+// server doesn't hand out bundles that senders will reject.
+func (s *Server) Publish(identity *[ed25519.PublicKeySize]byte, spk SignedPrekey, oneTime []OneTimePrekey) error {
+	if !spk.Verify(identity) {
+		return ErrInvalidSignature
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[*identity]
+	if !ok {
+		e = &serverEntry{}
+		s.entries[*identity] = e
+	}
+
+	e.signed = spk
+	e.oneTime = append(e.oneTime, oneTime...)
+	return nil
+}
+
+// Remaining returns the number of one-time prekeys left for the
+// identity, so that its owner knows when to upload more.
+func (s *Server) Remaining(identity *[ed25519.PublicKeySize]byte) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.entries[*identity]; ok {
+		return len(e.oneTime)
+	}
+	return 0
+}
+
+// Fetch returns a bundle for the identity, removing the one-time
+// prekey it contains. Once the one-time prekeys run out, bundles only
+// contain the signed prekey.
+func (s *Server) Fetch(identity *[ed25519.PublicKeySize]byte) (*Bundle, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[*identity]
+	if !ok {
+		return nil, ErrNoBundle
+	}
+
+	b := &Bundle{
+		Identity:       *identity,
+		SignedPrekeyID: e.signed.ID,
+		SignedPrekey:   e.signed.Public,
+		Signature:      e.signed.Signature,
+	}
+
+	if len(e.oneTime) > 0 {
+		opk := e.oneTime[0]
+		e.oneTime = e.oneTime[1:]
+		b.OneTimePrekeyID = opk.ID
+		b.OneTimePrekey = new([32]byte)
+		*b.OneTimePrekey = opk.Public
+	}
+	return b, nil
+}
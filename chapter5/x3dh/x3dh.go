@@ -0,0 +1,210 @@
+// Package x3dh implements the Extended Triple Diffie-Hellman key
+// agreement, which lets a sender start an encrypted conversation with
+// a recipient who is offline.
+//
+// The recipient (Bob) publishes a prekey bundle to a server: his
+// identity key, a signed prekey, and a set of one-time prekeys. The
+// identity key is a sessions Identity; its Ed25519 key signs the
+// signed prekey, and is mapped to Curve25519 for key agreement. The
+// sender (Alice) fetches a bundle, verifies the signature, and computes
+//
+//	DH1 = DH(IK_A, SPK_B)
+//	DH2 = DH(EK_A, IK_B)
+//	DH3 = DH(EK_A, SPK_B)
+//	DH4 = DH(EK_A, OPK_B)    (if a one-time prekey was available)
+//	SK  = HKDF(0xFF * 32 || DH1 || DH2 || DH3 [|| DH4])
+//
+// Her initial message carries her identity key, the ephemeral key
+// EK_A, the IDs of the prekeys she used, and a first message encrypted
+// under SK with both identity keys as additional data. Bob repeats the
+// computation with his private keys, and deletes the one-time prekey so
+// it can't be used again.
+package x3dh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	aesgcm "git.metacircular.net/kyle/gocrypto/chapter3/aesgcm"
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"git.metacircular.net/kyle/gocrypto/util"
+	"github.com/agl/ed25519"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// KeySize is the size of the agreed key.
+	KeySize = 32
+
+	kdfInfo       = "x3dh gocrypto"
+	messageInfo   = "x3dh initial message"
+	prekeyContext = "x3dh signed prekey"
+)
+
+var (
+	// ErrEncrypt is returned when the initial message can't be
+	// encrypted.
+	ErrEncrypt = errors.New("x3dh: encryption failed")
+
+	// ErrDecrypt is returned when the initial message can't be
+	// decrypted.
+	ErrDecrypt = errors.New("x3dh: decryption failed")
+
+	// ErrInvalidSignature is returned when a bundle's signed prekey
+	// isn't signed by its identity key.
+	ErrInvalidSignature = errors.New("x3dh: invalid prekey signature")
+
+	// ErrInvalidKey is returned when a key agreement fails because of
+	// an invalid public key.
+	ErrInvalidKey = errors.New("x3dh: invalid key")
+
+	// ErrUnknownPrekey is returned when an initial message refers to a
+	// prekey that doesn't exist or has already been used.
+	ErrUnknownPrekey = errors.New("x3dh: unknown prekey")
+
+	// ErrUntrustedPeer is returned when the Identity's PeerLookup
+	// rejects the sender of an initial message.
+	ErrUntrustedPeer = errors.New("x3dh: untrusted peer")
+)
+
+// An Agreement is the result of X3DH: the shared key, and the
+// associated data binding both parties' identities, which should be
+// used as additional data for the rest of the conversation.
+type Agreement struct {
+	Key            [KeySize]byte
+	AssociatedData []byte
+	Peer           [ed25519.PublicKeySize]byte
+}
+
+// Zero wipes the shared key.
+func (a *Agreement) Zero() {
+	util.Zero(a.Key[:])
+}
+
+// signedPrekeyData is the data signed by the identity key.
+func signedPrekeyData(spk *[32]byte) []byte {
+	out := make([]byte, 0, len(prekeyContext)+len(spk))
+	out = append(out, prekeyContext...)
+	return append(out, spk[:]...)
+}
+
+// associatedData is IK_A || IK_B.
+func associatedData(initiator, responder *[ed25519.PublicKeySize]byte) []byte {
+	out := make([]byte, 0, 2*ed25519.PublicKeySize)
+	out = append(out, initiator[:]...)
+	return append(out, responder[:]...)
+}
+
+// deriveKey runs HKDF over the concatenated DH outputs, prefixed with
+// 32 0xFF bytes as the X3DH specification requires for X25519.
+func deriveKey(dhs ...*[32]byte) [KeySize]byte {
+	ikm := make([]byte, 32, 32+32*len(dhs))
+	for i := range ikm {
+		ikm[i] = 0xff
+	}
+	for _, dh := range dhs {
+		ikm = append(ikm, dh[:]...)
+		util.Zero(dh[:])
+	}
+	defer util.Zero(ikm)
+
+	var key [KeySize]byte
+	salt := make([]byte, sha256.Size)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte(kdfInfo)), key[:])
+	return key
+}
+
+func dh(priv, pub *[32]byte) (*[32]byte, error) {
+	out, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	shared := new([32]byte)
+	copy(shared[:], out)
+	util.Zero(out)
+	return shared, nil
+}
+
+// messageCipher returns the AEAD for the initial message, keyed by a
+// key derived from the agreed key.
+func messageCipher(a *Agreement) cipher.AEAD {
+	key := make([]byte, aesgcm.KeySize)
+	io.ReadFull(hkdf.New(sha256.New, a.Key[:], nil, []byte(messageInfo)), key)
+	defer util.Zero(key)
+
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+// Initiate runs X3DH against a prekey bundle, returning the initial
+// message to send to the bundle's owner and the agreement. The message
+// is encrypted into the initial message.
+func Initiate(id *sessions.Identity, bundle *Bundle, message []byte) (*InitialMessage, *Agreement, error) {
+	if !bundle.Verify() {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	peerIK, err := sessions.PeerX25519(&bundle.Identity)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	ekPub, ekPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, ErrEncrypt
+	}
+	defer util.Zero(ekPriv[:])
+
+	dh1, err := id.DH(&bundle.SignedPrekey)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	dh2, err := dh(ekPriv, peerIK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dh3, err := dh(ekPriv, &bundle.SignedPrekey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dhs := []*[32]byte{dh1, dh2, dh3}
+	if bundle.OneTimePrekey != nil {
+		dh4, err := dh(ekPriv, bundle.OneTimePrekey)
+		if err != nil {
+			return nil, nil, err
+		}
+		dhs = append(dhs, dh4)
+	}
+
+	a := &Agreement{
+		Key:            deriveKey(dhs...),
+		AssociatedData: associatedData(id.Public(), &bundle.Identity),
+		Peer:           bundle.Identity,
+	}
+
+	nonce, err := util.RandBytes(aesgcm.NonceSize)
+	if err != nil {
+		return nil, nil, ErrEncrypt
+	}
+
+	m := &InitialMessage{
+		Identity:        *id.Public(),
+		Ephemeral:       *ekPub,
+		SignedPrekeyID:  bundle.SignedPrekeyID,
+		OneTimePrekeyID: bundle.OneTimePrekeyID,
+		HasOneTime:      bundle.OneTimePrekey != nil,
+	}
+	m.Ciphertext = messageCipher(a).Seal(nonce, nonce, message, a.AssociatedData)
+	return m, a, nil
+}
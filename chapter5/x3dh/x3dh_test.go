@@ -0,0 +1,183 @@
+package x3dh
+
+import (
+	"bytes"
+	"testing"
+
+	"git.metacircular.net/kyle/gocrypto/chapter5/sessions"
+	"github.com/agl/ed25519"
+)
+
+var testMessage = []byte("do not go gentle into that good night")
+
+// setup creates Bob's identity and prekeys, and publishes them to a
+// new server.
+func setup(t *testing.T, oneTime int) (*sessions.Identity, *Prekeys, *Server) {
+	bob, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	prekeys, err := NewPrekeys(bob)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	opks, err := prekeys.GenerateOneTimePrekeys(oneTime)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	server := NewServer()
+	if err = server.Publish(bob.Public(), prekeys.SignedPrekey(), opks); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return bob, prekeys, server
+}
+
+// exchange has Alice fetch Bob's bundle and send an initial message,
+// passing both through their serialised forms.
+func exchange(t *testing.T, alice, bob *sessions.Identity, server *Server) (*InitialMessage, *Agreement) {
+	b, err := server.Fetch(bob.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	b, err = ParseBundle(b.Marshal())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m, a, err := Initiate(alice, b, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m, err = ParseInitialMessage(m.Marshal())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return m, a
+}
+
+func TestX3DH(t *testing.T) {
+	alice, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, prekeys, server := setup(t, 1)
+	for _, hasOneTime := range []bool{true, false} {
+		m, aliceAgreement := exchange(t, alice, bob, server)
+		if m.HasOneTime != hasOneTime {
+			t.Fatal("one-time prekey use doesn't match the server's supply")
+		}
+
+		out, bobAgreement, err := prekeys.Respond(m)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(out, testMessage) {
+			t.Fatal("recovered message doesn't match original")
+		}
+
+		if aliceAgreement.Key != bobAgreement.Key {
+			t.Fatal("agreed keys don't match")
+		}
+
+		if !bytes.Equal(aliceAgreement.AssociatedData, bobAgreement.AssociatedData) {
+			t.Fatal("associated data doesn't match")
+		}
+
+		if bobAgreement.Peer != *alice.Public() || aliceAgreement.Peer != *bob.Public() {
+			t.Fatal("wrong peer identities")
+		}
+
+		// A message using a one-time prekey can't be replayed.
+		_, _, err = prekeys.Respond(m)
+		if hasOneTime && err != ErrUnknownPrekey {
+			t.Fatal("expected a replayed initial message to fail")
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	alice, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, prekeys, server := setup(t, 0)
+	m, _ := exchange(t, alice, bob, server)
+	old := prekeys.SignedPrekey().ID
+	if err = prekeys.RotateSignedPrekey(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Messages sent to the old signed prekey can still be answered
+	// until it's deleted.
+	if _, _, err = prekeys.Respond(m); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	prekeys.DeleteSignedPrekey(old)
+	if _, _, err = prekeys.Respond(m); err != ErrUnknownPrekey {
+		t.Fatal("expected a message to a deleted prekey to fail")
+	}
+}
+
+func TestRejections(t *testing.T) {
+	alice, err := sessions.NewIdentity()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	bob, prekeys, server := setup(t, 2)
+
+	b, err := server.Fetch(bob.Public())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A bundle whose signed prekey has been swapped is rejected.
+	forged := *b
+	forged.SignedPrekey[0] ^= 1
+	if _, _, err = Initiate(alice, &forged, testMessage); err != ErrInvalidSignature {
+		t.Fatal("expected a bundle with a bad signature to fail")
+	}
+
+	if err = server.Publish(alice.Public(), prekeys.SignedPrekey(), nil); err != ErrInvalidSignature {
+		t.Fatal("expected the server to reject a prekey signed by another identity")
+	}
+
+	m, _, err := Initiate(alice, b, testMessage)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m.Ciphertext[len(m.Ciphertext)-1] ^= 1
+	if _, _, err = prekeys.Respond(m); err != ErrDecrypt {
+		t.Fatal("expected a modified initial message to fail")
+	}
+	m.Ciphertext[len(m.Ciphertext)-1] ^= 1
+
+	bob.PeerLookup = func(*[ed25519.PublicKeySize]byte) bool { return false }
+	if _, _, err = prekeys.Respond(m); err != ErrUntrustedPeer {
+		t.Fatal("expected a message from an untrusted peer to fail")
+	}
+
+	bob.PeerLookup = nil
+	if _, _, err = prekeys.Respond(m); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if server.Remaining(bob.Public()) != 1 {
+		t.Fatal("server handed out the wrong number of one-time prekeys")
+	}
+
+	if _, err = ParseBundle(b.Marshal()[1:]); err != ErrMalformed {
+		t.Fatal("expected a truncated bundle to fail")
+	}
+}
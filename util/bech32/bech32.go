@@ -0,0 +1,151 @@
+// Package bech32 implements the BIP 173 Bech32 encoding as used by age
+// for its keys. Unlike BIP 173, the 90 character limit isn't enforced,
+// since age secret keys are longer than Bitcoin addresses.
+package bech32
+
+import (
+	"errors"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var (
+	// ErrInvalid is returned when a string isn't valid Bech32.
+	ErrInvalid = errors.New("bech32: invalid string")
+
+	// ErrChecksum is returned when the checksum doesn't match.
+	ErrChecksum = errors.New("bech32: invalid checksum")
+)
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// hrpExpand prepares the human-readable part for the checksum.
+func hrpExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from groups of from bits to groups of to
+// bits. When decoding, the padding must be short and zero.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	var out []byte
+	var acc uint32
+	var bits uint
+	max := uint32(1)<<to - 1
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, false
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte((acc>>bits)&max))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte((acc<<(to-bits))&max))
+		}
+	} else if bits >= from || (acc<<(to-bits))&max != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+func validHRP(hrp string) bool {
+	if len(hrp) == 0 {
+		return false
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode returns the Bech32 encoding of data with the human-readable
+// part hrp. If hrp is upper case, so is the result.
+func Encode(hrp string, data []byte) (string, error) {
+	lower := strings.ToLower(hrp)
+	upper := strings.ToUpper(hrp)
+	if !validHRP(hrp) || (hrp != lower && hrp != upper) {
+		return "", ErrInvalid
+	}
+
+	values, _ := convertBits(data, 8, 5, true)
+	chk := polymod(append(append(hrpExpand(lower), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+	b.WriteString(lower)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(charset[(chk>>uint(5*(5-i)))&31])
+	}
+
+	if hrp == upper && hrp != lower {
+		return strings.ToUpper(b.String()), nil
+	}
+	return b.String(), nil
+}
+
+// Decode parses a Bech32 string, returning the human-readable part as
+// it appears in s and the decoded data. Mixed case strings are
+// rejected.
+func Decode(s string) (string, []byte, error) {
+	lower := strings.ToLower(s)
+	if s != lower && s != strings.ToUpper(s) {
+		return "", nil, ErrInvalid
+	}
+
+	pos := strings.LastIndexByte(lower, '1')
+	if pos < 1 || pos+7 > len(s) || !validHRP(s[:pos]) {
+		return "", nil, ErrInvalid
+	}
+
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(lower); i++ {
+		d := strings.IndexByte(charset, lower[i])
+		if d < 0 {
+			return "", nil, ErrInvalid
+		}
+		values = append(values, byte(d))
+	}
+
+	if polymod(append(hrpExpand(lower[:pos]), values...)) != 1 {
+		return "", nil, ErrChecksum
+	}
+
+	data, ok := convertBits(values[:len(values)-6], 5, 8, false)
+	if !ok {
+		return "", nil, ErrInvalid
+	}
+	return s[:pos], data, nil
+}
@@ -0,0 +1,70 @@
+package bech32
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Valid checksums from BIP 173.
+var validStrings = []string{
+	"A12UEL5L",
+	"a12uel5l",
+	"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+	"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+}
+
+func TestValid(t *testing.T) {
+	for _, s := range validStrings {
+		if _, _, err := Decode(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	invalid := []string{
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty HRP
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // checksum too short
+		"A1G7SGD8",      // checksum calculated with upper case HRP
+		"A12uEL5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // bad checksum
+	}
+
+	for _, s := range invalid {
+		if _, _, err := Decode(s); err == nil {
+			t.Fatalf("%s: expected decoding to fail", s)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte("do not go gentle into that good night")
+	for _, hrp := range []string{"age", "AGE-SECRET-KEY-"} {
+		s, err := Encode(hrp, data)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if hrp == "age" && s != strings.ToLower(s) {
+			t.Fatal("expected a lower case encoding")
+		} else if hrp != "age" && s != strings.ToUpper(s) {
+			t.Fatal("expected an upper case encoding")
+		}
+
+		outHRP, out, err := Decode(s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if outHRP != hrp || !bytes.Equal(out, data) {
+			t.Fatal("decoded data doesn't match original")
+		}
+	}
+
+	if _, err := Encode("Age", data); err == nil {
+		t.Fatal("expected a mixed case HRP to fail")
+	}
+}